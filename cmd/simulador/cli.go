@@ -4,7 +4,11 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/arielril/network-simulator/internal/generate"
+	"github.com/arielril/network-simulator/internal/grade"
+	"github.com/arielril/network-simulator/internal/output"
 	"github.com/arielril/network-simulator/internal/simulator"
+	"github.com/arielril/network-simulator/internal/transcript"
 
 	"github.com/urfave/cli"
 )
@@ -18,8 +22,235 @@ func getCliApp() *cli.App {
 	app := cli.NewApp()
 	app.Name = "Network Simulator"
 	app.Usage = "Let's you run a simulation inside a topology. Two nodes sending messages"
-	app.UsageText = "simulador [path/to/topology/file] [src_node] [dst_node] [message]"
+	app.UsageText = "simulador [--format name] [--show-routing] [--down router:port[@seconds]] [path/to/topology/file] [src_node] [dst_node] [message]"
+	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:  "format, f",
+			Value: output.TEXT,
+			Usage: fmt.Sprintf("output format, one of %v", output.Formats()),
+		},
+		cli.BoolFlag{
+			Name:  "show-routing",
+			Usage: "print the exchanges of the routing protocols before the ping",
+		},
+		cli.StringSliceFlag{
+			Name:  "down",
+			Usage: "take the link of a router port down, at the time in seconds or once the routing converges",
+		},
+	}
 	app.Action = simulator.Run
+	app.Commands = []cli.Command{
+		{
+			Name:      "graph",
+			Usage:     "Print the topology as a Graphviz (DOT) graph, with the path of a ping over it",
+			UsageText: "simulador graph [path/to/topology/file] [src_node dst_node message]",
+			Action:    simulator.Graph,
+		},
+		{
+			Name:      "grade",
+			Usage:     "Compare the output of another simulator with the output of this one",
+			UsageText: "simulador grade [--ignore-whitespace] [path/to/topology/file] [src_node] [dst_node] [message] [path/to/candidate/output]",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "ignore-whitespace, w",
+					Usage: "lines that only differ by blanks are considered a match",
+				},
+			},
+			Action: grade.Grade,
+		},
+		{
+			Name:      "routes",
+			Usage:     "Run the routing protocols and print the router tables, with the source of each route",
+			UsageText: "simulador routes [--show-routing] [--down router:port[@seconds]] [path/to/topology/file] [router]",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "show-routing",
+					Usage: "print the exchanges of the routing protocols before the router tables",
+				},
+				cli.StringSliceFlag{
+					Name:  "down",
+					Usage: "take the link of a router port down, at the time in seconds or once the routing converges",
+				},
+			},
+			Action: simulator.Routes,
+		},
+		{
+			Name:      "analyze",
+			Usage:     "Follow the router tables without sending packets, reporting routing loops, black holes and nodes that can not reach each other",
+			UsageText: "simulador analyze [--down router:port[@seconds]] [path/to/topology/file]",
+			Flags: []cli.Flag{
+				cli.StringSliceFlag{
+					Name:  "down",
+					Usage: "take the link of a router port down, at the time in seconds or once the routing converges",
+				},
+			},
+			Action: simulator.Analyze,
+		},
+		{
+			Name:      "matrix",
+			Usage:     "Ping every node from every other node and print the outcomes as a matrix",
+			UsageText: "simulador matrix [--format table|csv|json] [--shared] [--jobs n] [--message text] [--down router:port[@seconds]] [path/to/topology/file]",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "format, f",
+					Value: simulator.MATRIX_TABLE,
+					Usage: "output format, one of table, csv or json",
+				},
+				cli.BoolFlag{
+					Name:  "shared",
+					Usage: "run every ping on the same environment, keeping the ARP tables, instead of a new one for each ping",
+				},
+				cli.IntFlag{
+					Name:  "jobs, j",
+					Usage: "number of pings run at the same time on new environments, all the CPUs when 0",
+				},
+				cli.StringFlag{
+					Name:  "message, m",
+					Value: "hello",
+					Usage: "message of the pings",
+				},
+				cli.StringSliceFlag{
+					Name:  "down",
+					Usage: "take the link of a router port down, at the time in seconds or once the routing converges",
+				},
+			},
+			Action: simulator.Matrix,
+		},
+		{
+			Name:      "ospf",
+			Usage:     "Run the routing protocols and print the link state database, SPF tree and routes of the routers running OSPF",
+			UsageText: "simulador ospf [--show-routing] [--down router:port[@seconds]] [path/to/topology/file] [router]",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "show-routing",
+					Usage: "print the exchanges of the routing protocols before the link state",
+				},
+				cli.StringSliceFlag{
+					Name:  "down",
+					Usage: "take the link of a router port down, at the time in seconds or once the routing converges",
+				},
+			},
+			Action: simulator.Ospf,
+		},
+		{
+			Name:      "bgp",
+			Usage:     "Run the routing protocols and print the sessions and paths of the routers running BGP",
+			UsageText: "simulador bgp [--show-routing] [--down router:port[@seconds]] [path/to/topology/file] [router]",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "show-routing",
+					Usage: "print the exchanges of the routing protocols before the paths",
+				},
+				cli.StringSliceFlag{
+					Name:  "down",
+					Usage: "take the link of a router port down, at the time in seconds or once the routing converges",
+				},
+			},
+			Action: simulator.Bgp,
+		},
+		{
+			Name:      "generate",
+			Usage:     "Print a generated topology of a shape, with a route on every router to every network",
+			UsageText: "simulador generate [--routers n] [--depth n] [--fanout n] [--k n] [--extra-links n] [--nodes n] [--mtu tier=value] [--seed n] [--loops n] [line|ring|star|tree|mesh|fat-tree|random]",
+			Flags: []cli.Flag{
+				cli.IntFlag{
+					Name:  "routers, r",
+					Value: 4,
+					Usage: "number of routers of the line, ring, star, mesh and random shapes",
+				},
+				cli.IntFlag{
+					Name:  "depth",
+					Value: 2,
+					Usage: "levels below the root of the tree",
+				},
+				cli.IntFlag{
+					Name:  "fanout",
+					Value: 2,
+					Usage: "children of each router of the tree",
+				},
+				cli.IntFlag{
+					Name:  "k",
+					Value: 4,
+					Usage: "pods of the fat-tree, an even number",
+				},
+				cli.IntFlag{
+					Name:  "extra-links",
+					Usage: "links added between random routers of the random shape, half the routers when not set",
+				},
+				cli.IntFlag{
+					Name:  "nodes, n",
+					Value: 1,
+					Usage: "nodes on the network of each router with nodes",
+				},
+				cli.StringSliceFlag{
+					Name:  "mtu",
+					Usage: fmt.Sprintf("MTU of the networks of a tier as tier=value, with the tiers %v", generate.Tiers()),
+				},
+				cli.Int64Flag{
+					Name:  "seed",
+					Value: 1,
+					Usage: "seed of the random shape and of the loops",
+				},
+				cli.IntFlag{
+					Name:  "loops",
+					Usage: "routing loops added to the router tables on purpose",
+				},
+			},
+			Action: generate.Command,
+		},
+		{
+			Name:      "export",
+			Usage:     "Print the topology with the MACs and addresses chosen for its auto placeholders",
+			UsageText: "simulador export [path/to/topology/file]",
+			Action:    simulator.Export,
+		},
+		{
+			Name:      "fmt",
+			Usage:     "Format topology files in place, keeping their comments",
+			UsageText: "simulador fmt [--check] [path/to/topology/file...]",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "check",
+					Usage: "only print the files that are not formatted, exiting with 1 when there are any",
+				},
+			},
+			Action: simulator.Fmt,
+		},
+		{
+			Name:      "diff",
+			Usage:     "Print the nodes, router ports and routes that changed between two topologies, and the pings whose outcome, path or fragments changed",
+			UsageText: "simulador diff [--jobs n] [--message text] [--down router:port[@seconds]] [path/to/old/topology] [path/to/new/topology]",
+			Flags: []cli.Flag{
+				cli.IntFlag{
+					Name:  "jobs, j",
+					Usage: "number of pings run at the same time, all the CPUs when 0",
+				},
+				cli.StringFlag{
+					Name:  "message, m",
+					Value: "hello",
+					Usage: "message of the pings",
+				},
+				cli.StringSliceFlag{
+					Name:  "down",
+					Usage: "take the link of a router port down on both topologies, at the time in seconds or once the routing converges",
+				},
+			},
+			Action: simulator.Diff,
+		},
+		{
+			Name:      "convert",
+			Usage:     "Convert a transcript printed by the simulator to another output format",
+			UsageText: "simulador convert [--format name] [path/to/transcript]",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "format, f",
+					Value: output.JSON,
+					Usage: fmt.Sprintf("output format, one of %v", output.Formats()),
+				},
+			},
+			Action: transcript.Convert,
+		},
+	}
 
 	return app
 }
@@ -29,6 +260,7 @@ func main() {
 	appErr := app.Run(os.Args)
 
 	if appErr != nil {
-		panic(fmt.Sprintf("Failed to run cli: %v", appErr))
+		fmt.Fprintf(os.Stderr, "Error: %v\n", appErr)
+		os.Exit(exitCode(appErr))
 	}
 }
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"os"
+
+	"github.com/arielril/network-simulator/internal/simulator"
+	"github.com/arielril/network-simulator/internal/transcript"
+	"github.com/urfave/cli"
+)
+
+// Exit codes of the simulador command, by category of the error
+const (
+	EXIT_OK      int = 0
+	EXIT_FAILURE int = 1
+	// Invalid arguments, or nodes that are not on the topology
+	EXIT_USAGE int = 2
+	// The topology or transcript file can not be read
+	EXIT_FILE int = 3
+	// The topology or transcript file is malformed
+	EXIT_INVALID_INPUT int = 4
+	// The simulation can not deliver the frames: missing gateway, missing route
+	// or unanswered ARP request
+	EXIT_SIMULATION int = 5
+)
+
+// exitCode returns the exit code for the category of the error
+func exitCode(err error) int {
+	var (
+		exitErr     cli.ExitCoder
+		usageErr    *simulator.UsageError
+		nodeErr     *simulator.UnknownNodeError
+		pathErr     *os.PathError
+		topologyErr *simulator.TopologyError
+		parseErrs   transcript.ParseErrors
+		gatewayErr  *simulator.NoGatewayError
+		routeErr    *simulator.NoRouteError
+		arpErr      *simulator.ArpError
+	)
+
+	switch {
+	case err == nil:
+		return EXIT_OK
+	case errors.As(err, &exitErr):
+		return exitErr.ExitCode()
+	case errors.As(err, &usageErr), errors.As(err, &nodeErr):
+		return EXIT_USAGE
+	case errors.As(err, &pathErr):
+		return EXIT_FILE
+	case errors.As(err, &topologyErr), errors.As(err, &parseErrs):
+		return EXIT_INVALID_INPUT
+	case errors.As(err, &gatewayErr), errors.As(err, &routeErr), errors.As(err, &arpErr):
+		return EXIT_SIMULATION
+	}
+	return EXIT_FAILURE
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/arielril/network-simulator/internal/simulator"
+	"github.com/arielril/network-simulator/internal/transcript"
+	"github.com/urfave/cli"
+)
+
+func TestExitCode(t *testing.T) {
+	cases := []struct {
+		err  error
+		code int
+	}{
+		{nil, EXIT_OK},
+		{errors.New("unknown"), EXIT_FAILURE},
+		{cli.NewExitError("", 1), 1},
+		{&simulator.UsageError{Msg: "Invalid arguments"}, EXIT_USAGE},
+		{&simulator.UnknownNodeError{Name: "n9", Role: "source"}, EXIT_USAGE},
+		{fmt.Errorf("Failed to read topology: %w", &os.PathError{Op: "open", Path: "x", Err: os.ErrNotExist}), EXIT_FILE},
+		{&simulator.TopologyError{Line: 1, Msg: "Invalid MAC address"}, EXIT_INVALID_INPUT},
+		{fmt.Errorf("Invalid transcript:\n%w", transcript.ParseErrors{}), EXIT_INVALID_INPUT},
+		{&simulator.NoGatewayError{Node: "n1", Gateway: "10.0.0.1"}, EXIT_SIMULATION},
+		{&simulator.NoRouteError{Router: "r1", Dest: "10.0.0.2"}, EXIT_SIMULATION},
+		{&simulator.ArpError{Device: "r1", Ip: "10.0.0.2"}, EXIT_SIMULATION},
+	}
+
+	for _, tc := range cases {
+		if code := exitCode(tc.err); code != tc.code {
+			t.Errorf("%#v: expected exit code %v, got %v", tc.err, tc.code, code)
+		}
+	}
+}
+
+// The errors of the commands are mapped to the exit code of their category
+func TestCommandExitCode(t *testing.T) {
+	cases := []struct {
+		args []string
+		code int
+	}{
+		{[]string{"--format", "bogus", "../../examples/example1.txt", "n1", "n4", "hello"}, EXIT_USAGE},
+		{[]string{"../../examples/example1.txt", "n1", "n9", "hello"}, EXIT_USAGE},
+		{[]string{"../../examples/missing.txt", "n1", "n4", "hello"}, EXIT_FILE},
+	}
+
+	for _, tc := range cases {
+		err := getCliApp().Run(append([]string{"simulador"}, tc.args...))
+		if code := exitCode(err); code != tc.code {
+			t.Errorf("%v: expected exit code %v, got %v (%v)", tc.args, tc.code, code, err)
+		}
+	}
+}
@@ -1,9 +1,8 @@
 module github.com/arielril/network-simulator
 
-go 1.13
+go 1.16
 
 require (
 	github.com/asaskevich/govalidator v0.0.0-20190424111038-f61b66f89f4a
-	github.com/novalagung/gubrak v0.0.0-20190315172014-149e14d37ef6
 	github.com/urfave/cli v1.22.1
 )
@@ -0,0 +1,191 @@
+package event
+
+import (
+	"fmt"
+	"io"
+)
+
+// Kind identifies what happened in one line of a simulation transcript
+type Kind uint8
+
+const (
+	ARP_REQUEST Kind = iota + 1
+	ARP_REPLY
+	ECHO_REQUEST
+	ECHO_REPLY
+	TIME_EXCEEDED
+	RECEIVED
+	// Routing protocols
+	RIP_RESPONSE
+	LINK_DOWN
+	OSPF_HELLO
+	OSPF_LSU
+	BGP_UPDATE
+	// Frames sent by a switch to the port of a learned MAC, or to every port
+	SWITCH_FORWARD
+	SWITCH_FLOOD
+)
+
+var kindNames = map[Kind]string{
+	ARP_REQUEST:    "arp_request",
+	ARP_REPLY:      "arp_reply",
+	ECHO_REQUEST:   "echo_request",
+	ECHO_REPLY:     "echo_reply",
+	TIME_EXCEEDED:  "time_exceeded",
+	RECEIVED:       "received",
+	RIP_RESPONSE:   "rip_response",
+	LINK_DOWN:      "link_down",
+	OSPF_HELLO:     "ospf_hello",
+	OSPF_LSU:       "ospf_lsu",
+	BGP_UPDATE:     "bgp_update",
+	SWITCH_FORWARD: "switch_forward",
+	SWITCH_FLOOD:   "switch_flood",
+}
+
+func (k Kind) String() string {
+	if name, ok := kindNames[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("kind(%d)", uint8(k))
+}
+
+func (k Kind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+func (k *Kind) UnmarshalText(text []byte) error {
+	for kind, name := range kindNames {
+		if name == string(text) {
+			*k = kind
+			return nil
+		}
+	}
+	return fmt.Errorf("Unknown event kind %q", text)
+}
+
+// Event is a single frame (or final processing step) seen during a simulation
+type Event struct {
+	Kind Kind `json:"kind"`
+	// Name of the component sending the frame
+	Src string `json:"src"`
+	// Name of the component receiving the frame (empty for ARP requests)
+	Dst    string `json:"dst,omitempty"`
+	SrcMac string `json:"src_mac,omitempty"`
+	DstMac string `json:"dst_mac,omitempty"`
+	// VLAN tag of the frame, 0 when it is not tagged
+	Vlan  uint16 `json:"vlan,omitempty"`
+	SrcIp string `json:"src_ip,omitempty"`
+	DstIp string `json:"dst_ip,omitempty"`
+	Ttl   uint8  `json:"ttl,omitempty"`
+	Mf    uint8  `json:"mf"`
+	Off   uint8  `json:"off"`
+	Data  string `json:"data,omitempty"`
+	// Seconds since the start of the routing protocols, for their events
+	Time int `json:"time,omitempty"`
+}
+
+// Sink receives the events generated by a simulation
+type Sink interface {
+	Emit(ev Event)
+}
+
+// Operator returns the MsGenny arc operator of the event
+func (ev Event) Operator() string {
+	switch ev.Kind {
+	case ARP_REQUEST:
+		return "box"
+	case RECEIVED:
+		return "rbox"
+	case LINK_DOWN:
+		return "abox"
+	}
+	return "=>"
+}
+
+// Arc returns the MsGenny arc of the event, e.g. "n1 => n2"
+func (ev Event) Arc() string {
+	participants := ev.Participants()
+	return fmt.Sprintf(
+		"%v %v %v",
+		participants[0], ev.Operator(), participants[len(participants)-1],
+	)
+}
+
+// Label returns the text written after the arc, without the final ";"
+func (ev Event) Label() string {
+	eth := fmt.Sprintf("ETH (src=%v dst=%v)", ev.SrcMac, ev.DstMac)
+	if ev.Vlan != 0 {
+		eth = fmt.Sprintf("ETH (src=%v dst=%v vlan=%v)", ev.SrcMac, ev.DstMac, ev.Vlan)
+	}
+	ip := fmt.Sprintf(
+		"IP (src=%v dst=%v ttl=%v mf=%v off=%v)",
+		ev.SrcIp, ev.DstIp, ev.Ttl, ev.Mf, ev.Off,
+	)
+
+	switch ev.Kind {
+	case ARP_REQUEST:
+		return fmt.Sprintf("%v \\n ARP - Who has %v? Tell %v", eth, ev.DstIp, ev.SrcIp)
+	case ARP_REPLY:
+		return fmt.Sprintf("%v \\n ARP - %v is at %v", eth, ev.SrcIp, ev.SrcMac)
+	case ECHO_REQUEST:
+		return fmt.Sprintf("%v \\n %v \\n ICMP - Echo request (data=%v)", eth, ip, ev.Data)
+	case ECHO_REPLY:
+		return fmt.Sprintf("%v \\n %v \\n ICMP - Echo reply (data=%v)", eth, ip, ev.Data)
+	case TIME_EXCEEDED:
+		return fmt.Sprintf("%v \\n %v \\n ICMP - Time Exceeded", eth, ip)
+	case RECEIVED:
+		return fmt.Sprintf("Received %v", ev.Data)
+	case RIP_RESPONSE:
+		return fmt.Sprintf(
+			"%v \\n %v \\n UDP (src=520 dst=520) \\n RIP v2 - Response at %vs (%v)",
+			eth, ip, ev.Time, ev.Data,
+		)
+	case LINK_DOWN:
+		return fmt.Sprintf("Port %v down at %vs", ev.Data, ev.Time)
+	case SWITCH_FORWARD:
+		return fmt.Sprintf("%v \\n Switch - Forwarded %v", eth, ev.Data)
+	case SWITCH_FLOOD:
+		return fmt.Sprintf("%v \\n Switch - Flooded %v", eth, ev.Data)
+	case OSPF_HELLO:
+		return fmt.Sprintf("%v \\n %v \\n OSPF - Hello at %vs (%v)", eth, ip, ev.Time, ev.Data)
+	case OSPF_LSU:
+		return fmt.Sprintf("%v \\n %v \\n OSPF - LS Update at %vs (%v)", eth, ip, ev.Time, ev.Data)
+	case BGP_UPDATE:
+		return fmt.Sprintf(
+			"%v \\n %v \\n TCP (src=179 dst=179) \\n BGP - Update at %vs (%v)",
+			eth, ip, ev.Time, ev.Data,
+		)
+	}
+	return ""
+}
+
+// String returns the event in the simulator output format
+func (ev Event) String() string {
+	return fmt.Sprintf("%v : %v;", ev.Arc(), ev.Label())
+}
+
+// Participants returns the names of the components that appear in an event
+func (ev Event) Participants() []string {
+	if ev.Kind == ARP_REQUEST || ev.Kind == RECEIVED || ev.Kind == LINK_DOWN || ev.Dst == "" {
+		return []string{ev.Src}
+	}
+	return []string{ev.Src, ev.Dst}
+}
+
+// Recorder is a Sink that keeps every event it receives
+type Recorder struct {
+	Events []Event
+}
+
+func (r *Recorder) Emit(ev Event) {
+	r.Events = append(r.Events, ev)
+}
+
+// Writer is a Sink that prints each event as a line of the simulator output
+type Writer struct {
+	W io.Writer
+}
+
+func (w Writer) Emit(ev Event) {
+	fmt.Fprintln(w.W, ev.String())
+}
@@ -2,21 +2,16 @@ package file
 
 import (
 	"bufio"
-	"fmt"
 	"os"
-	"strings"
-
-	fp "github.com/novalagung/gubrak"
 )
 
-func Read(path string) []string {
+// Read returns the lines of the file
+func Read(path string) ([]string, error) {
 	file, err := os.Open(path)
-	defer file.Close()
 	if err != nil {
-		panic(
-			fmt.Sprintf("Failed to open file: %v", err),
-		)
+		return nil, err
 	}
+	defer file.Close()
 
 	scanner := bufio.NewScanner(file)
 	scanner.Split(bufio.ScanLines)
@@ -26,8 +21,5 @@ func Read(path string) []string {
 		lines = append(lines, scanner.Text())
 	}
 
-	mapped, _ := fp.Map(lines, strings.ToUpper)
-	lines = mapped.([]string)
-
-	return lines
+	return lines, scanner.Err()
 }
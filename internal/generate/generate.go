@@ -0,0 +1,509 @@
+package generate
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/arielril/network-simulator/internal/simulator"
+	"github.com/urfave/cli"
+)
+
+// Limits and defaults of the generated topologies
+const (
+	DEFAULT_MTU int = 10
+	// Nodes on the network of a router, that is a /24
+	MAX_NODES int = 253
+	// The number of ports of a router is a byte
+	MAX_PORTS int = 255
+	// Networks of the nodes are 10.x.y.0/24, with x.y from 0.1
+	MAX_HOST_NETWORKS int = 65535
+	// Links between routers are /30 networks on 100.64.0.0/10
+	MAX_LINKS int = 1 << 20
+)
+
+// Address blocks of the generated networks
+const (
+	HOST_NETWORKS uint32 = 10 << 24
+	LINK_NETWORKS uint32 = 100<<24 | 64<<16
+)
+
+// Options of the generated topology. Only the sizes of the shape are used.
+type Options struct {
+	Shape string
+	// Routers of the line, ring, star, mesh and random shapes
+	Routers int
+	// Levels below the root of the tree and children of each router
+	Depth  int
+	Fanout int
+	// Pods of the fat-tree, an even number
+	K int
+	// Links added to the random tree of the random shape
+	ExtraLinks int
+	// Nodes on the network of each router that has nodes
+	Nodes int
+	// MTU of the networks of each tier, DEFAULT_MTU when not set
+	Mtu map[string]int
+	// Seed of the random shape and of the loops
+	Seed int64
+	// Routing loops added to the router tables on purpose
+	Loops int
+}
+
+// Topology is a generated topology, as the lines of each section of the file
+type Topology struct {
+	Nodes   []string
+	Routers []string
+	Routes  []string
+	// Problems of the topology that did not stop its generation
+	Warnings []string
+}
+
+// Lines returns the topology on the format of the topology files
+func (t *Topology) Lines() []string {
+	lines := make([]string, 0, len(t.Nodes)+len(t.Routers)+len(t.Routes)+3)
+	lines = append(lines, simulator.NODE_LABEL)
+	lines = append(lines, t.Nodes...)
+	lines = append(lines, simulator.ROUTER_LABEL)
+	lines = append(lines, t.Routers...)
+	lines = append(lines, simulator.ROUTER_TABLE_LABEL)
+	return append(lines, t.Routes...)
+}
+
+// Write prints the topology file
+func (t *Topology) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, line := range t.Lines() {
+		bw.WriteString(line)
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
+
+/*
+----------------------------------------------------
+Addresses
+----------------------------------------------------
+*/
+
+func formatIp(ip uint32) string {
+	return fmt.Sprintf("%v.%v.%v.%v", ip>>24, (ip>>16)&0xFF, (ip>>8)&0xFF, ip&0xFF)
+}
+
+// macs hands out the MAC addresses in sequence
+type macs struct {
+	last uint64
+}
+
+func (m *macs) next() string {
+	m.last++
+	b := m.last
+	return fmt.Sprintf(
+		"%02X:%02X:%02X:%02X:%02X:%02X",
+		(b>>40)&0xFF, (b>>32)&0xFF, (b>>24)&0xFF, (b>>16)&0xFF, (b>>8)&0xFF, b&0xFF,
+	)
+}
+
+type genPort struct {
+	number  int
+	mac     string
+	ip      uint32
+	prefix  int
+	mtu     int
+	network uint32
+}
+
+type genRouter struct {
+	name  string
+	ports []genPort
+	// Port and address on the link to each neighbor
+	neighbors map[int]genPort
+}
+
+// genRoute is a route of a router to the network through the next hop
+type genRoute struct {
+	network uint32
+	prefix  int
+	nexthop uint32
+	port    int
+}
+
+/*
+----------------------------------------------------
+Generation
+----------------------------------------------------
+*/
+
+func mtuOf(opts Options, tier string) int {
+	if mtu, set := opts.Mtu[tier]; set {
+		return mtu
+	}
+	return DEFAULT_MTU
+}
+
+func validate(opts Options, g *graph) error {
+	for tier, mtu := range opts.Mtu {
+		if _, known := tierRank[tier]; !known {
+			return fmt.Errorf("Unknown tier %q, expected one of %v", tier, Tiers())
+		}
+		if mtu < 1 || mtu > 255 {
+			return fmt.Errorf("Invalid MTU %v of the %v tier, expected a value between 1 and 255", mtu, tier)
+		}
+	}
+	if opts.Nodes < 1 || opts.Nodes > MAX_NODES {
+		return fmt.Errorf("The nodes of each router must be between 1 and %v, got %v", MAX_NODES, opts.Nodes)
+	}
+	if opts.Loops < 0 {
+		return fmt.Errorf("The number of loops can not be negative, got %v", opts.Loops)
+	}
+
+	hostNetworks := 0
+	ports := make([]int, len(g.tiers))
+	for i, hosts := range g.hosts {
+		if hosts {
+			hostNetworks++
+			ports[i]++
+		}
+	}
+	for _, l := range g.links {
+		ports[l[0]]++
+		ports[l[1]]++
+	}
+	if hostNetworks > MAX_HOST_NETWORKS {
+		return fmt.Errorf("The topology has %v networks of nodes, more than the %v available", hostNetworks, MAX_HOST_NETWORKS)
+	}
+	if len(g.links) > MAX_LINKS {
+		return fmt.Errorf("The topology has %v links, more than the %v available", len(g.links), MAX_LINKS)
+	}
+	for i, count := range ports {
+		if count > MAX_PORTS {
+			return fmt.Errorf("Router r%v has %v ports, more than the %v allowed", i+1, count, MAX_PORTS)
+		}
+	}
+	return nil
+}
+
+// bfs returns the distance of every router to the target, in links
+func bfs(adjacent [][]int, target int) []int {
+	dist := make([]int, len(adjacent))
+	for i := range dist {
+		dist[i] = -1
+	}
+	dist[target] = 0
+	queue := []int{target}
+	for len(queue) > 0 {
+		u := queue[0]
+		queue = queue[1:]
+		for _, v := range adjacent[u] {
+			if dist[v] < 0 {
+				dist[v] = dist[u] + 1
+				queue = append(queue, v)
+			}
+		}
+	}
+	return dist
+}
+
+// nextHop returns the neighbor of the router on a shortest path to the target
+// of the distances, the one declared first on ties
+func nextHop(adjacent [][]int, dist []int, u int) int {
+	for _, v := range adjacent[u] {
+		if dist[v] == dist[u]-1 {
+			return v
+		}
+	}
+	return -1
+}
+
+// Generate creates the topology of the shape, with a route on every router to
+// every network it is not connected to
+func Generate(opts Options) (*Topology, error) {
+	rnd := rand.New(rand.NewSource(opts.Seed))
+	g, err := newGraph(opts, rnd)
+	if err != nil {
+		return nil, err
+	}
+	if err := validate(opts, g); err != nil {
+		return nil, err
+	}
+
+	topology := &Topology{}
+	addresses := &macs{}
+	routers := make([]*genRouter, len(g.tiers))
+	addPort := func(r *genRouter, ip uint32, prefix, mtu int) genPort {
+		p := genPort{
+			number:  len(r.ports),
+			mac:     addresses.next(),
+			ip:      ip,
+			prefix:  prefix,
+			mtu:     mtu,
+			network: ip & (0xFFFFFFFF << uint(32-prefix)),
+		}
+		r.ports = append(r.ports, p)
+		return p
+	}
+
+	// networks of each router that the others route to: its nodes and the
+	// links where it is the first router
+	owned := make([][]genPort, len(g.tiers))
+	hostNetwork := 0
+	for i := range routers {
+		r := &genRouter{name: fmt.Sprintf("r%v", i+1), neighbors: make(map[int]genPort)}
+		routers[i] = r
+		if !g.hosts[i] {
+			continue
+		}
+		hostNetwork++
+		network := HOST_NETWORKS | uint32(hostNetwork)<<8
+		mtu := mtuOf(opts, HOST_TIER)
+		gw := addPort(r, network|1, 24, mtu)
+		owned[i] = append(owned[i], gw)
+		for n := 0; n < opts.Nodes; n++ {
+			topology.Nodes = append(topology.Nodes, fmt.Sprintf(
+				"n%v,%v,%v/24,%v,%v",
+				len(topology.Nodes)+1, addresses.next(), formatIp(network|uint32(n+2)), mtu, formatIp(gw.ip),
+			))
+		}
+	}
+
+	adjacent := make([][]int, len(g.tiers))
+	for li, l := range g.links {
+		a, b := l[0], l[1]
+		tier := g.tiers[a]
+		if tierRank[g.tiers[b]] < tierRank[tier] {
+			tier = g.tiers[b]
+		}
+		network := LINK_NETWORKS + uint32(li)*4
+		pa := addPort(routers[a], network|1, 30, mtuOf(opts, tier))
+		pb := addPort(routers[b], network|2, 30, mtuOf(opts, tier))
+		routers[a].neighbors[b] = pa
+		routers[b].neighbors[a] = pb
+		owned[a] = append(owned[a], pa)
+		adjacent[a] = append(adjacent[a], b)
+		adjacent[b] = append(adjacent[b], a)
+	}
+	for _, adj := range adjacent {
+		sort.Ints(adj)
+	}
+
+	// routes of each router, by network
+	routes := make([]map[uint32]*genRoute, len(routers))
+	order := make([][]uint32, len(routers))
+	for i := range routes {
+		routes[i] = make(map[uint32]*genRoute)
+	}
+	connected := func(u int, network uint32) bool {
+		for _, p := range routers[u].ports {
+			if p.network == network {
+				return true
+			}
+		}
+		return false
+	}
+	route := func(u, via int, dest genPort) *genRoute {
+		return &genRoute{
+			network: dest.network,
+			prefix:  dest.prefix,
+			nexthop: routers[via].neighbors[u].ip,
+			port:    routers[u].neighbors[via].number,
+		}
+	}
+
+	longest, from, to := 0, 0, 0
+	for t := range routers {
+		if len(owned[t]) == 0 {
+			continue
+		}
+		dist := bfs(adjacent, t)
+		for u := range routers {
+			if u == t {
+				continue
+			}
+			via := nextHop(adjacent, dist, u)
+			for _, dest := range owned[t] {
+				if !connected(u, dest.network) {
+					routes[u][dest.network] = route(u, via, dest)
+					order[u] = append(order[u], dest.network)
+				}
+			}
+			if g.hosts[t] && g.hosts[u] && dist[u] > longest {
+				longest, from, to = dist[u], u, t
+			}
+		}
+	}
+	// the routers of the path are the links plus one, and each one decrements the TTL
+	if longest+1 >= int(simulator.ICMP_TTL) {
+		topology.Warnings = append(topology.Warnings, fmt.Sprintf(
+			"The path from %v to %v crosses %v routers, so the TTL of %v expires before the pings arrive",
+			routers[from].name, routers[to].name, longest+1, simulator.ICMP_TTL,
+		))
+	}
+
+	if err := seedLoops(opts.Loops, rnd, g, adjacent, owned, routes, route); err != nil {
+		return nil, err
+	}
+
+	for _, r := range routers {
+		line := []string{r.name, strconv.Itoa(len(r.ports))}
+		for _, p := range r.ports {
+			line = append(line, p.mac, fmt.Sprintf("%v/%v", formatIp(p.ip), p.prefix), strconv.Itoa(p.mtu))
+		}
+		topology.Routers = append(topology.Routers, strings.Join(line, ","))
+	}
+	for i, r := range routers {
+		for _, rt := range compressRoutes(routes[i], order[i]) {
+			topology.Routes = append(topology.Routes, fmt.Sprintf(
+				"%v,%v/%v,%v,%v", r.name, formatIp(rt.network), rt.prefix, formatIp(rt.nexthop), rt.port,
+			))
+		}
+	}
+	return topology, nil
+}
+
+// seedLoops points the route of a router to a network of the nodes back to the
+// neighbor that forwards to it, so the packets bounce between both
+func seedLoops(
+	loops int, rnd *rand.Rand, g *graph, adjacent [][]int, owned [][]genPort,
+	routes []map[uint32]*genRoute, route func(u, via int, dest genPort) *genRoute,
+) error {
+	if loops == 0 {
+		return nil
+	}
+
+	type candidate struct {
+		u, v int
+		dest genPort
+	}
+	candidates := make([]candidate, 0)
+	for t := range owned {
+		if !g.hosts[t] {
+			continue
+		}
+		dest := owned[t][0]
+		dist := bfs(adjacent, t)
+		for u := range adjacent {
+			if dist[u] < 2 {
+				continue
+			}
+			candidates = append(candidates, candidate{u: u, v: nextHop(adjacent, dist, u), dest: dest})
+		}
+	}
+
+	rnd.Shuffle(len(candidates), func(i, j int) { candidates[i], candidates[j] = candidates[j], candidates[i] })
+	seeded := make(map[[2]uint32]bool)
+	for _, c := range candidates {
+		if loops == 0 {
+			break
+		}
+		key := [2]uint32{uint32(c.v), c.dest.network}
+		if seeded[key] {
+			continue
+		}
+		seeded[key] = true
+		routes[c.u][c.dest.network] = route(c.u, c.v, c.dest)
+		routes[c.v][c.dest.network] = route(c.v, c.u, c.dest)
+		loops--
+	}
+	if loops > 0 {
+		return fmt.Errorf("The topology has no room for %v more loops: they need routers two links away from a network of nodes", loops)
+	}
+	return nil
+}
+
+// compressRoutes replaces the most common next hop of the routes by a default
+// route, that comes last as the routes are looked up in order
+func compressRoutes(routes map[uint32]*genRoute, order []uint32) []*genRoute {
+	type hop struct {
+		nexthop uint32
+		port    int
+	}
+	counts := make(map[hop]int)
+	var best hop
+	for _, network := range order {
+		rt := routes[network]
+		h := hop{rt.nexthop, rt.port}
+		counts[h]++
+		if counts[h] > counts[best] {
+			best = h
+		}
+	}
+
+	compressed := make([]*genRoute, 0, len(order))
+	for _, network := range order {
+		rt := routes[network]
+		if counts[best] < 2 || rt.nexthop != best.nexthop || rt.port != best.port {
+			compressed = append(compressed, rt)
+		}
+	}
+	if counts[best] >= 2 {
+		compressed = append(compressed, &genRoute{nexthop: best.nexthop, port: best.port})
+	}
+	return compressed
+}
+
+/*
+----------------------------------------------------
+Generate command
+----------------------------------------------------
+*/
+
+// parseMtus reads the MTUs given as tier=value
+func parseMtus(values []string) (map[string]int, error) {
+	mtus := make(map[string]int)
+	for _, value := range values {
+		for _, field := range strings.Split(value, ",") {
+			parts := strings.SplitN(field, "=", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("Invalid MTU %v, expected tier=value", field)
+			}
+			mtu, err := strconv.Atoi(parts[1])
+			if err != nil {
+				return nil, fmt.Errorf("Invalid MTU %v, expected tier=value", field)
+			}
+			mtus[strings.TrimSpace(parts[0])] = mtu
+		}
+	}
+	return mtus, nil
+}
+
+// Command prints a generated topology of the shape
+func Command(ctx *cli.Context) error {
+	args := ctx.Args()
+	if len(args) != 1 {
+		return &simulator.UsageError{Msg: "Invalid generate arguments, expected the shape"}
+	}
+	mtus, err := parseMtus(ctx.StringSlice("mtu"))
+	if err != nil {
+		return &simulator.UsageError{Msg: err.Error()}
+	}
+
+	opts := Options{
+		Shape:      args.Get(0),
+		Routers:    ctx.Int("routers"),
+		Depth:      ctx.Int("depth"),
+		Fanout:     ctx.Int("fanout"),
+		K:          ctx.Int("k"),
+		ExtraLinks: ctx.Int("extra-links"),
+		Nodes:      ctx.Int("nodes"),
+		Mtu:        mtus,
+		Seed:       ctx.Int64("seed"),
+		Loops:      ctx.Int("loops"),
+	}
+	if !ctx.IsSet("extra-links") {
+		opts.ExtraLinks = opts.Routers / 2
+	}
+
+	topology, err := Generate(opts)
+	if err != nil {
+		return &simulator.UsageError{Msg: err.Error()}
+	}
+	for _, msg := range topology.Warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", msg)
+	}
+	return topology.Write(os.Stdout)
+}
@@ -0,0 +1,115 @@
+package generate
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/arielril/network-simulator/internal/simulator"
+)
+
+func load(t *testing.T, topology *Topology) simulator.Environment {
+	t.Helper()
+
+	env := simulator.NewEnvironment()
+	if err := env.ParseLines(topology.Lines()); err != nil {
+		t.Fatalf("The generated topology is invalid: %v\n%v", err, strings.Join(topology.Lines(), "\n"))
+	}
+	if warnings := env.Warnings(); len(warnings) > 0 {
+		t.Errorf("The generated routes have warnings: %v", warnings)
+	}
+	return env
+}
+
+func TestFullReachability(t *testing.T) {
+	cases := []Options{
+		{Shape: LINE, Routers: 5},
+		{Shape: RING, Routers: 7},
+		{Shape: STAR, Routers: 6, Nodes: 3},
+		{Shape: TREE, Depth: 3, Fanout: 2},
+		{Shape: MESH, Routers: 6},
+		{Shape: FAT_TREE, K: 4, Nodes: 2},
+		{Shape: RANDOM, Routers: 12, ExtraLinks: 8, Seed: 42},
+	}
+	for _, opts := range cases {
+		opts := opts
+		if opts.Nodes == 0 {
+			opts.Nodes = 1
+		}
+		t.Run(opts.Shape, func(t *testing.T) {
+			topology, err := Generate(opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(topology.Warnings) > 0 {
+				t.Errorf("Unexpected warnings: %v", topology.Warnings)
+			}
+
+			analysis := load(t, topology).AnalyzeForwarding()
+			if analysis.Problems() > 0 {
+				t.Errorf(
+					"Expected full reachability, got loops %v, black holes %v and unreachable pairs %v",
+					analysis.Loops, analysis.BlackHoles, analysis.Unreachable,
+				)
+			}
+		})
+	}
+}
+
+func TestSeededLoops(t *testing.T) {
+	topology, err := Generate(Options{Shape: RING, Routers: 6, Nodes: 1, Seed: 7, Loops: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loops := load(t, topology).AnalyzeForwarding().Loops; len(loops) != 2 {
+		t.Errorf("Expected 2 loops, got %v", loops)
+	}
+
+	if _, err := Generate(Options{Shape: LINE, Routers: 2, Nodes: 1, Loops: 1}); err == nil {
+		t.Error("Expected no room for loops between two routers")
+	}
+}
+
+func TestRandomSeed(t *testing.T) {
+	generate := func(seed int64) string {
+		topology, err := Generate(Options{Shape: RANDOM, Routers: 10, ExtraLinks: 5, Nodes: 1, Seed: seed})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.Join(topology.Lines(), "\n")
+	}
+	if generate(3) != generate(3) {
+		t.Error("Expected the same topology for the same seed")
+	}
+	if generate(3) == generate(4) {
+		t.Error("Expected another topology for another seed")
+	}
+}
+
+func TestTierMtus(t *testing.T) {
+	opts := Options{Shape: STAR, Routers: 3, Nodes: 1, Mtu: map[string]int{HOST_TIER: 5, EDGE_TIER: 8}}
+	topology, err := Generate(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(topology.Nodes[0], "/24,5,10.0.1.1") {
+		t.Errorf("Expected the nodes on the host MTU, got %v", topology.Nodes[0])
+	}
+	if topology.Routers[0] != "r1,2,00:00:00:00:00:05,100.64.0.1/30,8,00:00:00:00:00:07,100.64.0.5/30,8" {
+		t.Errorf("Expected the links of the star on the edge MTU, got %v", topology.Routers[0])
+	}
+
+	opts.Mtu = map[string]int{"backbone": 5}
+	if _, err := Generate(opts); err == nil {
+		t.Error("Expected an error for an unknown tier")
+	}
+}
+
+func TestLongPathWarning(t *testing.T) {
+	topology, err := Generate(Options{Shape: LINE, Routers: int(simulator.ICMP_TTL), Nodes: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(topology.Warnings) != 1 {
+		t.Errorf("Expected a warning about the TTL, got %v", topology.Warnings)
+	}
+}
@@ -0,0 +1,239 @@
+package generate
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+)
+
+// Shapes of the generated topologies
+const (
+	LINE     string = "line"
+	RING     string = "ring"
+	STAR     string = "star"
+	TREE     string = "tree"
+	MESH     string = "mesh"
+	FAT_TREE string = "fat-tree"
+	RANDOM   string = "random"
+)
+
+// Tiers of the routers, that set the MTU of their links. The networks of the
+// nodes are on the host tier.
+const (
+	HOST_TIER        string = "host"
+	EDGE_TIER        string = "edge"
+	AGGREGATION_TIER string = "aggregation"
+	CORE_TIER        string = "core"
+)
+
+// Shapes returns the name of every shape the generator knows
+func Shapes() []string {
+	return []string{FAT_TREE, LINE, MESH, RANDOM, RING, STAR, TREE}
+}
+
+// Tiers returns the name of every tier whose MTU can be set
+func Tiers() []string {
+	return []string{HOST_TIER, EDGE_TIER, AGGREGATION_TIER, CORE_TIER}
+}
+
+// tierRank orders the tiers from the nodes to the core
+var tierRank = map[string]int{
+	HOST_TIER:        0,
+	EDGE_TIER:        1,
+	AGGREGATION_TIER: 2,
+	CORE_TIER:        3,
+}
+
+// graph is the shape of the topology: the routers, their tiers, which ones
+// have nodes, and the links between them
+type graph struct {
+	tiers []string
+	hosts []bool
+	links [][2]int
+}
+
+func (g *graph) addRouter(tier string, hosts bool) int {
+	g.tiers = append(g.tiers, tier)
+	g.hosts = append(g.hosts, hosts)
+	return len(g.tiers) - 1
+}
+
+func (g *graph) link(a, b int) {
+	g.links = append(g.links, [2]int{a, b})
+}
+
+func lineGraph(routers int) *graph {
+	g := &graph{}
+	for i := 0; i < routers; i++ {
+		g.addRouter(CORE_TIER, true)
+		if i > 0 {
+			g.link(i-1, i)
+		}
+	}
+	return g
+}
+
+func ringGraph(routers int) *graph {
+	g := lineGraph(routers)
+	if routers > 2 {
+		g.link(routers-1, 0)
+	}
+	return g
+}
+
+func starGraph(routers int) *graph {
+	g := &graph{}
+	center := g.addRouter(CORE_TIER, routers == 1)
+	for i := 1; i < routers; i++ {
+		g.link(center, g.addRouter(EDGE_TIER, true))
+	}
+	return g
+}
+
+func meshGraph(routers int) *graph {
+	g := &graph{}
+	for i := 0; i < routers; i++ {
+		g.addRouter(CORE_TIER, true)
+		for j := 0; j < i; j++ {
+			g.link(j, i)
+		}
+	}
+	return g
+}
+
+// treeGraph has a root with fanout children on each level, down to the depth.
+// Only the leaves have nodes.
+func treeGraph(depth, fanout int) *graph {
+	g := &graph{}
+	level := []int{g.addRouter(CORE_TIER, depth == 0)}
+	for d := 1; d <= depth; d++ {
+		tier := AGGREGATION_TIER
+		if d == depth {
+			tier = EDGE_TIER
+		}
+		next := make([]int, 0, len(level)*fanout)
+		for _, parent := range level {
+			for i := 0; i < fanout; i++ {
+				child := g.addRouter(tier, d == depth)
+				g.link(parent, child)
+				next = append(next, child)
+			}
+		}
+		level = next
+	}
+	return g
+}
+
+// fatTreeGraph is the k-ary fat-tree: k pods of k/2 edge and k/2 aggregation
+// routers, all connected to each other, and (k/2)^2 core routers, each
+// connected to an aggregation router of every pod
+func fatTreeGraph(k int) *graph {
+	g := &graph{}
+	half := k / 2
+	cores := make([]int, half*half)
+	for i := range cores {
+		cores[i] = g.addRouter(CORE_TIER, false)
+	}
+	for pod := 0; pod < k; pod++ {
+		aggregations := make([]int, half)
+		for i := range aggregations {
+			aggregations[i] = g.addRouter(AGGREGATION_TIER, false)
+			for c := 0; c < half; c++ {
+				g.link(cores[i*half+c], aggregations[i])
+			}
+		}
+		for i := 0; i < half; i++ {
+			edge := g.addRouter(EDGE_TIER, true)
+			for _, aggregation := range aggregations {
+				g.link(aggregation, edge)
+			}
+		}
+	}
+	return g
+}
+
+// randomGraph connects the routers on a random tree, so all of them are
+// reachable, and adds the extra links between random pairs of routers
+func randomGraph(routers, extraLinks int, rnd *rand.Rand) *graph {
+	g := &graph{}
+	linked := make(map[[2]int]bool)
+	for i := 0; i < routers; i++ {
+		g.addRouter(CORE_TIER, true)
+		if i > 0 {
+			j := rnd.Intn(i)
+			g.link(j, i)
+			linked[[2]int{j, i}] = true
+		}
+	}
+
+	candidates := make([][2]int, 0)
+	for i := 0; i < routers; i++ {
+		for j := i + 1; j < routers; j++ {
+			if !linked[[2]int{i, j}] {
+				candidates = append(candidates, [2]int{i, j})
+			}
+		}
+	}
+	rnd.Shuffle(len(candidates), func(i, j int) { candidates[i], candidates[j] = candidates[j], candidates[i] })
+	if extraLinks > len(candidates) {
+		extraLinks = len(candidates)
+	}
+	chosen := candidates[:extraLinks]
+	sort.Slice(chosen, func(i, j int) bool {
+		if chosen[i][0] != chosen[j][0] {
+			return chosen[i][0] < chosen[j][0]
+		}
+		return chosen[i][1] < chosen[j][1]
+	})
+	for _, l := range chosen {
+		g.link(l[0], l[1])
+	}
+	return g
+}
+
+// newGraph creates the graph of the shape, validating the sizes
+func newGraph(opts Options, rnd *rand.Rand) (*graph, error) {
+	positive := func(name string, value int) error {
+		if value < 1 {
+			return fmt.Errorf("The %v must be at least 1, got %v", name, value)
+		}
+		return nil
+	}
+
+	switch opts.Shape {
+	case LINE, RING, STAR, MESH, RANDOM:
+		if err := positive("number of routers", opts.Routers); err != nil {
+			return nil, err
+		}
+	}
+
+	switch opts.Shape {
+	case LINE:
+		return lineGraph(opts.Routers), nil
+	case RING:
+		return ringGraph(opts.Routers), nil
+	case STAR:
+		return starGraph(opts.Routers), nil
+	case MESH:
+		return meshGraph(opts.Routers), nil
+	case RANDOM:
+		if opts.ExtraLinks < 0 {
+			return nil, fmt.Errorf("The number of extra links can not be negative, got %v", opts.ExtraLinks)
+		}
+		return randomGraph(opts.Routers, opts.ExtraLinks, rnd), nil
+	case TREE:
+		if opts.Depth < 0 {
+			return nil, fmt.Errorf("The depth of the tree can not be negative, got %v", opts.Depth)
+		}
+		if err := positive("fanout of the tree", opts.Fanout); err != nil {
+			return nil, err
+		}
+		return treeGraph(opts.Depth, opts.Fanout), nil
+	case FAT_TREE:
+		if opts.K < 2 || opts.K%2 != 0 {
+			return nil, fmt.Errorf("The k of the fat-tree must be an even number of at least 2, got %v", opts.K)
+		}
+		return fatTreeGraph(opts.K), nil
+	}
+	return nil, fmt.Errorf("Unknown shape %q, expected one of %v", opts.Shape, Shapes())
+}
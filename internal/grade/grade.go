@@ -0,0 +1,313 @@
+package grade
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/arielril/network-simulator/internal/event"
+	"github.com/arielril/network-simulator/internal/simulator"
+	"github.com/arielril/network-simulator/internal/transcript"
+	"github.com/urfave/cli"
+)
+
+// Status is the result of the comparison of a line of the transcript
+type Status uint8
+
+const (
+	// the line is exactly the same as the reference
+	MATCH Status = iota + 1
+	// the line has the same meaning as the reference, but is written differently
+	FORMAT
+	// the line is in the place of a reference line, but with different values
+	MISMATCH
+	// the reference line is not in the candidate transcript
+	MISSING
+	// the candidate line is not in the reference transcript
+	EXTRA
+)
+
+func (s Status) String() string {
+	switch s {
+	case MATCH:
+		return "match"
+	case FORMAT:
+		return "format"
+	case MISMATCH:
+		return "mismatched"
+	case MISSING:
+		return "missing"
+	case EXTRA:
+		return "extra"
+	}
+	return "unknown"
+}
+
+// Line is a reference line, a candidate line or both, compared
+type Line struct {
+	Status Status
+	Want   string
+	Got    string
+	// Line numbers on the reference and on the candidate (0 when absent)
+	WantLine int
+	GotLine  int
+	// Fields with different values, for mismatched lines
+	Fields []string
+}
+
+// Report is the result of grading a candidate transcript
+type Report struct {
+	Lines  []Line
+	Counts map[Status]int
+	// Percentage of the reference reproduced by the candidate
+	Score float64
+}
+
+type Options struct {
+	// Lines that only differ by blanks are considered a match
+	IgnoreWhitespace bool
+}
+
+type transcriptLine struct {
+	number int
+	text   string
+	ev     event.Event
+	parsed bool
+	// identifies what the line means, so lines written differently can be aligned
+	key string
+}
+
+func normalizeWhitespace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func removeWhitespace(s string) string {
+	return strings.Join(strings.Fields(s), "")
+}
+
+func readLines(text string) []transcriptLine {
+	lines := make([]transcriptLine, 0)
+	for i, l := range strings.Split(text, "\n") {
+		l = strings.TrimRight(l, "\r")
+		if strings.TrimSpace(l) == "" {
+			continue
+		}
+		ev, err := transcript.ParseLenientLine(l)
+		line := transcriptLine{number: i + 1, text: l, ev: ev, parsed: err == nil}
+		line.key = lineKey(line)
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func lineKey(l transcriptLine) string {
+	if !l.parsed {
+		return normalizeWhitespace(l.text)
+	}
+	ev := l.ev
+	ev.SrcMac = strings.ToUpper(ev.SrcMac)
+	ev.DstMac = strings.ToUpper(ev.DstMac)
+	return ev.String()
+}
+
+func (l transcriptLine) sameText(other transcriptLine, opts Options) bool {
+	if opts.IgnoreWhitespace {
+		return removeWhitespace(l.text) == removeWhitespace(other.text)
+	}
+	return l.text == other.text
+}
+
+// fieldDiff lists the fields of the events with different values
+func fieldDiff(want, got event.Event) []string {
+	fields := []struct {
+		name      string
+		want, got interface{}
+	}{
+		{"kind", want.Kind, got.Kind},
+		{"src", want.Src, got.Src},
+		{"dst", want.Dst, got.Dst},
+		{"src mac", strings.ToUpper(want.SrcMac), strings.ToUpper(got.SrcMac)},
+		{"dst mac", strings.ToUpper(want.DstMac), strings.ToUpper(got.DstMac)},
+		{"vlan", want.Vlan, got.Vlan},
+		{"src ip", want.SrcIp, got.SrcIp},
+		{"dst ip", want.DstIp, got.DstIp},
+		{"ttl", want.Ttl, got.Ttl},
+		{"mf", want.Mf, got.Mf},
+		{"off", want.Off, got.Off},
+		{"data", want.Data, got.Data},
+	}
+
+	diff := make([]string, 0)
+	for _, f := range fields {
+		if f.want != f.got {
+			diff = append(diff, fmt.Sprintf("%v (want %v, got %v)", f.name, f.want, f.got))
+		}
+	}
+	return diff
+}
+
+// lcs aligns the lines of both transcripts by meaning, returning the pairs of
+// indexes of the longest common subsequence
+func lcs(want, got []transcriptLine) [][2]int {
+	n, m := len(want), len(got)
+	table := make([][]int, n+1)
+	for i := range table {
+		table[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if want[i].key == got[j].key {
+				table[i][j] = table[i+1][j+1] + 1
+			} else if table[i+1][j] >= table[i][j+1] {
+				table[i][j] = table[i+1][j]
+			} else {
+				table[i][j] = table[i][j+1]
+			}
+		}
+	}
+
+	pairs := make([][2]int, 0, table[0][0])
+	for i, j := 0, 0; i < n && j < m; {
+		switch {
+		case want[i].key == got[j].key:
+			pairs = append(pairs, [2]int{i, j})
+			i++
+			j++
+		case table[i+1][j] >= table[i][j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+	return pairs
+}
+
+// compareGap compares the lines between two aligned pairs. Lines on the same
+// position are mismatches, the remaining ones are missing or extra.
+func compareGap(want, got []transcriptLine) []Line {
+	lines := make([]Line, 0)
+	for i := 0; i < len(want) || i < len(got); i++ {
+		switch {
+		case i >= len(got):
+			lines = append(lines, Line{Status: MISSING, Want: want[i].text, WantLine: want[i].number})
+		case i >= len(want):
+			lines = append(lines, Line{Status: EXTRA, Got: got[i].text, GotLine: got[i].number})
+		default:
+			line := Line{
+				Status: MISMATCH,
+				Want:   want[i].text, WantLine: want[i].number,
+				Got: got[i].text, GotLine: got[i].number,
+			}
+			if got[i].parsed {
+				line.Fields = fieldDiff(want[i].ev, got[i].ev)
+			} else {
+				line.Fields = []string{"unknown line format"}
+			}
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// Compare grades the candidate transcript against the reference one
+func Compare(reference, candidate string, opts Options) Report {
+	want := readLines(reference)
+	got := readLines(candidate)
+
+	report := Report{Counts: make(map[Status]int)}
+	i, j := 0, 0
+	for _, pair := range append(lcs(want, got), [2]int{len(want), len(got)}) {
+		report.Lines = append(report.Lines, compareGap(want[i:pair[0]], got[j:pair[1]])...)
+		if pair[0] < len(want) {
+			status := FORMAT
+			if want[pair[0]].sameText(got[pair[1]], opts) {
+				status = MATCH
+			}
+			report.Lines = append(report.Lines, Line{
+				Status: status,
+				Want:   want[pair[0]].text, WantLine: want[pair[0]].number,
+				Got: got[pair[1]].text, GotLine: got[pair[1]].number,
+			})
+		}
+		i, j = pair[0]+1, pair[1]+1
+	}
+
+	for _, l := range report.Lines {
+		report.Counts[l.Status]++
+	}
+
+	// lines written differently get half of the score, extra lines are penalized
+	total := float64(len(want) + report.Counts[EXTRA])
+	if total == 0 {
+		report.Score = 100
+	} else {
+		points := float64(report.Counts[MATCH]) + float64(report.Counts[FORMAT])/2
+		report.Score = 100 * points / total
+	}
+	return report
+}
+
+// Write prints the score and every line that is not an exact match
+func (r Report) Write(w io.Writer) {
+	fmt.Fprintf(w, "Score: %.1f%%\n", r.Score)
+	fmt.Fprintf(
+		w, "match: %v, format: %v, mismatched: %v, missing: %v, extra: %v\n",
+		r.Counts[MATCH], r.Counts[FORMAT], r.Counts[MISMATCH], r.Counts[MISSING], r.Counts[EXTRA],
+	)
+
+	for _, l := range r.Lines {
+		switch l.Status {
+		case MATCH:
+			continue
+		case MISSING:
+			fmt.Fprintf(w, "\nline %v: missing\n  want: %v\n", l.WantLine, l.Want)
+		case EXTRA:
+			fmt.Fprintf(w, "\ncandidate line %v: extra\n  got:  %v\n", l.GotLine, l.Got)
+		default:
+			fmt.Fprintf(w, "\nline %v (candidate line %v): %v", l.WantLine, l.GotLine, l.Status)
+			if len(l.Fields) > 0 {
+				fmt.Fprintf(w, " - %v", strings.Join(l.Fields, ", "))
+			}
+			fmt.Fprintf(w, "\n  want: %v\n  got:  %v\n", l.Want, l.Got)
+		}
+	}
+}
+
+/*
+----------------------------------------------------
+Grade command
+----------------------------------------------------
+*/
+
+// Grade compares the transcript of another simulator with the output of this one
+func Grade(ctx *cli.Context) error {
+	args := ctx.Args()
+	if len(args) != 5 {
+		return &simulator.UsageError{Msg: "Invalid grade arguments"}
+	}
+
+	candidate, err := os.ReadFile(args.Get(4))
+	if err != nil {
+		return fmt.Errorf("Failed to read candidate output: %w", err)
+	}
+
+	env, err := simulator.LoadEnvironment(args.Get(0))
+	if err != nil {
+		return err
+	}
+	var reference strings.Builder
+	env.SetSink(event.Writer{W: &reference})
+	if err := simulator.Ping(env, args.Get(1), args.Get(2), args.Get(3)); err != nil {
+		return err
+	}
+
+	opts := Options{IgnoreWhitespace: ctx.Bool("ignore-whitespace")}
+	report := Compare(reference.String(), string(candidate), opts)
+	report.Write(os.Stdout)
+
+	if report.Score < 100 {
+		return cli.NewExitError("", 1)
+	}
+	return nil
+}
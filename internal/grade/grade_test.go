@@ -0,0 +1,113 @@
+package grade
+
+import (
+	"strings"
+	"testing"
+)
+
+var reference = strings.Join([]string{
+	`n1 box n1 : ETH (src=00:00:00:00:00:01 dst=FF:FF:FF:FF:FF:FF) \n ARP - Who has 192.168.0.3? Tell 192.168.0.2;`,
+	`n2 => n1 : ETH (src=00:00:00:00:00:02 dst=00:00:00:00:00:01) \n ARP - 192.168.0.3 is at 00:00:00:00:00:02;`,
+	`n1 => n2 : ETH (src=00:00:00:00:00:01 dst=00:00:00:00:00:02) \n IP (src=192.168.0.2 dst=192.168.0.3 ttl=8 mf=1 off=0) \n ICMP - Echo request (data=hello);`,
+	`n1 => n2 : ETH (src=00:00:00:00:00:01 dst=00:00:00:00:00:02) \n IP (src=192.168.0.2 dst=192.168.0.3 ttl=8 mf=0 off=5) \n ICMP - Echo request (data=world);`,
+	`n2 rbox n2 : Received helloworld;`,
+}, "\n")
+
+func TestCompareIdentical(t *testing.T) {
+	report := Compare(reference, reference+"\n\n", Options{})
+	if report.Score != 100 || report.Counts[MATCH] != 5 {
+		t.Errorf("Expected a perfect score, got %v (%v)", report.Score, report.Counts)
+	}
+}
+
+func TestCompareWhitespace(t *testing.T) {
+	candidate := strings.Replace(reference, "n1 box n1 :", "n1 box n1  : ", 1)
+
+	report := Compare(reference, candidate, Options{})
+	if report.Counts[FORMAT] != 1 || report.Score != 90 {
+		t.Errorf("Expected a format difference, got %v (%v)", report.Score, report.Counts)
+	}
+
+	report = Compare(reference, candidate, Options{IgnoreWhitespace: true})
+	if report.Score != 100 {
+		t.Errorf("Expected whitespace to be ignored, got %v (%v)", report.Score, report.Counts)
+	}
+}
+
+func TestCompareFormat(t *testing.T) {
+	candidate := strings.Replace(reference, "dst=00:00:00:00:00:01)", "dst = 00:00:00:00:00:01 )", 1)
+	candidate = strings.Replace(candidate, "Received helloworld;", "Received helloworld", 1)
+
+	report := Compare(reference, candidate, Options{})
+	if report.Counts[FORMAT] != 2 || report.Counts[MATCH] != 3 {
+		t.Errorf("Expected two format differences, got %v (%v)", report.Score, report.Counts)
+	}
+}
+
+func TestCompareMismatch(t *testing.T) {
+	candidate := strings.Replace(reference, "ttl=8 mf=1 off=0", "ttl=7 mf=0 off=0", 1)
+
+	report := Compare(reference, candidate, Options{})
+	if report.Counts[MISMATCH] != 1 {
+		t.Fatalf("Expected one mismatched line, got %v", report.Counts)
+	}
+	for _, l := range report.Lines {
+		if l.Status != MISMATCH {
+			continue
+		}
+		if l.WantLine != 3 || len(l.Fields) != 2 {
+			t.Errorf("Expected ttl and mf to differ on line 3, got line %v: %v", l.WantLine, l.Fields)
+		}
+	}
+}
+
+func TestCompareMissingAndExtra(t *testing.T) {
+	lines := strings.Split(reference, "\n")
+	candidate := strings.Join(append(lines[1:], "n2 rbox n2 : Received hello;"), "\n")
+
+	report := Compare(reference, candidate, Options{})
+	if report.Counts[MISSING] != 1 || report.Counts[EXTRA] != 1 || report.Counts[MATCH] != 4 {
+		t.Errorf("Expected one missing and one extra line, got %v", report.Counts)
+	}
+}
+
+func TestCompareSwitchLines(t *testing.T) {
+	switched := strings.Join([]string{
+		`n1 box n1 : ETH (src=00:00:00:00:00:01 dst=FF:FF:FF:FF:FF:FF) \n ARP - Who has 192.168.0.3? Tell 192.168.0.2;`,
+		`sw1 => n2 : ETH (src=00:00:00:00:00:01 dst=FF:FF:FF:FF:FF:FF) \n Switch - Flooded from port 0 to port 1;`,
+		`sw1 => r1 : ETH (src=00:00:00:00:00:01 dst=FF:FF:FF:FF:FF:FF) \n Switch - Flooded from port 0 to port 2;`,
+	}, "\n")
+	candidate := strings.Replace(switched, "Flooded from port 0 to port 2", "Forwarded from port 0 to port 3", 1)
+
+	report := Compare(switched, candidate, Options{})
+	if report.Counts[MATCH] != 2 || report.Counts[MISMATCH] != 1 {
+		t.Fatalf("Expected one mismatched switch line, got %v", report.Counts)
+	}
+	for _, l := range report.Lines {
+		if l.Status == MISMATCH && (l.WantLine != 3 || len(l.Fields) != 2) {
+			t.Errorf("Expected kind and data to differ on line 3, got line %v: %v", l.WantLine, l.Fields)
+		}
+	}
+}
+
+func TestCompareVlanTags(t *testing.T) {
+	tagged := strings.Join([]string{
+		`sw1 => sw2 : ETH (src=00:00:00:00:00:01 dst=FF:FF:FF:FF:FF:FF vlan=10) \n Switch - Flooded from port 0 to port 2;`,
+		`sw2 => r1 : ETH (src=00:00:00:00:00:01 dst=FF:FF:FF:FF:FF:FF vlan=10) \n Switch - Flooded from port 0 to port 3;`,
+		`r1 => n1 : ETH (src=00:00:00:00:00:05 dst=00:00:00:00:00:01 vlan=10) \n ARP - 192.168.10.1 is at 00:00:00:00:00:05;`,
+	}, "\n")
+
+	// a wrong tag, and a tag left out
+	candidate := strings.Replace(tagged, "vlan=10) \\n Switch - Flooded from port 0 to port 3", "vlan=20) \\n Switch - Flooded from port 0 to port 3", 1)
+	candidate = strings.Replace(candidate, " vlan=10) \\n ARP", ") \\n ARP", 1)
+
+	report := Compare(tagged, candidate, Options{})
+	if report.Counts[MATCH] != 1 || report.Counts[MISMATCH] != 2 {
+		t.Fatalf("Expected two mismatched tags, got %v", report.Counts)
+	}
+	for _, l := range report.Lines {
+		if l.Status == MISMATCH && (len(l.Fields) != 1 || !strings.HasPrefix(l.Fields[0], "vlan")) {
+			t.Errorf("Expected only the vlan to differ on line %v, got %v", l.WantLine, l.Fields)
+		}
+	}
+}
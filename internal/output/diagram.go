@@ -0,0 +1,210 @@
+package output
+
+import (
+	"image/color"
+	"io"
+	"strings"
+
+	"github.com/arielril/network-simulator/internal/event"
+)
+
+// Sizes, in pixels, of the rendered sequence diagram
+const (
+	CHAR_WIDTH     int = 6
+	LINE_HEIGHT    int = 12
+	MARGIN         int = 20
+	ENTITY_HEIGHT  int = 24
+	ROW_PADDING    int = 10
+	BOX_PADDING    int = 6
+	MIN_COL_WIDTH  int = 120
+	ARROW_SIZE     int = 6
+	LABEL_BASELINE int = 3
+)
+
+var (
+	COLOR_BLACK      = color.RGBA{0x00, 0x00, 0x00, 0xFF}
+	COLOR_WHITE      = color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}
+	COLOR_LIFELINE   = color.RGBA{0x99, 0x99, 0x99, 0xFF}
+	COLOR_ARP        = color.RGBA{0x1F, 0x77, 0xB4, 0xFF}
+	COLOR_ICMP       = color.RGBA{0x2C, 0xA0, 0x2C, 0xFF}
+	COLOR_TIME_EXCED = color.RGBA{0xD6, 0x27, 0x28, 0xFF}
+	COLOR_RECEIVED   = color.RGBA{0x94, 0x67, 0xBD, 0xFF}
+	COLOR_ROUTING    = color.RGBA{0x8C, 0x56, 0x4B, 0xFF}
+	COLOR_SWITCH     = color.RGBA{0x7F, 0x7F, 0x7F, 0xFF}
+	COLOR_BOX_FILL   = color.RGBA{0xF7, 0xF7, 0xF7, 0xFF}
+)
+
+// protocolColor returns the color used to draw the frames of each protocol
+func protocolColor(kind event.Kind) color.RGBA {
+	switch kind {
+	case event.ARP_REQUEST, event.ARP_REPLY:
+		return COLOR_ARP
+	case event.ECHO_REQUEST, event.ECHO_REPLY:
+		return COLOR_ICMP
+	case event.TIME_EXCEEDED:
+		return COLOR_TIME_EXCED
+	case event.RIP_RESPONSE, event.OSPF_HELLO, event.OSPF_LSU, event.BGP_UPDATE, event.LINK_DOWN:
+		return COLOR_ROUTING
+	case event.SWITCH_FORWARD, event.SWITCH_FLOOD:
+		return COLOR_SWITCH
+	}
+	return COLOR_RECEIVED
+}
+
+// canvas is what a renderer must know how to draw
+type canvas interface {
+	line(x1, y1, x2, y2 int, c color.RGBA, dashed bool)
+	rect(x, y, w, h int, stroke, fill color.RGBA, rounded bool)
+	arrowHead(x, y int, left bool, c color.RGBA)
+	// text writes s centered on cx, with the baseline on y
+	text(cx, y int, s string, c color.RGBA)
+}
+
+type diagramRow struct {
+	ev    event.Event
+	lines []string
+	y     int
+	h     int
+}
+
+// diagram is the layout of a sequence diagram, independent of the renderer
+type diagram struct {
+	entities []string
+	column   map[string]int
+	colWidth int
+	width    int
+	height   int
+	rows     []diagramRow
+}
+
+func textWidth(s string) int {
+	return len(s) * CHAR_WIDTH
+}
+
+func maxTextWidth(lines []string) int {
+	width := 0
+	for _, l := range lines {
+		if w := textWidth(l); w > width {
+			width = w
+		}
+	}
+	return width
+}
+
+// isNote tells if the event is drawn as a box over a single lifeline
+func isNote(ev event.Event) bool {
+	participants := ev.Participants()
+	return len(participants) == 1 || participants[0] == participants[1]
+}
+
+// isReply tells if the frame answers a previous one, drawn as a dotted arrow by
+// the formats that support it
+func isReply(ev event.Event) bool {
+	return ev.Kind == event.ARP_REPLY || ev.Kind == event.ECHO_REPLY
+}
+
+func newDiagram(entities []string, events []event.Event) *diagram {
+	d := &diagram{
+		entities: entities,
+		column:   make(map[string]int),
+		colWidth: MIN_COL_WIDTH,
+	}
+	for i, name := range entities {
+		d.column[name] = i
+	}
+
+	for _, ev := range events {
+		lines := strings.Split(ev.Label(), " \\n ")
+		row := diagramRow{ev: ev, lines: lines}
+		row.h = len(lines)*LINE_HEIGHT + 2*ROW_PADDING
+
+		width := maxTextWidth(lines) + 2*BOX_PADDING
+		if !isNote(ev) {
+			span := d.column[ev.Src] - d.column[ev.Dst]
+			if span < 0 {
+				span = -span
+			}
+			width = width/span + 2*BOX_PADDING
+		}
+		if width > d.colWidth {
+			d.colWidth = width
+		}
+		d.rows = append(d.rows, row)
+	}
+
+	y := MARGIN + ENTITY_HEIGHT + ROW_PADDING
+	for i := range d.rows {
+		d.rows[i].y = y
+		y += d.rows[i].h
+	}
+
+	d.width = 2*MARGIN + len(entities)*d.colWidth
+	d.height = y + MARGIN
+	return d
+}
+
+// x returns the position of the lifeline of an entity
+func (d *diagram) x(name string) int {
+	return MARGIN + d.column[name]*d.colWidth + d.colWidth/2
+}
+
+func (d *diagram) draw(c canvas) {
+	bottom := d.height - MARGIN
+	for _, name := range d.entities {
+		x := d.x(name)
+		c.line(x, MARGIN+ENTITY_HEIGHT, x, bottom, COLOR_LIFELINE, true)
+
+		w := textWidth(name) + 2*BOX_PADDING
+		c.rect(x-w/2, MARGIN, w, ENTITY_HEIGHT, COLOR_BLACK, COLOR_WHITE, false)
+		c.text(x, MARGIN+ENTITY_HEIGHT/2+LABEL_BASELINE, name, COLOR_BLACK)
+	}
+
+	for _, row := range d.rows {
+		color := protocolColor(row.ev.Kind)
+
+		if isNote(row.ev) {
+			x := d.x(row.ev.Src)
+			w := maxTextWidth(row.lines) + 2*BOX_PADDING
+			h := len(row.lines)*LINE_HEIGHT + BOX_PADDING
+			top := row.y + (row.h-h)/2
+			c.rect(x-w/2, top, w, h, color, COLOR_BOX_FILL, row.ev.Kind == event.RECEIVED)
+			for i, l := range row.lines {
+				c.text(x, top+BOX_PADDING/2+(i+1)*LINE_HEIGHT-LABEL_BASELINE, l, COLOR_BLACK)
+			}
+			continue
+		}
+
+		x1 := d.x(row.ev.Src)
+		x2 := d.x(row.ev.Dst)
+		arrowY := row.y + row.h - ROW_PADDING
+		c.line(x1, arrowY, x2, arrowY, color, false)
+		c.arrowHead(x2, arrowY, x2 < x1, color)
+		for i, l := range row.lines {
+			c.text((x1+x2)/2, row.y+(i+1)*LINE_HEIGHT-LABEL_BASELINE, l, color)
+		}
+	}
+}
+
+/*
+----------------------------------------------------
+Rendered outputs
+----------------------------------------------------
+*/
+
+type rendered struct {
+	buffer
+	render func(w io.Writer, d *diagram) error
+}
+
+func (r *rendered) Close() error {
+	d := newDiagram(r.participants(), r.events)
+	return r.render(r.w, d)
+}
+
+func newSvg(w io.Writer, entities []string) Emitter {
+	return &rendered{buffer{w: w, entities: entities}, renderSvg}
+}
+
+func newPng(w io.Writer, entities []string) Emitter {
+	return &rendered{buffer{w: w, entities: entities}, renderPng}
+}
@@ -0,0 +1,48 @@
+package output
+
+import (
+	"testing"
+
+	"github.com/arielril/network-simulator/internal/event"
+)
+
+// An arc over more than one column shares its width between them
+func TestDiagramLayout(t *testing.T) {
+	ev := testEvents[2]
+	ev.Dst = "n2"
+	d := newDiagram([]string{"n1", "r1", "n2"}, []event.Event{ev})
+
+	// the longest line of the label has 49 characters, over 2 columns
+	colWidth := (49*CHAR_WIDTH+2*BOX_PADDING)/2 + 2*BOX_PADDING
+	top := MARGIN + ENTITY_HEIGHT + ROW_PADDING
+	rowHeight := 3*LINE_HEIGHT + 2*ROW_PADDING
+
+	if d.colWidth != colWidth {
+		t.Errorf("Expected columns of %v, got %v", colWidth, d.colWidth)
+	}
+	if d.width != 2*MARGIN+3*colWidth || d.height != top+rowHeight+MARGIN {
+		t.Errorf("Expected a diagram of %vx%v, got %vx%v", 2*MARGIN+3*colWidth, top+rowHeight+MARGIN, d.width, d.height)
+	}
+	if row := d.rows[0]; row.y != top || row.h != rowHeight || len(row.lines) != 3 {
+		t.Errorf("Expected a row of 3 lines at %v with %v pixels, got %+v", top, rowHeight, row)
+	}
+	if x := d.x("r1"); x != MARGIN+colWidth+colWidth/2 {
+		t.Errorf("Expected the lifeline of r1 at %v, got %v", MARGIN+colWidth+colWidth/2, x)
+	}
+}
+
+func TestProtocolColor(t *testing.T) {
+	cases := map[event.Kind]string{
+		event.ARP_REPLY:     svgColor(COLOR_ARP),
+		event.ECHO_REQUEST:  svgColor(COLOR_ICMP),
+		event.TIME_EXCEEDED: svgColor(COLOR_TIME_EXCED),
+		event.RECEIVED:      svgColor(COLOR_RECEIVED),
+		event.RIP_RESPONSE:  svgColor(COLOR_ROUTING),
+		event.SWITCH_FLOOD:  svgColor(COLOR_SWITCH),
+	}
+	for kind, want := range cases {
+		if got := svgColor(protocolColor(kind)); got != want {
+			t.Errorf("%v: expected %v, got %v", kind, want, got)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package output
+
+import (
+	"encoding/json"
+	"io"
+)
+
+type jsonOutput struct {
+	buffer
+}
+
+func newJson(w io.Writer, entities []string) Emitter {
+	return &jsonOutput{buffer{w: w, entities: entities}}
+}
+
+// Close writes the events as a JSON array, one object per event
+func (j *jsonOutput) Close() error {
+	encoder := json.NewEncoder(j.w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(j.events)
+}
@@ -0,0 +1,73 @@
+package output
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Words with a meaning in a Mermaid sequence diagram, that can not be participant ids
+var mermaidKeywords = map[string]bool{
+	"participant": true, "actor": true, "as": true, "loop": true, "alt": true,
+	"else": true, "opt": true, "par": true, "and": true, "rect": true,
+	"end": true, "note": true, "over": true, "activate": true,
+	"deactivate": true, "autonumber": true, "critical": true, "break": true,
+	"box": true, "title": true, "left": true, "right": true, "of": true,
+}
+
+var mermaidEscaper = strings.NewReplacer(
+	"#", "#35;",
+	";", "#59;",
+	"<", "#lt;",
+	">", "#gt;",
+	"\n", " ",
+)
+
+// mermaidText escapes each line of a label and joins them with line breaks
+func mermaidText(label string) string {
+	lines := strings.Split(label, " \\n ")
+	for i, l := range lines {
+		lines[i] = mermaidEscaper.Replace(l)
+	}
+	return strings.Join(lines, "<br/>")
+}
+
+type mermaid struct {
+	buffer
+}
+
+func newMermaid(w io.Writer, entities []string) Emitter {
+	return &mermaid{buffer{w: w, entities: entities}}
+}
+
+func (m *mermaid) Close() error {
+	w := bufio.NewWriter(m.w)
+	fmt.Fprintln(w, "sequenceDiagram")
+
+	ids := make(map[string]string)
+	for i, name := range m.participants() {
+		if mscIdentifier.MatchString(name) && !mermaidKeywords[strings.ToLower(name)] {
+			ids[name] = name
+			fmt.Fprintf(w, "    participant %v\n", name)
+			continue
+		}
+		ids[name] = fmt.Sprintf("e%v", i)
+		fmt.Fprintf(w, "    participant %v as %v\n", ids[name], mermaidEscaper.Replace(name))
+	}
+
+	for _, ev := range m.events {
+		label := mermaidText(ev.Label())
+		if isNote(ev) {
+			fmt.Fprintf(w, "    Note over %v: %v\n", ids[ev.Src], label)
+			continue
+		}
+		arrow := "->>"
+		if isReply(ev) {
+			arrow = "-->>"
+		}
+		fmt.Fprintf(w, "    %v%v%v: %v\n", ids[ev.Src], arrow, ids[ev.Dst], label)
+	}
+
+	return w.Flush()
+}
@@ -0,0 +1,39 @@
+package output
+
+import (
+	"testing"
+
+	"github.com/arielril/network-simulator/internal/event"
+)
+
+func TestMermaid(t *testing.T) {
+	cases := []struct {
+		events []event.Event
+		want   string
+	}{
+		{testEvents, `sequenceDiagram
+    participant n1
+    participant r1
+    Note over n1: ETH (src=00:00:00:00:00:01 dst=FF:FF:FF:FF:FF:FF)<br/>ARP - Who has 10.0.0.1? Tell 10.0.0.2
+    r1-->>n1: ETH (src=00:00:00:00:00:05 dst=00:00:00:00:00:01)<br/>ARP - 10.0.0.1 is at 00:00:00:00:00:05
+    n1->>r1: ETH (src=00:00:00:00:00:01 dst=00:00:00:00:00:05)<br/>IP (src=10.0.0.2 dst=10.0.0.1 ttl=8 mf=0 off=0)<br/>ICMP - Echo request (data=hi)
+    Note over r1: Received hi
+`},
+		// names that are not identifiers, or are keywords, get an id
+		{[]event.Event{
+			{Kind: event.RECEIVED, Src: "node 1", Data: "a;b<c>#1"},
+			{Kind: event.RECEIVED, Src: "end", Data: "hi"},
+		}, `sequenceDiagram
+    participant e0 as node 1
+    participant e1 as end
+    Note over e0: Received a#59;b#lt;c#gt;#35;1
+    Note over e1: Received hi
+`},
+	}
+
+	for _, tc := range cases {
+		if got := render(t, MERMAID, nil, tc.events); got != tc.want {
+			t.Errorf("Expected:\n%v\nGot:\n%v", tc.want, got)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package output
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/arielril/network-simulator/internal/event"
+)
+
+// Options recommended by the assignment to view the output on mscgen_js
+const (
+	MSC_WORD_WRAP_ARCS = "true"
+	MSC_HSCALE         = "2.5"
+)
+
+var mscIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// mscQuote quotes a string for MsGenny/MscGen, escaping inner quotes
+func mscQuote(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
+
+// mscEntity returns the name as an entity identifier, quoted when needed
+func mscEntity(name string) string {
+	if mscIdentifier.MatchString(name) {
+		return name
+	}
+	return mscQuote(name)
+}
+
+/*
+----------------------------------------------------
+MsGenny document
+----------------------------------------------------
+*/
+
+type msGenny struct {
+	buffer
+}
+
+func newMsGenny(w io.Writer, entities []string) Emitter {
+	return &msGenny{buffer{w: w, entities: entities}}
+}
+
+// msGennyLabel keeps the label as the simulator prints it, quoting it only
+// when it has characters that would end the arc early
+func msGennyLabel(label string) string {
+	if strings.ContainsAny(label, `,;"{}[]`) {
+		return mscQuote(label)
+	}
+	return label
+}
+
+func (m *msGenny) Close() error {
+	w := bufio.NewWriter(m.w)
+
+	fmt.Fprintf(w, "wordwraparcs=%v, hscale=%v;\n", MSC_WORD_WRAP_ARCS, MSC_HSCALE)
+
+	entities := m.participants()
+	names := make([]string, len(entities))
+	for i, name := range entities {
+		names[i] = mscEntity(name)
+	}
+	fmt.Fprintf(w, "%v;\n", strings.Join(names, ", "))
+
+	for _, ev := range m.events {
+		fmt.Fprintf(w, "%v : %v;\n", mscArc(ev, mscEntity), msGennyLabel(ev.Label()))
+	}
+
+	return w.Flush()
+}
+
+// mscArc writes the arc of the event with the quoting rules of the dialect
+func mscArc(ev event.Event, entity func(string) string) string {
+	participants := ev.Participants()
+	return fmt.Sprintf(
+		"%v %v %v",
+		entity(participants[0]), ev.Operator(), entity(participants[len(participants)-1]),
+	)
+}
+
+/*
+----------------------------------------------------
+MscGen document
+----------------------------------------------------
+*/
+
+type mscGen struct {
+	buffer
+}
+
+func newMscGen(w io.Writer, entities []string) Emitter {
+	return &mscGen{buffer{w: w, entities: entities}}
+}
+
+func (m *mscGen) Close() error {
+	w := bufio.NewWriter(m.w)
+
+	fmt.Fprintln(w, "msc {")
+	fmt.Fprintf(w, "  wordwraparcs=%v, hscale=%v;\n\n", MSC_WORD_WRAP_ARCS, mscQuote(MSC_HSCALE))
+
+	entities := m.participants()
+	names := make([]string, len(entities))
+	for i, name := range entities {
+		names[i] = mscQuote(name)
+	}
+	fmt.Fprintf(w, "  %v;\n\n", strings.Join(names, ", "))
+
+	for _, ev := range m.events {
+		fmt.Fprintf(
+			w, "  %v [label=%v];\n",
+			mscArc(ev, mscQuote), mscQuote(ev.Label()),
+		)
+	}
+	fmt.Fprintln(w, "}")
+
+	return w.Flush()
+}
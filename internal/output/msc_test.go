@@ -0,0 +1,70 @@
+package output
+
+import (
+	"testing"
+
+	"github.com/arielril/network-simulator/internal/event"
+)
+
+// Names and payloads with characters of the syntax are quoted
+var quotedEvents = []event.Event{
+	{Kind: event.RECEIVED, Src: "node 1", Data: `say "hi"; bye`},
+}
+
+func TestMsGenny(t *testing.T) {
+	cases := []struct {
+		events []event.Event
+		want   string
+	}{
+		{testEvents, `wordwraparcs=true, hscale=2.5;
+n1, r1;
+n1 box n1 : ETH (src=00:00:00:00:00:01 dst=FF:FF:FF:FF:FF:FF) \n ARP - Who has 10.0.0.1? Tell 10.0.0.2;
+r1 => n1 : ETH (src=00:00:00:00:00:05 dst=00:00:00:00:00:01) \n ARP - 10.0.0.1 is at 00:00:00:00:00:05;
+n1 => r1 : ETH (src=00:00:00:00:00:01 dst=00:00:00:00:00:05) \n IP (src=10.0.0.2 dst=10.0.0.1 ttl=8 mf=0 off=0) \n ICMP - Echo request (data=hi);
+r1 rbox r1 : Received hi;
+`},
+		{quotedEvents, `wordwraparcs=true, hscale=2.5;
+"node 1";
+"node 1" rbox "node 1" : "Received say \"hi\"; bye";
+`},
+	}
+
+	for _, tc := range cases {
+		if got := render(t, MSGENNY, testEntities, tc.events); got != tc.want {
+			t.Errorf("Expected:\n%v\nGot:\n%v", tc.want, got)
+		}
+	}
+}
+
+func TestMscGen(t *testing.T) {
+	cases := []struct {
+		events []event.Event
+		want   string
+	}{
+		{testEvents, `msc {
+  wordwraparcs=true, hscale="2.5";
+
+  "n1", "r1";
+
+  "n1" box "n1" [label="ETH (src=00:00:00:00:00:01 dst=FF:FF:FF:FF:FF:FF) \n ARP - Who has 10.0.0.1? Tell 10.0.0.2"];
+  "r1" => "n1" [label="ETH (src=00:00:00:00:00:05 dst=00:00:00:00:00:01) \n ARP - 10.0.0.1 is at 00:00:00:00:00:05"];
+  "n1" => "r1" [label="ETH (src=00:00:00:00:00:01 dst=00:00:00:00:00:05) \n IP (src=10.0.0.2 dst=10.0.0.1 ttl=8 mf=0 off=0) \n ICMP - Echo request (data=hi)"];
+  "r1" rbox "r1" [label="Received hi"];
+}
+`},
+		{quotedEvents, `msc {
+  wordwraparcs=true, hscale="2.5";
+
+  "node 1";
+
+  "node 1" rbox "node 1" [label="Received say \"hi\"; bye"];
+}
+`},
+	}
+
+	for _, tc := range cases {
+		if got := render(t, MSCGEN, testEntities, tc.events); got != tc.want {
+			t.Errorf("Expected:\n%v\nGot:\n%v", tc.want, got)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package output
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/arielril/network-simulator/internal/event"
+)
+
+const (
+	TEXT     string = "text"
+	MSGENNY  string = "msgenny"
+	MSCGEN   string = "mscgen"
+	SVG      string = "svg"
+	PNG      string = "png"
+	MERMAID  string = "mermaid"
+	PLANTUML string = "plantuml"
+	JSON     string = "json"
+	PCAP     string = "pcap"
+)
+
+// Emitter receives the events of a simulation and writes them in some format.
+// Close must be called once the simulation ends so buffered formats can be written.
+type Emitter interface {
+	event.Sink
+	Close() error
+}
+
+type emitterFactory func(w io.Writer, entities []string) Emitter
+
+var formats = map[string]emitterFactory{
+	TEXT:     newText,
+	MSGENNY:  newMsGenny,
+	MSCGEN:   newMscGen,
+	SVG:      newSvg,
+	PNG:      newPng,
+	MERMAID:  newMermaid,
+	PLANTUML: newPlantUml,
+	JSON:     newJson,
+	PCAP:     newPcap,
+}
+
+// Formats returns the name of every supported output format
+func Formats() []string {
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New creates the emitter for the format. The entities are the names of the
+// components of the topology, in the order they were declared.
+func New(format string, w io.Writer, entities []string) (Emitter, error) {
+	factory, ok := formats[format]
+	if !ok {
+		return nil, fmt.Errorf("Unknown output format %q, expected one of %v", format, Formats())
+	}
+	return factory(w, entities), nil
+}
+
+/*
+----------------------------------------------------
+Plain text output
+----------------------------------------------------
+*/
+
+type text struct {
+	event.Writer
+}
+
+func newText(w io.Writer, entities []string) Emitter {
+	return &text{event.Writer{W: w}}
+}
+
+func (t *text) Close() error {
+	return nil
+}
+
+/*
+----------------------------------------------------
+Buffered output
+----------------------------------------------------
+*/
+
+// buffer keeps the events until the end of the simulation, for formats that
+// must declare the participants before the first arc
+type buffer struct {
+	w        io.Writer
+	entities []string
+	events   []event.Event
+}
+
+func (b *buffer) Emit(ev event.Event) {
+	b.events = append(b.events, ev)
+}
+
+// participants returns the entities seen in the events, in topology order.
+// Names missing from the topology are appended in order of appearance.
+func (b *buffer) participants() []string {
+	seen := make(map[string]bool)
+	for _, ev := range b.events {
+		for _, name := range ev.Participants() {
+			seen[name] = true
+		}
+	}
+
+	list := make([]string, 0, len(seen))
+	for _, name := range b.entities {
+		if seen[name] {
+			list = append(list, name)
+			delete(seen, name)
+		}
+	}
+	for _, ev := range b.events {
+		for _, name := range ev.Participants() {
+			if seen[name] {
+				list = append(list, name)
+				delete(seen, name)
+			}
+		}
+	}
+	return list
+}
@@ -0,0 +1,74 @@
+package output
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/arielril/network-simulator/internal/event"
+)
+
+// A ping from n1 to its gateway r1, with the ARP resolution
+var (
+	testEntities = []string{"n1", "r1"}
+	testEvents   = []event.Event{
+		{Kind: event.ARP_REQUEST, Src: "n1", SrcMac: "00:00:00:00:00:01", DstMac: "FF:FF:FF:FF:FF:FF", SrcIp: "10.0.0.2", DstIp: "10.0.0.1"},
+		{Kind: event.ARP_REPLY, Src: "r1", Dst: "n1", SrcMac: "00:00:00:00:00:05", DstMac: "00:00:00:00:00:01", SrcIp: "10.0.0.1", DstIp: "10.0.0.2"},
+		{Kind: event.ECHO_REQUEST, Src: "n1", Dst: "r1", SrcMac: "00:00:00:00:00:01", DstMac: "00:00:00:00:00:05", SrcIp: "10.0.0.2", DstIp: "10.0.0.1", Ttl: 8, Data: "hi"},
+		{Kind: event.RECEIVED, Src: "r1", Data: "hi"},
+	}
+)
+
+// render writes the events on the format and returns the document
+func render(t *testing.T, format string, entities []string, events []event.Event) string {
+	t.Helper()
+	var buf bytes.Buffer
+	out, err := New(format, &buf, entities)
+	if err != nil {
+		t.Fatalf("Failed to create the %v output: %v", format, err)
+	}
+	for _, ev := range events {
+		out.Emit(ev)
+	}
+	if err := out.Close(); err != nil {
+		t.Fatalf("Failed to write the %v output: %v", format, err)
+	}
+	return buf.String()
+}
+
+func TestUnknownFormat(t *testing.T) {
+	if _, err := New("bogus", &bytes.Buffer{}, nil); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+}
+
+func TestText(t *testing.T) {
+	got := render(t, TEXT, testEntities, testEvents)
+	want := ""
+	for _, ev := range testEvents {
+		want += ev.String() + "\n"
+	}
+	if got != want {
+		t.Errorf("Expected:\n%v\nGot:\n%v", want, got)
+	}
+}
+
+// Entities are listed in topology order, and only when they appear
+func TestParticipants(t *testing.T) {
+	b := buffer{entities: []string{"r1", "n2", "n1"}}
+	for _, ev := range testEvents {
+		b.Emit(ev)
+	}
+	b.Emit(event.Event{Kind: event.RECEIVED, Src: "n9"})
+
+	got := b.participants()
+	want := []string{"r1", "n1", "n9"}
+	if len(got) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Expected %v, got %v", want, got)
+			break
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package output
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+
+	"github.com/arielril/network-simulator/internal/event"
+)
+
+// Values of the libpcap file format and of the protocol headers
+const (
+	PCAP_MAGIC         uint32 = 0xA1B2C3D4
+	PCAP_SNAPLEN       uint32 = 65535
+	PCAP_LINK_ETHERNET uint32 = 1
+	// Interval between the timestamps of consecutive frames
+	PCAP_FRAME_INTERVAL_USEC uint32 = 1000
+
+	ETHERTYPE_IPV4 uint16 = 0x0800
+	ETHERTYPE_ARP  uint16 = 0x0806
+	ETHERTYPE_VLAN uint16 = 0x8100
+
+	ARP_OPER_REQUEST uint16 = 1
+	ARP_OPER_REPLY   uint16 = 2
+
+	IP_PROTOCOL_ICMP uint8  = 1
+	IP_FLAG_MF       uint16 = 0x2000
+
+	ICMP_ECHO_REPLY    uint8 = 0
+	ICMP_ECHO_REQUEST  uint8 = 8
+	ICMP_TIME_EXCEEDED uint8 = 11
+	// Identifier and sequence number of the echo messages
+	ICMP_ECHO_ID  uint16 = 1
+	ICMP_ECHO_SEQ uint16 = 1
+)
+
+// pcapOutput writes the frames of the simulation as an Ethernet capture, to be
+// opened on Wireshark or tcpdump. The final processing events are not frames
+// and are skipped.
+type pcapOutput struct {
+	buffer
+}
+
+func newPcap(w io.Writer, entities []string) Emitter {
+	return &pcapOutput{buffer{w: w, entities: entities}}
+}
+
+func macBytes(mac string) []byte {
+	hw, err := net.ParseMAC(mac)
+	if err != nil || len(hw) != 6 {
+		return make([]byte, 6)
+	}
+	return hw
+}
+
+func ipBytes(ip string) []byte {
+	addr := net.ParseIP(ip).To4()
+	if addr == nil {
+		return make([]byte, 4)
+	}
+	return addr
+}
+
+func checksum(data []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(data[i:]))
+	}
+	if len(data)%2 == 1 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = (sum & 0xFFFF) + (sum >> 16)
+	}
+	return ^uint16(sum)
+}
+
+func ethernetFrame(ev event.Event, ethertype uint16, payload []byte) []byte {
+	frame := make([]byte, 0, 14+len(payload))
+	frame = append(frame, macBytes(ev.DstMac)...)
+	frame = append(frame, macBytes(ev.SrcMac)...)
+	if ev.Vlan != 0 {
+		// 802.1Q tag, with the priority and the DEI left at 0
+		tag := make([]byte, 4)
+		binary.BigEndian.PutUint16(tag, ETHERTYPE_VLAN)
+		binary.BigEndian.PutUint16(tag[2:], ev.Vlan)
+		frame = append(frame, tag...)
+	}
+	frame = append(frame, byte(ethertype>>8), byte(ethertype))
+	return append(frame, payload...)
+}
+
+func arpPacket(oper uint16, senderMac, senderIp, targetMac, targetIp []byte) []byte {
+	pkt := []byte{0, 1, 0x08, 0x00, 6, 4, byte(oper >> 8), byte(oper)}
+	pkt = append(pkt, senderMac...)
+	pkt = append(pkt, senderIp...)
+	pkt = append(pkt, targetMac...)
+	return append(pkt, targetIp...)
+}
+
+func icmpPacket(ev event.Event) []byte {
+	var pkt []byte
+	switch ev.Kind {
+	case event.ECHO_REQUEST, event.ECHO_REPLY:
+		typ := ICMP_ECHO_REQUEST
+		if ev.Kind == event.ECHO_REPLY {
+			typ = ICMP_ECHO_REPLY
+		}
+		pkt = []byte{
+			typ, 0, 0, 0,
+			byte(ICMP_ECHO_ID >> 8), byte(ICMP_ECHO_ID),
+			byte(ICMP_ECHO_SEQ >> 8), byte(ICMP_ECHO_SEQ),
+		}
+		pkt = append(pkt, ev.Data...)
+	default:
+		pkt = []byte{ICMP_TIME_EXCEEDED, 0, 0, 0, 0, 0, 0, 0}
+	}
+	binary.BigEndian.PutUint16(pkt[2:], checksum(pkt))
+	return pkt
+}
+
+// ipv4Packet builds the datagram. The IP offset field counts blocks of 8 bytes,
+// so the byte offsets of the simulator are rounded down to fit on it.
+func ipv4Packet(ev event.Event, id uint16, payload []byte) []byte {
+	flags := uint16(ev.Off) / 8
+	if ev.Mf == 1 {
+		flags |= IP_FLAG_MF
+	}
+
+	header := make([]byte, 20)
+	header[0] = 0x45
+	binary.BigEndian.PutUint16(header[2:], uint16(20+len(payload)))
+	binary.BigEndian.PutUint16(header[4:], id)
+	binary.BigEndian.PutUint16(header[6:], flags)
+	header[8] = ev.Ttl
+	header[9] = IP_PROTOCOL_ICMP
+	copy(header[12:], ipBytes(ev.SrcIp))
+	copy(header[16:], ipBytes(ev.DstIp))
+	binary.BigEndian.PutUint16(header[10:], checksum(header))
+
+	return append(header, payload...)
+}
+
+func (p *pcapOutput) Close() error {
+	w := bufio.NewWriter(p.w)
+	le := binary.LittleEndian
+
+	header := make([]byte, 24)
+	le.PutUint32(header[0:], PCAP_MAGIC)
+	le.PutUint16(header[4:], 2)
+	le.PutUint16(header[6:], 4)
+	le.PutUint32(header[16:], PCAP_SNAPLEN)
+	le.PutUint32(header[20:], PCAP_LINK_ETHERNET)
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	// IPs learned from the ARP requests, to fill the target of the replies
+	arpIps := make(map[string]string)
+	var datagramId uint16
+	var usec uint32
+
+	for _, ev := range p.events {
+		var frame []byte
+		switch ev.Kind {
+		case event.ARP_REQUEST:
+			arpIps[ev.SrcMac] = ev.SrcIp
+			arp := arpPacket(ARP_OPER_REQUEST, macBytes(ev.SrcMac), ipBytes(ev.SrcIp), make([]byte, 6), ipBytes(ev.DstIp))
+			frame = ethernetFrame(ev, ETHERTYPE_ARP, arp)
+		case event.ARP_REPLY:
+			arp := arpPacket(ARP_OPER_REPLY, macBytes(ev.SrcMac), ipBytes(ev.SrcIp), macBytes(ev.DstMac), ipBytes(arpIps[ev.DstMac]))
+			frame = ethernetFrame(ev, ETHERTYPE_ARP, arp)
+		case event.ECHO_REQUEST, event.ECHO_REPLY, event.TIME_EXCEEDED:
+			if ev.Off == 0 {
+				datagramId++
+			}
+			frame = ethernetFrame(ev, ETHERTYPE_IPV4, ipv4Packet(ev, datagramId, icmpPacket(ev)))
+		default:
+			continue
+		}
+
+		record := make([]byte, 16)
+		le.PutUint32(record[0:], usec/1000000)
+		le.PutUint32(record[4:], usec%1000000)
+		le.PutUint32(record[8:], uint32(len(frame)))
+		le.PutUint32(record[12:], uint32(len(frame)))
+		if _, err := w.Write(append(record, frame...)); err != nil {
+			return err
+		}
+		usec += PCAP_FRAME_INTERVAL_USEC
+	}
+
+	return w.Flush()
+}
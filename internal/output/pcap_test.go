@@ -0,0 +1,58 @@
+package output
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestPcap(t *testing.T) {
+	capture := []byte(render(t, PCAP, testEntities, testEvents))
+
+	header := []byte{
+		0xD4, 0xC3, 0xB2, 0xA1, 2, 0, 4, 0,
+		0, 0, 0, 0, 0, 0, 0, 0,
+		0xFF, 0xFF, 0, 0, 1, 0, 0, 0,
+	}
+	arpRequest := []byte{
+		// record: time 0 and the length of the frame, twice
+		0, 0, 0, 0, 0, 0, 0, 0, 42, 0, 0, 0, 42, 0, 0, 0,
+		// ethernet
+		0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0, 0, 0, 0, 0, 1, 0x08, 0x06,
+		// arp request, with the target MAC unknown
+		0, 1, 0x08, 0x00, 6, 4, 0, 1,
+		0, 0, 0, 0, 0, 1, 10, 0, 0, 2,
+		0, 0, 0, 0, 0, 0, 10, 0, 0, 1,
+	}
+	want := append(header, arpRequest...)
+	if !bytes.HasPrefix(capture, want) {
+		t.Fatalf("Expected the capture to start with\n% x\nGot\n% x", want, capture[:len(want)])
+	}
+
+	// the received event is not a frame
+	records := 0
+	var echo []byte
+	for rest := capture[len(header):]; len(rest) >= 16; records++ {
+		size := binary.LittleEndian.Uint32(rest[8:])
+		if usec := binary.LittleEndian.Uint32(rest[4:]); usec != uint32(records)*PCAP_FRAME_INTERVAL_USEC {
+			t.Errorf("Record %v: expected the time %vus, got %vus", records, uint32(records)*PCAP_FRAME_INTERVAL_USEC, usec)
+		}
+		echo = rest[16 : 16+size]
+		rest = rest[16+size:]
+	}
+	if records != 3 {
+		t.Fatalf("Expected 3 frames, got %v", records)
+	}
+
+	// the last frame is the echo request, with valid checksums
+	ip := echo[14:]
+	if binary.BigEndian.Uint16(echo[12:]) != ETHERTYPE_IPV4 || ip[8] != 8 || ip[9] != IP_PROTOCOL_ICMP {
+		t.Errorf("Expected an ICMP datagram with ttl 8, got % x", ip)
+	}
+	if checksum(ip[:20]) != 0 || checksum(ip[20:]) != 0 {
+		t.Errorf("Invalid checksums on % x", ip)
+	}
+	if icmp := ip[20:]; icmp[0] != ICMP_ECHO_REQUEST || string(icmp[8:]) != "hi" {
+		t.Errorf("Expected an echo request with data hi, got % x", icmp)
+	}
+}
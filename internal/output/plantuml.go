@@ -0,0 +1,82 @@
+package output
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/arielril/network-simulator/internal/event"
+)
+
+// plantUmlEscapes keep backslashes literal and disable the creole markup
+// (bold, italic, links, html tags) that could be written in a payload
+var plantUmlEscapes = []string{
+	`\`, `\\`,
+	"~", "~~",
+	"<", "~<",
+	"[", "~[",
+	"**", "~**",
+	"//", "~//",
+	"__", "~__",
+	"--", "~--",
+	`""`, `~""`,
+	"^^", "~^^",
+	"\n", " ",
+}
+
+var plantUmlEscaper = strings.NewReplacer(plantUmlEscapes...)
+
+// PlantUML strings have no escape for the quote, so inside quoted names it is
+// written as its unicode code point
+var plantUmlNameEscaper = strings.NewReplacer(append([]string{`"`, "<U+0022>"}, plantUmlEscapes...)...)
+
+// plantUmlQuote quotes a participant name
+func plantUmlQuote(name string) string {
+	return `"` + plantUmlNameEscaper.Replace(name) + `"`
+}
+
+// plantUmlText escapes each line of a label and joins them with line breaks
+func plantUmlText(label string) string {
+	lines := strings.Split(label, " \\n ")
+	for i, l := range lines {
+		lines[i] = plantUmlEscaper.Replace(l)
+	}
+	return strings.Join(lines, `\n`)
+}
+
+type plantUml struct {
+	buffer
+}
+
+func newPlantUml(w io.Writer, entities []string) Emitter {
+	return &plantUml{buffer{w: w, entities: entities}}
+}
+
+func (p *plantUml) Close() error {
+	w := bufio.NewWriter(p.w)
+	fmt.Fprintln(w, "@startuml")
+
+	ids := make(map[string]string)
+	for i, name := range p.participants() {
+		ids[name] = fmt.Sprintf("e%v", i)
+		fmt.Fprintf(w, "participant %v as %v\n", plantUmlQuote(name), ids[name])
+	}
+
+	for _, ev := range p.events {
+		label := plantUmlText(ev.Label())
+		switch {
+		case ev.Kind == event.RECEIVED:
+			fmt.Fprintf(w, "note over %v : %v\n", ids[ev.Src], label)
+		case isNote(ev):
+			fmt.Fprintf(w, "rnote over %v : %v\n", ids[ev.Src], label)
+		case isReply(ev):
+			fmt.Fprintf(w, "%v --> %v : %v\n", ids[ev.Src], ids[ev.Dst], label)
+		default:
+			fmt.Fprintf(w, "%v -> %v : %v\n", ids[ev.Src], ids[ev.Dst], label)
+		}
+	}
+
+	fmt.Fprintln(w, "@enduml")
+	return w.Flush()
+}
@@ -0,0 +1,38 @@
+package output
+
+import (
+	"testing"
+
+	"github.com/arielril/network-simulator/internal/event"
+)
+
+func TestPlantUml(t *testing.T) {
+	cases := []struct {
+		events []event.Event
+		want   string
+	}{
+		{testEvents, `@startuml
+participant "n1" as e0
+participant "r1" as e1
+rnote over e0 : ETH (src=00:00:00:00:00:01 dst=FF:FF:FF:FF:FF:FF)\nARP - Who has 10.0.0.1? Tell 10.0.0.2
+e1 --> e0 : ETH (src=00:00:00:00:00:05 dst=00:00:00:00:00:01)\nARP - 10.0.0.1 is at 00:00:00:00:00:05
+e0 -> e1 : ETH (src=00:00:00:00:00:01 dst=00:00:00:00:00:05)\nIP (src=10.0.0.2 dst=10.0.0.1 ttl=8 mf=0 off=0)\nICMP - Echo request (data=hi)
+note over e1 : Received hi
+@enduml
+`},
+		// quotes can not be escaped on names, and payloads keep the markup literal
+		{[]event.Event{
+			{Kind: event.RECEIVED, Src: `r"1\`, Data: `**bold** <b> c:\dir`},
+		}, `@startuml
+participant "r<U+0022>1\\" as e0
+note over e0 : Received ~**bold~** ~<b> c:\\dir
+@enduml
+`},
+	}
+
+	for _, tc := range cases {
+		if got := render(t, PLANTUML, nil, tc.events); got != tc.want {
+			t.Errorf("Expected:\n%v\nGot:\n%v", tc.want, got)
+		}
+	}
+}
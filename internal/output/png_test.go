@@ -0,0 +1,43 @@
+package output
+
+import (
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestPng(t *testing.T) {
+	img, err := png.Decode(strings.NewReader(render(t, PNG, testEntities, testEvents)))
+	if err != nil {
+		t.Fatalf("Invalid PNG: %v", err)
+	}
+
+	// same layout as the SVG document
+	if size := img.Bounds().Size(); size.X != 676 || size.Y != 250 {
+		t.Errorf("Expected an image of 676x250, got %vx%v", size.X, size.Y)
+	}
+
+	pixels := []struct {
+		x, y int
+		want color.RGBA
+	}{
+		{0, 0, COLOR_WHITE},
+		// border of the entity box of n1
+		{167, 20, COLOR_BLACK},
+		// border and fill of the ARP request note
+		{26, 61, COLOR_ARP},
+		{27, 62, COLOR_BOX_FILL},
+		// arrows of the ARP reply and of the echo request
+		{338, 132, COLOR_ARP},
+		{338, 188, COLOR_ICMP},
+		// cut corner of the rounded received box
+		{458, 205, COLOR_WHITE},
+		{459, 205, COLOR_RECEIVED},
+	}
+	for _, p := range pixels {
+		if got := color.RGBAModel.Convert(img.At(p.x, p.y)); got != p.want {
+			t.Errorf("Pixel %v,%v: expected %v, got %v", p.x, p.y, p.want, got)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package output
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"image/color"
+	"io"
+	"strings"
+)
+
+const SVG_FONT_SIZE int = 10
+
+func svgColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+func svgEscape(s string) string {
+	var sb strings.Builder
+	_ = xml.EscapeText(&sb, []byte(s))
+	return sb.String()
+}
+
+type svgCanvas struct {
+	w *bufio.Writer
+}
+
+func (s svgCanvas) line(x1, y1, x2, y2 int, c color.RGBA, dashed bool) {
+	dash := ""
+	if dashed {
+		dash = ` stroke-dasharray="4,4"`
+	}
+	fmt.Fprintf(
+		s.w, "  <line x1=\"%v\" y1=\"%v\" x2=\"%v\" y2=\"%v\" stroke=\"%v\"%v/>\n",
+		x1, y1, x2, y2, svgColor(c), dash,
+	)
+}
+
+func (s svgCanvas) rect(x, y, w, h int, stroke, fill color.RGBA, rounded bool) {
+	radius := 0
+	if rounded {
+		radius = BOX_PADDING
+	}
+	fmt.Fprintf(
+		s.w, "  <rect x=\"%v\" y=\"%v\" width=\"%v\" height=\"%v\" rx=\"%v\" stroke=\"%v\" fill=\"%v\"/>\n",
+		x, y, w, h, radius, svgColor(stroke), svgColor(fill),
+	)
+}
+
+func (s svgCanvas) arrowHead(x, y int, left bool, c color.RGBA) {
+	back := x - ARROW_SIZE
+	if left {
+		back = x + ARROW_SIZE
+	}
+	fmt.Fprintf(
+		s.w, "  <polygon points=\"%v,%v %v,%v %v,%v\" fill=\"%v\"/>\n",
+		x, y, back, y-ARROW_SIZE/2, back, y+ARROW_SIZE/2, svgColor(c),
+	)
+}
+
+func (s svgCanvas) text(cx, y int, str string, c color.RGBA) {
+	fmt.Fprintf(
+		s.w, "  <text x=\"%v\" y=\"%v\" fill=\"%v\" text-anchor=\"middle\">%v</text>\n",
+		cx, y, svgColor(c), svgEscape(str),
+	)
+}
+
+func renderSvg(w io.Writer, d *diagram) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(
+		bw, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%v\" height=\"%v\" viewBox=\"0 0 %v %v\" font-family=\"monospace\" font-size=\"%v\">\n",
+		d.width, d.height, d.width, d.height, SVG_FONT_SIZE,
+	)
+	fmt.Fprintf(bw, "  <rect width=\"100%%\" height=\"100%%\" fill=\"%v\"/>\n", svgColor(COLOR_WHITE))
+	d.draw(svgCanvas{bw})
+	fmt.Fprintln(bw, "</svg>")
+	return bw.Flush()
+}
@@ -0,0 +1,39 @@
+package output
+
+import "testing"
+
+func TestSvg(t *testing.T) {
+	want := `<svg xmlns="http://www.w3.org/2000/svg" width="676" height="250" viewBox="0 0 676 250" font-family="monospace" font-size="10">
+  <rect width="100%" height="100%" fill="#ffffff"/>
+  <line x1="179" y1="44" x2="179" y2="230" stroke="#999999" stroke-dasharray="4,4"/>
+  <rect x="167" y="20" width="24" height="24" rx="0" stroke="#000000" fill="#ffffff"/>
+  <text x="179" y="35" fill="#000000" text-anchor="middle">n1</text>
+  <line x1="497" y1="44" x2="497" y2="230" stroke="#999999" stroke-dasharray="4,4"/>
+  <rect x="485" y="20" width="24" height="24" rx="0" stroke="#000000" fill="#ffffff"/>
+  <text x="497" y="35" fill="#000000" text-anchor="middle">r1</text>
+  <rect x="26" y="61" width="306" height="30" rx="0" stroke="#1f77b4" fill="#f7f7f7"/>
+  <text x="179" y="73" fill="#000000" text-anchor="middle">ETH (src=00:00:00:00:00:01 dst=FF:FF:FF:FF:FF:FF)</text>
+  <text x="179" y="85" fill="#000000" text-anchor="middle">ARP - Who has 10.0.0.1? Tell 10.0.0.2</text>
+  <line x1="497" y1="132" x2="179" y2="132" stroke="#1f77b4"/>
+  <polygon points="179,132 185,129 185,135" fill="#1f77b4"/>
+  <text x="338" y="107" fill="#1f77b4" text-anchor="middle">ETH (src=00:00:00:00:00:05 dst=00:00:00:00:00:01)</text>
+  <text x="338" y="119" fill="#1f77b4" text-anchor="middle">ARP - 10.0.0.1 is at 00:00:00:00:00:05</text>
+  <line x1="179" y1="188" x2="497" y2="188" stroke="#2ca02c"/>
+  <polygon points="497,188 491,185 491,191" fill="#2ca02c"/>
+  <text x="338" y="151" fill="#2ca02c" text-anchor="middle">ETH (src=00:00:00:00:00:01 dst=00:00:00:00:00:05)</text>
+  <text x="338" y="163" fill="#2ca02c" text-anchor="middle">IP (src=10.0.0.2 dst=10.0.0.1 ttl=8 mf=0 off=0)</text>
+  <text x="338" y="175" fill="#2ca02c" text-anchor="middle">ICMP - Echo request (data=hi)</text>
+  <rect x="458" y="205" width="78" height="18" rx="6" stroke="#9467bd" fill="#f7f7f7"/>
+  <text x="497" y="217" fill="#000000" text-anchor="middle">Received hi</text>
+</svg>
+`
+	if got := render(t, SVG, testEntities, testEvents); got != want {
+		t.Errorf("Expected:\n%v\nGot:\n%v", want, got)
+	}
+}
+
+func TestSvgEscape(t *testing.T) {
+	if got := svgEscape(`a<b & "c"`); got != "a&lt;b &amp; &#34;c&#34;" {
+		t.Errorf("Unexpected escaped text %v", got)
+	}
+}
@@ -0,0 +1,361 @@
+package simulator
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/urfave/cli"
+)
+
+/*
+----------------------------------------------------
+Forwarding paths
+----------------------------------------------------
+*/
+
+type forwardStatus uint8
+
+const (
+	// the packet reaches the device with the destination IP
+	FORWARD_DELIVERED forwardStatus = iota + 1
+	// the routers send the packet to each other until its TTL expires
+	FORWARD_LOOP
+	// a router has no route to the destination, or can not resolve the next hop
+	FORWARD_BLACK_HOLE
+)
+
+// forwardPath is the path a packet takes from a router to a destination,
+// following the router tables without sending any frame
+type forwardPath struct {
+	status forwardStatus
+	// Routers that forward the packet, in order, without the destination
+	routers []string
+	// Device that receives the packet, when delivered
+	device string
+	// Repeated routers, starting and ending on the same one, for loops
+	cycle []string
+	// Router that drops the packet and why, for black holes
+	router string
+	reason string
+}
+
+func (p forwardPath) String() string {
+	switch p.status {
+	case FORWARD_DELIVERED:
+		return strings.Join(append(append([]string{}, p.routers...), p.device), " -> ")
+	case FORWARD_LOOP:
+		return "loop " + strings.Join(p.cycle, " -> ")
+	}
+	return fmt.Sprintf("%v: black hole at %v, %v", strings.Join(p.routers, " -> "), p.router, p.reason)
+}
+
+// forwardStep returns the device that receives the packet forwarded by the
+// router, with the route lookup of the simulation
+func (e *environment) forwardStep(r *router, dest IP) (NetComponent, string) {
+	rtEntry, port := r.lookupRoute(dest)
+	if rtEntry == nil {
+		return nil, fmt.Sprintf("no route to %v", dest.ip)
+	}
+
+	if rtEntry.nexthop == *NewIp("0.0.0.0/0") {
+		comp := e.GetNetComponentByIp(dest)
+		if comp == nil || !e.sameSegment(port.netInterface, e.GetComponentNetInterfaceByIp(comp, dest)) {
+			return nil, fmt.Sprintf("no device answers the ARP request for %v", dest.ip)
+		}
+		return comp, ""
+	}
+
+	comp := e.GetNetComponentByIpOnly(rtEntry.nexthop)
+	if comp == nil || !e.sameSegment(port.netInterface, e.GetComponentNetInterfaceByIpOnly(comp, rtEntry.nexthop)) {
+		return nil, fmt.Sprintf("no device answers the ARP request for the next hop %v", rtEntry.nexthop.ip)
+	}
+	for _, p := range comp.(*router).ports {
+		if p.ip.ip == rtEntry.nexthop.ip && p.down {
+			return nil, fmt.Sprintf("the port of the next hop %v is down", rtEntry.nexthop.ip)
+		}
+	}
+	return comp, ""
+}
+
+// forward follows the router tables from the router to the destination
+func (e *environment) forward(r *router, dest IP) forwardPath {
+	path := forwardPath{}
+	visited := make(map[string]int)
+
+	for {
+		if _, isOwn := r.GetPortByIp(dest); isOwn {
+			path.status = FORWARD_DELIVERED
+			path.device = r.name
+			return path
+		}
+		if idx, seen := visited[r.name]; seen {
+			path.status = FORWARD_LOOP
+			path.cycle = append(append([]string{}, path.routers[idx:]...), r.name)
+			return path
+		}
+		visited[r.name] = len(path.routers)
+		path.routers = append(path.routers, r.name)
+
+		comp, reason := e.forwardStep(r, dest)
+		if comp == nil {
+			path.status = FORWARD_BLACK_HOLE
+			path.router = r.name
+			path.reason = reason
+			return path
+		}
+
+		next, isRouter := comp.(*router)
+		if !isRouter {
+			path.status = FORWARD_DELIVERED
+			path.device = comp.GetName()
+			return path
+		}
+		r = next
+	}
+}
+
+// sameCycle tells if both loops go through the same routers in the same order
+func sameCycle(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	routers := strings.Join(a[:len(a)-1], " ")
+	doubled := routers + " " + routers
+	return strings.Contains(" "+doubled+" ", " "+strings.Join(b[:len(b)-1], " ")+" ")
+}
+
+/*
+----------------------------------------------------
+Analysis of the topology
+----------------------------------------------------
+*/
+
+// ForwardingLoop is a cycle of routers that forward the packets to a network to each other
+type ForwardingLoop struct {
+	Dest    string
+	Routers []string
+}
+
+// BlackHole is a router that drops the packets to a network
+type BlackHole struct {
+	Dest   string
+	Router string
+	Reason string
+}
+
+// UnreachablePair is a node that can not ping another one
+type UnreachablePair struct {
+	Src    string
+	Dst    string
+	Reason string
+}
+
+// DestinationPaths are the paths from every router to a network
+type DestinationPaths struct {
+	Dest  string
+	Paths []string
+}
+
+// Analysis is the result of following the router tables, without sending packets
+type Analysis struct {
+	Destinations []DestinationPaths
+	Loops        []ForwardingLoop
+	BlackHoles   []BlackHole
+	Unreachable  []UnreachablePair
+}
+
+// Problems returns the number of loops, black holes and unreachable pairs
+func (a Analysis) Problems() int {
+	return len(a.Loops) + len(a.BlackHoles) + len(a.Unreachable)
+}
+
+// destinations returns an address on each network of the topology, in the
+// order they were declared. The nodes are preferred over the router ports.
+func (e *environment) destinations() []IP {
+	dests := make([]IP, 0)
+	seen := make(map[IP]bool)
+	add := func(ip IP) {
+		if !seen[ip.Network()] {
+			seen[ip.Network()] = true
+			dests = append(dests, ip)
+		}
+	}
+	for _, n := range e.nodes {
+		add(n.netPort.ip)
+	}
+	for _, r := range e.routers {
+		for _, p := range r.ports {
+			add(p.ip)
+		}
+	}
+	return dests
+}
+
+// reachNode returns why the packets of the node do not reach the destination,
+// or an empty string when they do
+func (e *environment) reachNode(n *node, dest IP, paths map[string]forwardPath) string {
+	if n.netPort.ip.IsSameNet(dest) {
+		comp := e.GetNetComponentByIp(dest)
+		if comp == nil || !e.sameSegment(n.netPort, e.GetComponentNetInterfaceByIp(comp, dest)) {
+			return fmt.Sprintf("no device answers the ARP request for %v", dest.ip)
+		}
+		return ""
+	}
+
+	gw := e.GetDefaultGateway(n)
+	if gw == nil {
+		return fmt.Sprintf("the gateway %v is not a router port", n.gateway.ip)
+	}
+	port, _ := gw.GetPortByIp(n.gateway)
+	if port.down {
+		return fmt.Sprintf("the port of the gateway %v is down", n.gateway.ip)
+	}
+	if !e.sameSegment(n.netPort, port.netInterface) {
+		return fmt.Sprintf("no device answers the ARP request for the gateway %v", n.gateway.ip)
+	}
+
+	key := gw.name + " " + dest.ToString()
+	path, done := paths[key]
+	if !done {
+		path = e.forward(gw, dest)
+		paths[key] = path
+	}
+	switch {
+	case path.status != FORWARD_DELIVERED:
+		return path.String()
+	case len(path.routers) >= int(ICMP_TTL):
+		return fmt.Sprintf("the TTL expires after %v routers", ICMP_TTL)
+	}
+	return ""
+}
+
+// AnalyzeForwarding follows the router tables from every router to every
+// network and from every node to every other, reporting the loops, the black
+// holes and the nodes that can not ping each other
+func (e *environment) AnalyzeForwarding() Analysis {
+	e.ConvergeRouting(false)
+
+	analysis := Analysis{}
+	paths := make(map[string]forwardPath)
+
+	for _, dest := range e.destinations() {
+		net := dest.Network().ToString()
+		dp := DestinationPaths{Dest: net}
+		for _, r := range e.routers {
+			path := e.forward(r, dest)
+			paths[r.name+" "+dest.ToString()] = path
+			dp.Paths = append(dp.Paths, fmt.Sprintf("%v: %v", r.name, path))
+
+			switch path.status {
+			case FORWARD_LOOP:
+				known := false
+				for _, loop := range analysis.Loops {
+					known = known || (loop.Dest == net && sameCycle(loop.Routers, path.cycle))
+				}
+				if !known {
+					analysis.Loops = append(analysis.Loops, ForwardingLoop{Dest: net, Routers: path.cycle})
+				}
+			case FORWARD_BLACK_HOLE:
+				hole := BlackHole{Dest: net, Router: path.router, Reason: path.reason}
+				known := false
+				for _, other := range analysis.BlackHoles {
+					known = known || other == hole
+				}
+				if !known {
+					analysis.BlackHoles = append(analysis.BlackHoles, hole)
+				}
+			}
+		}
+		analysis.Destinations = append(analysis.Destinations, dp)
+	}
+
+	for _, src := range e.nodes {
+		for _, dst := range e.nodes {
+			if src == dst {
+				continue
+			}
+			if reason := e.reachNode(src, dst.netPort.ip, paths); reason != "" {
+				analysis.Unreachable = append(analysis.Unreachable, UnreachablePair{
+					Src: src.name, Dst: dst.name, Reason: "request " + reason,
+				})
+				continue
+			}
+			if reason := e.reachNode(dst, src.netPort.ip, paths); reason != "" {
+				analysis.Unreachable = append(analysis.Unreachable, UnreachablePair{
+					Src: src.name, Dst: dst.name, Reason: "reply " + reason,
+				})
+			}
+		}
+	}
+	return analysis
+}
+
+// Write prints the paths to every network, followed by the problems found
+func (a Analysis) Write(w io.Writer) {
+	for _, dp := range a.Destinations {
+		fmt.Fprintf(w, "Destination %v\n", dp.Dest)
+		for _, p := range dp.Paths {
+			fmt.Fprintf(w, "  %v\n", p)
+		}
+		fmt.Fprintln(w)
+	}
+
+	fmt.Fprintln(w, "Loops")
+	for _, loop := range a.Loops {
+		fmt.Fprintf(w, "  %v: %v\n", loop.Dest, strings.Join(loop.Routers, " -> "))
+	}
+	if len(a.Loops) == 0 {
+		fmt.Fprintln(w, "  none")
+	}
+	fmt.Fprintln(w, "Black holes")
+	for _, hole := range a.BlackHoles {
+		fmt.Fprintf(w, "  %v: %v, %v\n", hole.Dest, hole.Router, hole.Reason)
+	}
+	if len(a.BlackHoles) == 0 {
+		fmt.Fprintln(w, "  none")
+	}
+	fmt.Fprintln(w, "Unreachable pairs")
+	for _, pair := range a.Unreachable {
+		fmt.Fprintf(w, "  %v -> %v: %v\n", pair.Src, pair.Dst, pair.Reason)
+	}
+	if len(a.Unreachable) == 0 {
+		fmt.Fprintln(w, "  none")
+	}
+	fmt.Fprintf(
+		w, "\nloops: %v, black holes: %v, unreachable pairs: %v\n",
+		len(a.Loops), len(a.BlackHoles), len(a.Unreachable),
+	)
+}
+
+/*
+----------------------------------------------------
+Analyze command
+----------------------------------------------------
+*/
+
+// Analyze looks for routing loops, black holes and unreachable nodes on the
+// router tables of the topology, without sending packets
+func Analyze(ctx *cli.Context) error {
+	args := ctx.Args()
+	if len(args) != 1 {
+		return &UsageError{Msg: "Invalid analyze arguments"}
+	}
+
+	env, err := LoadEnvironment(args.Get(0))
+	if err != nil {
+		return err
+	}
+	PrintWarnings(env)
+	if err := SetLinkFailures(env, ctx.StringSlice("down")); err != nil {
+		return err
+	}
+
+	analysis := env.AnalyzeForwarding()
+	analysis.Write(os.Stdout)
+	if analysis.Problems() > 0 {
+		return cli.NewExitError("", 1)
+	}
+	return nil
+}
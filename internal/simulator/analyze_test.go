@@ -0,0 +1,86 @@
+package simulator
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/arielril/network-simulator/internal/event"
+)
+
+func TestAnalyzeLoop(t *testing.T) {
+	env, err := LoadEnvironment(filepath.Join(EXAMPLES_DIR, "example3.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	analysis := env.AnalyzeForwarding()
+
+	if len(analysis.Loops) != 1 {
+		t.Fatalf("expected one loop, got %v", analysis.Loops)
+	}
+	loop := analysis.Loops[0]
+	if loop.Dest != "10.0.0.0/8" || strings.Join(loop.Routers, " ") != "R2 R3 R2" {
+		t.Errorf("expected the loop R2 -> R3 -> R2 to 10.0.0.0/8, got %v", loop)
+	}
+	if len(analysis.BlackHoles) != 0 {
+		t.Errorf("expected no black holes, got %v", analysis.BlackHoles)
+	}
+}
+
+func TestAnalyzeBlackHole(t *testing.T) {
+	env, err := loadTopology(t, behindRouter(twoNetworks))
+	if err != nil {
+		t.Fatal(err)
+	}
+	analysis := env.AnalyzeForwarding()
+
+	want := BlackHole{Dest: "30.0.0.0/24", Router: "r1", Reason: "no route to 30.0.0.2"}
+	if len(analysis.BlackHoles) != 1 || analysis.BlackHoles[0] != want {
+		t.Errorf("expected %v, got %v", want, analysis.BlackHoles)
+	}
+	if len(analysis.Unreachable) != 2 {
+		t.Errorf("expected n1 and n2 unreachable from each other, got %v", analysis.Unreachable)
+	}
+}
+
+// The analysis must agree with the pings of every pair of nodes of the examples
+func TestAnalyzeMatchesPings(t *testing.T) {
+	topologies, err := filepath.Glob(filepath.Join(EXAMPLES_DIR, "*.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range topologies {
+		env, err := LoadEnvironment(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		unreachable := make(map[string]bool)
+		for _, pair := range env.AnalyzeForwarding().Unreachable {
+			unreachable[pair.Src+" "+pair.Dst] = true
+		}
+
+		for _, src := range env.(*environment).nodes {
+			for _, dst := range env.(*environment).nodes {
+				if src == dst {
+					continue
+				}
+				pingEnv, _ := LoadEnvironment(path)
+				recorder := &event.Recorder{}
+				pingEnv.SetSink(recorder)
+				err := Ping(pingEnv, src.name, dst.name, "hello")
+
+				replied := false
+				for _, ev := range recorder.Events {
+					replied = replied || (ev.Kind == event.ECHO_REPLY && ev.Dst == src.name)
+				}
+				if (err == nil && replied) == unreachable[src.name+" "+dst.name] {
+					t.Errorf(
+						"%v: %v -> %v analyzed as unreachable=%v, but the ping replied=%v (%v)",
+						filepath.Base(path), src.name, dst.name, unreachable[src.name+" "+dst.name], replied, err,
+					)
+				}
+			}
+		}
+	}
+}
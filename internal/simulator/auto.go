@@ -0,0 +1,258 @@
+package simulator
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/urfave/cli"
+)
+
+// Placeholders of the topology, replaced by addresses chosen by the parser
+const (
+	// A MAC or a gateway chosen by the parser
+	AUTO string = "auto"
+	// An address chosen by the parser on the subnet, as auto@10.0.0.0/24
+	AUTO_SUBNET string = "auto@"
+)
+
+// Automatic MACs are locally administered, from 02:00:00:00:00:01 on
+const (
+	AUTO_MAC_FIRST uint64 = 0x020000000001
+	AUTO_MAC_LAST  uint64 = 0x02FFFFFFFFFF
+)
+
+/*
+----------------------------------------------------
+Address plan
+----------------------------------------------------
+*/
+
+// placeholder is a field of a line of the topology to be resolved
+type placeholder struct {
+	line  int
+	field int
+	// Device of the placeholder, for the errors
+	owner string
+}
+
+type autoMac struct {
+	placeholder
+	assign func(mac MAC)
+}
+
+type autoIp struct {
+	placeholder
+	subnet IP
+	assign func(ip IP)
+}
+
+type autoGateway struct {
+	placeholder
+	node *node
+}
+
+// addressPlan keeps the addresses declared on the topology and the
+// placeholders to resolve once every device is read
+type addressPlan struct {
+	usedMacs  map[MAC]bool
+	usedIps   map[uint32]bool
+	macs      []autoMac
+	routerIps []autoIp
+	nodeIps   []autoIp
+	gateways  []autoGateway
+	// Values of the placeholders, by line and field
+	resolved map[int]map[int]string
+}
+
+func newAddressPlan() *addressPlan {
+	return &addressPlan{
+		usedMacs: make(map[MAC]bool),
+		usedIps:  make(map[uint32]bool),
+		resolved: make(map[int]map[int]string),
+	}
+}
+
+func isAuto(value string) bool {
+	return strings.EqualFold(value, AUTO)
+}
+
+// autoSubnet returns the subnet of an automatic address, if the value is one
+func autoSubnet(value string) (string, bool) {
+	if len(value) > len(AUTO_SUBNET) && strings.EqualFold(value[:len(AUTO_SUBNET)], AUTO_SUBNET) {
+		return value[len(AUTO_SUBNET):], true
+	}
+	return "", false
+}
+
+// parseMacField reads a MAC, or registers the placeholder to assign it later
+func (plan *addressPlan) parseMacField(value string, at placeholder, assign func(mac MAC)) (MAC, error) {
+	if isAuto(value) {
+		plan.macs = append(plan.macs, autoMac{placeholder: at, assign: assign})
+		return "", nil
+	}
+	mac, err := parseMac(value)
+	if err != nil {
+		return "", err
+	}
+	plan.usedMacs[mac] = true
+	return mac, nil
+}
+
+// parseIpField reads an address with prefix, or registers the placeholder to
+// assign it later. Until then, the address is the one of the subnet.
+func (plan *addressPlan) parseIpField(value string, at placeholder, router bool, assign func(ip IP)) (string, error) {
+	subnet, isAutoIp := autoSubnet(value)
+	if !isAutoIp {
+		if err := parseIpPrefix(value); err != nil {
+			return "", err
+		}
+		plan.usedIps[NewIp(value).bits] = true
+		return value, nil
+	}
+
+	if err := parseIpPrefix(subnet); err != nil {
+		return "", err
+	}
+	request := autoIp{placeholder: at, subnet: NewIp(subnet).Network(), assign: assign}
+	if router {
+		plan.routerIps = append(plan.routerIps, request)
+	} else {
+		plan.nodeIps = append(plan.nodeIps, request)
+	}
+	return request.subnet.ToString(), nil
+}
+
+func (plan *addressPlan) resolve(at placeholder, value string) {
+	if plan.resolved[at.line] == nil {
+		plan.resolved[at.line] = make(map[int]string)
+	}
+	plan.resolved[at.line][at.field] = value
+}
+
+func formatMac(bits uint64) MAC {
+	return MAC(fmt.Sprintf(
+		"%02X:%02X:%02X:%02X:%02X:%02X",
+		(bits>>40)&0xFF, (bits>>32)&0xFF, (bits>>24)&0xFF, (bits>>16)&0xFF, (bits>>8)&0xFF, bits&0xFF,
+	))
+}
+
+// hostRange returns the first and last addresses of the hosts of the subnet
+func hostRange(subnet IP) (uint32, uint32) {
+	first := subnet.bits
+	last := first | (MASK >> subnet.prefix)
+	if subnet.prefix < 31 {
+		first++
+		last--
+	}
+	return first, last
+}
+
+// assignAddresses resolves the placeholders: the MACs in the order they were
+// declared, then the addresses of the router ports and of the nodes, the lowest
+// free ones of their subnets, and finally the gateways
+func (plan *addressPlan) assignAddresses(routers []*router, fail func(at placeholder, msg string) error) error {
+	nextMac := AUTO_MAC_FIRST
+	for _, request := range plan.macs {
+		for nextMac <= AUTO_MAC_LAST && plan.usedMacs[formatMac(nextMac)] {
+			nextMac++
+		}
+		if nextMac > AUTO_MAC_LAST {
+			return fail(request.placeholder, fmt.Sprintf("No automatic MAC left for %v", request.owner))
+		}
+		mac := formatMac(nextMac)
+		plan.usedMacs[mac] = true
+		request.assign(mac)
+		plan.resolve(request.placeholder, string(mac))
+	}
+
+	// lowest address of each subnet that may be free
+	next := make(map[IP]uint32)
+	for _, request := range append(append([]autoIp{}, plan.routerIps...), plan.nodeIps...) {
+		first, last := hostRange(request.subnet)
+		addr, started := next[request.subnet]
+		if !started {
+			addr = first
+		}
+		for addr <= last && addr >= first && plan.usedIps[addr] {
+			addr++
+		}
+		if addr > last || addr < first {
+			return fail(request.placeholder, fmt.Sprintf(
+				"No free address left on the subnet %v for %v", request.subnet.ToString(), request.owner,
+			))
+		}
+		next[request.subnet] = addr + 1
+		plan.usedIps[addr] = true
+
+		ip := IP{ip: bitToIp(addr), prefix: request.subnet.prefix, bits: addr}
+		request.assign(ip)
+		plan.resolve(request.placeholder, ip.ToString())
+	}
+
+	for _, request := range plan.gateways {
+		var gateway *IP
+		for _, rt := range routers {
+			for _, p := range rt.ports {
+				if gateway == nil && p.ip.Network() == request.node.netPort.ip.Network() {
+					gateway = &IP{ip: p.ip.ip, prefix: request.node.netPort.ip.prefix, bits: p.ip.bits}
+				}
+			}
+		}
+		if gateway == nil {
+			return fail(request.placeholder, fmt.Sprintf(
+				"No router port on the network %v to be the gateway of %v",
+				request.node.netPort.ip.Network().ToString(), request.owner,
+			))
+		}
+		request.node.gateway = *gateway
+		plan.resolve(request.placeholder, gateway.ip)
+	}
+	return nil
+}
+
+/*
+----------------------------------------------------
+Resolved topology
+----------------------------------------------------
+*/
+
+// ResolvedLines returns the lines of the topology with the addresses chosen
+// for the placeholders, and the lines of the files it includes in their place
+func (e *environment) ResolvedLines() []string {
+	lines := make([]string, len(e.lines))
+	for i, l := range e.lines {
+		values, hasPlaceholders := e.resolved[i]
+		if !hasPlaceholders {
+			lines[i] = l.text
+			continue
+		}
+		fields := splitFields(l.content)
+		for field, value := range values {
+			fields[field] = value
+		}
+		lines[i] = strings.Join(fields, ",")
+		if l.comment != "" {
+			lines[i] += " " + l.comment
+		}
+	}
+	return lines
+}
+
+// Export prints the topology with the addresses chosen for the placeholders
+func Export(ctx *cli.Context) error {
+	args := ctx.Args()
+	if len(args) != 1 {
+		return &UsageError{Msg: "Invalid export arguments"}
+	}
+
+	env, err := LoadEnvironment(args.Get(0))
+	if err != nil {
+		return err
+	}
+	PrintWarnings(env)
+	for _, line := range env.ResolvedLines() {
+		fmt.Fprintln(os.Stdout, line)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package simulator
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+const autoNetworks = `
+#NODE
+n1,auto,auto@192.168.0.0/24,5,auto
+n2,00:00:00:00:00:01,192.168.0.2/24,5,192.168.0.1
+n3,auto,auto@192.168.0.0/24,5,auto
+n4,auto,auto@192.168.1.0/24,5,auto
+#ROUTER
+r1,2,auto,auto@192.168.0.0/24,5,02:00:00:00:00:01,192.168.1.1/24,5
+#ROUTERTABLE
+`
+
+func TestAutoPlaceholders(t *testing.T) {
+	env, err := loadTopology(t, autoNetworks)
+	if err != nil {
+		t.Fatalf("Failed to load the topology: %v", err)
+	}
+
+	expected := []string{
+		"#NODE",
+		"n1,02:00:00:00:00:02,192.168.0.3/24,5,192.168.0.1",
+		"n2,00:00:00:00:00:01,192.168.0.2/24,5,192.168.0.1",
+		"n3,02:00:00:00:00:03,192.168.0.4/24,5,192.168.0.1",
+		"n4,02:00:00:00:00:04,192.168.1.2/24,5,192.168.1.1",
+		"#ROUTER",
+		"r1,2,02:00:00:00:00:05,192.168.0.1/24,5,02:00:00:00:00:01,192.168.1.1/24,5",
+		"#ROUTERTABLE",
+	}
+	if diff := diffLines(expected, env.ResolvedLines()); diff != "" {
+		t.Errorf("Unexpected resolved topology:\n%v", diff)
+	}
+
+	for _, pair := range [][2]string{{"n1", "n4"}, {"n3", "n2"}} {
+		if err := pingTopology(t, autoNetworks, pair[0], pair[1]); err != nil {
+			t.Errorf("Ping from %v to %v failed: %v", pair[0], pair[1], err)
+		}
+	}
+}
+
+func TestAutoResolvedRoundTrip(t *testing.T) {
+	env, err := loadTopology(t, autoNetworks)
+	if err != nil {
+		t.Fatalf("Failed to load the topology: %v", err)
+	}
+	resolved := env.ResolvedLines()
+
+	again, err := loadTopology(t, strings.Join(resolved, "\n"))
+	if err != nil {
+		t.Fatalf("Failed to load the resolved topology: %v", err)
+	}
+	if diff := diffLines(resolved, again.ResolvedLines()); diff != "" {
+		t.Errorf("The resolved topology changed when loaded again:\n%v", diff)
+	}
+}
+
+func TestAutoPoolExhausted(t *testing.T) {
+	cases := []struct {
+		name     string
+		topology string
+		line     int
+		msg      string
+	}{
+		{
+			name: "no free address",
+			topology: `
+#NODE
+n1,auto,auto@10.0.0.0/30,5,auto
+n2,auto,auto@10.0.0.0/30,5,auto
+#ROUTER
+r1,1,auto,auto@10.0.0.0/30,5
+#ROUTERTABLE`,
+			line: 3,
+			msg:  "No free address left on the subnet 10.0.0.0/30 for node n2",
+		},
+		{
+			name: "no gateway",
+			topology: `
+#NODE
+n1,auto,auto@10.0.0.0/24,5,auto
+#ROUTER
+r1,1,auto,auto@10.0.1.0/24,5
+#ROUTERTABLE`,
+			line: 2,
+			msg:  "No router port on the network 10.0.0.0/24 to be the gateway of node n1",
+		},
+		{
+			name: "invalid subnet",
+			topology: `
+#NODE
+n1,auto,auto@10.0.0.0,5,auto
+#ROUTER
+#ROUTERTABLE`,
+			line: 2,
+			msg:  "Invalid IP address 10.0.0.0, expected an IPv4 address with prefix",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := loadTopology(t, c.topology)
+			var topoErr *TopologyError
+			if !errors.As(err, &topoErr) {
+				t.Fatalf("Expected a topology error, got %v", err)
+			}
+			if topoErr.Line != c.line || topoErr.Msg != c.msg {
+				t.Errorf("Expected line %v: %v, got line %v: %v", c.line, c.msg, topoErr.Line, topoErr.Msg)
+			}
+		})
+	}
+}
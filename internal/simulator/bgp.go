@@ -0,0 +1,682 @@
+package simulator
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/arielril/network-simulator/internal/event"
+	"github.com/urfave/cli"
+)
+
+// BGP timers (in seconds) and constants
+const (
+	// Delay of the updates sent after the best paths change
+	BGP_UPDATE_DELAY int = 1
+	// Interval of the scan that resolves the next hops again, following the
+	// changes of the other protocols
+	BGP_SCAN_INTERVAL int = 5
+	// A peer notices a session is down when no keepalive is received for this long
+	BGP_HOLD_TIME int = 180
+
+	BGP_DEFAULT_LOCAL_PREF uint32 = 100
+	BGP_IBGP_TTL           uint8  = 64
+)
+
+// BgpPolicy is the import and export policy of a BGP session
+type BgpPolicy struct {
+	// Local preference of the routes received, 0 keeps the one received (100 on eBGP)
+	LocalPref uint32
+	// MED of the routes advertised, 0 sends none
+	Med uint32
+	// Extra copies of the AS number prepended to the routes advertised on eBGP
+	Prepend int
+	// Routes to networks inside these prefixes are not accepted, or not advertised
+	DenyIn  []string
+	DenyOut []string
+	// Advertise the router as the next hop of the routes sent on iBGP
+	NextHopSelf bool
+}
+
+// bgpPath is a path to a prefix, as received from a peer or originated by the router
+type bgpPath struct {
+	prefix    IP
+	asPath    []uint32
+	nexthop   IP
+	localPref uint32
+	med       uint32
+	// Session the path was learned from, nil when originated by the router
+	from *bgpSession
+}
+
+func (p *bgpPath) String() string {
+	hops := make([]string, len(p.asPath))
+	for i, asn := range p.asPath {
+		hops[i] = fmt.Sprintf("%v", asn)
+	}
+	text := fmt.Sprintf(
+		"%v path=[%v] next_hop=%v med=%v",
+		p.prefix.ToString(), strings.Join(hops, " "), p.nexthop.ip, p.med,
+	)
+	if p.localPref != 0 {
+		text += fmt.Sprintf(" local_pref=%v", p.localPref)
+	}
+	return text
+}
+
+// bgpSession is the side of a router of a session with a peer
+type bgpSession struct {
+	proc   *bgpProcess
+	peerIp IP
+	policy BgpPolicy
+	// Filled when the session is established
+	peer    *bgpProcess
+	remote  *bgpSession
+	localIp IP
+	port    routerPort
+	ebgp    bool
+	up      bool
+	// The peer is waiting for the hold time to notice the session is down
+	closing bool
+	ribIn   map[IP]*bgpPath
+	ribOut  map[IP]string
+}
+
+// bgpProcess is BGP running on a router of an autonomous system
+type bgpProcess struct {
+	router *router
+	asn    uint32
+	// Paths to the networks announced by the router
+	originated []*bgpPath
+	sessions   []*bgpSession
+	best       map[IP]*bgpPath
+	// Description of the best paths and their forwarding, to notice changes
+	installed string
+	// An update is scheduled
+	pending bool
+}
+
+// bgp is the set of routers running BGP, on any autonomous system
+type bgp struct {
+	env        *environment
+	procs      []*bgpProcess
+	scheduler  *scheduler
+	emit       func(ev event.Event)
+	lastChange int
+}
+
+func (e *environment) bgpDomain() *bgp {
+	for _, p := range e.routing.protocols {
+		if d, isBgp := p.(*bgp); isBgp {
+			return d
+		}
+	}
+	return nil
+}
+
+func (d *bgp) process(routerName string) *bgpProcess {
+	for _, proc := range d.procs {
+		if proc.router.name == routerName {
+			return proc
+		}
+	}
+	return nil
+}
+
+// EnableBgp runs BGP on the router, as part of the autonomous system, announcing the networks
+func (e *environment) EnableBgp(routerName string, asn uint32, networks []string) error {
+	rt := e.GetRouterByName(routerName)
+	if rt == nil {
+		return fmt.Errorf("Unknown router %v", routerName)
+	}
+	if asn == 0 {
+		return fmt.Errorf("Invalid AS number %v", asn)
+	}
+	proc := &bgpProcess{router: rt, asn: asn, best: make(map[IP]*bgpPath)}
+	for _, network := range networks {
+		if err := parseIpPrefix(network); err != nil {
+			return err
+		}
+		prefix := NewIp(network).Network()
+		proc.originated = append(proc.originated, &bgpPath{
+			prefix:    prefix,
+			nexthop:   *NewIp("0.0.0.0"),
+			localPref: BGP_DEFAULT_LOCAL_PREF,
+		})
+	}
+
+	d := e.bgpDomain()
+	if d == nil {
+		d = &bgp{env: e}
+		e.routing.protocols = append(e.routing.protocols, d)
+	}
+	if d.process(routerName) != nil {
+		return fmt.Errorf("BGP is enabled more than once on router %v", routerName)
+	}
+	d.procs = append(d.procs, proc)
+	e.routing.converged = false
+	return nil
+}
+
+// AddBgpNeighbor adds a session of the router with the peer address, which must
+// also be declared on the peer. Peers on other autonomous systems must be on a
+// network of the router.
+func (e *environment) AddBgpNeighbor(routerName, peerIp string, policy BgpPolicy) error {
+	d := e.bgpDomain()
+	var proc *bgpProcess
+	if d != nil {
+		proc = d.process(routerName)
+	}
+	if proc == nil {
+		return fmt.Errorf("Router %v does not run BGP", routerName)
+	}
+	if err := parseIpAddress(peerIp); err != nil {
+		return err
+	}
+	for _, prefix := range append(append([]string{}, policy.DenyIn...), policy.DenyOut...) {
+		if err := parseIpPrefix(prefix); err != nil {
+			return err
+		}
+	}
+	if policy.Prepend < 0 {
+		return fmt.Errorf("Invalid AS path prepend %v", policy.Prepend)
+	}
+
+	ip := *NewIp(peerIp)
+	for _, s := range proc.sessions {
+		if s.peerIp.ip == ip.ip {
+			return fmt.Errorf("Router %v has more than one session with %v", routerName, peerIp)
+		}
+	}
+	proc.sessions = append(proc.sessions, &bgpSession{proc: proc, peerIp: ip, policy: policy})
+	e.routing.converged = false
+	return nil
+}
+
+/*
+----------------------------------------------------
+Sessions
+----------------------------------------------------
+*/
+
+// owner returns the process of the router with the address
+func (d *bgp) owner(ip IP) (*bgpProcess, routerPort) {
+	for _, proc := range d.procs {
+		for _, p := range proc.router.ports {
+			if p.ip.ip == ip.ip {
+				return proc, p
+			}
+		}
+	}
+	return nil, routerPort{}
+}
+
+// establish pairs the session with the one declared on the peer. When the
+// routers have more than one session, the one on the same network is used.
+func (d *bgp) establish(s *bgpSession) {
+	peer, _ := d.owner(s.peerIp)
+	if peer == nil || peer == s.proc {
+		return
+	}
+	var local routerPort
+	for _, remote := range peer.sessions {
+		owner, port := d.owner(remote.peerIp)
+		if owner != s.proc || (s.remote != nil && !port.ip.IsSameNet(s.peerIp)) {
+			continue
+		}
+		s.remote, local = remote, port
+		if port.ip.IsSameNet(s.peerIp) {
+			break
+		}
+	}
+	if s.remote == nil {
+		return
+	}
+
+	s.peer = peer
+	s.localIp, s.port = *NewIp(local.ip.ip), local
+	s.ebgp = peer.asn != s.proc.asn
+	if s.ebgp && !local.ip.IsSameNet(s.peerIp) {
+		// eBGP peers must be directly connected
+		return
+	}
+	s.up = !local.down
+	s.ribIn = make(map[IP]*bgpPath)
+	s.ribOut = make(map[IP]string)
+}
+
+func (d *bgp) start(s *scheduler, emit func(ev event.Event)) {
+	d.scheduler = s
+	d.emit = emit
+	for _, proc := range d.procs {
+		for _, session := range proc.sessions {
+			d.establish(session)
+		}
+	}
+	for _, proc := range d.procs {
+		d.decide(proc, 0)
+	}
+
+	var scan func(now int)
+	scan = func(now int) {
+		for _, proc := range d.procs {
+			d.decide(proc, now)
+		}
+		s.after(BGP_SCAN_INTERVAL, scan)
+	}
+	s.at(BGP_SCAN_INTERVAL, scan)
+}
+
+// sessionDown drops the session and the paths learned on it
+func (d *bgp) sessionDown(s *bgpSession, now int) {
+	if !s.up {
+		return
+	}
+	s.up = false
+	s.ribIn = make(map[IP]*bgpPath)
+	s.ribOut = make(map[IP]string)
+	d.decide(s.proc, now)
+}
+
+func (d *bgp) linkDown(s *scheduler, r *router, port uint8) {
+	for _, proc := range d.procs {
+		if proc.router != r {
+			continue
+		}
+		for _, session := range proc.sessions {
+			if !session.up || !session.ebgp || session.port.number != port {
+				continue
+			}
+			d.sessionDown(session, s.now)
+
+			// the peer only notices when the hold time expires
+			remote := session.remote
+			remote.closing = true
+			s.after(BGP_HOLD_TIME, func(now int) {
+				remote.closing = false
+				d.sessionDown(remote, now)
+			})
+		}
+	}
+}
+
+/*
+----------------------------------------------------
+Decision process
+----------------------------------------------------
+*/
+
+func denied(prefixes []string, netdest IP) bool {
+	for _, prefix := range prefixes {
+		filter := NewIp(prefix).Network()
+		if filter.prefix <= netdest.prefix && filter.IsSameNet(netdest) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve returns the port and address to forward to the next hop, through the
+// connected networks or the routes of the other protocols
+func (proc *bgpProcess) resolve(nexthop IP) (routerPort, IP, bool) {
+	for _, p := range proc.router.ports {
+		if !p.down && p.ip.IsSameNet(nexthop) {
+			return p, nexthop, true
+		}
+	}
+	for _, ent := range proc.router.routerTable {
+		if ent.source == EBGP_ROUTE || ent.source == IBGP_ROUTE || !ent.netdest.IsSameNet(nexthop) {
+			continue
+		}
+		p, hasPort := proc.router.GetPortByNumber(ent.port)
+		if !hasPort || p.down {
+			continue
+		}
+		if ent.nexthop.ip == "0.0.0.0" {
+			return p, nexthop, true
+		}
+		return p, ent.nexthop, true
+	}
+	return routerPort{}, IP{}, false
+}
+
+// better tells if the path is preferred over the other
+func better(a, b *bgpPath) bool {
+	if a.localPref != b.localPref {
+		return a.localPref > b.localPref
+	}
+	if (a.from == nil) != (b.from == nil) {
+		return a.from == nil
+	}
+	if len(a.asPath) != len(b.asPath) {
+		return len(a.asPath) < len(b.asPath)
+	}
+	// MEDs are only compared between paths from the same neighbor AS
+	if len(a.asPath) > 0 && a.asPath[0] == b.asPath[0] && a.med != b.med {
+		return a.med < b.med
+	}
+	if a.from == nil || b.from == nil {
+		return false
+	}
+	if a.from.ebgp != b.from.ebgp {
+		return a.from.ebgp
+	}
+	return a.from.peerIp.ToBit() < b.from.peerIp.ToBit()
+}
+
+// candidates returns the paths to every prefix with a reachable next hop
+func (proc *bgpProcess) candidates() map[IP][]*bgpPath {
+	paths := make(map[IP][]*bgpPath)
+	for _, path := range proc.originated {
+		paths[path.prefix] = append(paths[path.prefix], path)
+	}
+	for _, s := range proc.sessions {
+		if !s.up {
+			continue
+		}
+		for prefix, path := range s.ribIn {
+			if _, _, reachable := proc.resolve(path.nexthop); reachable {
+				paths[prefix] = append(paths[prefix], path)
+			}
+		}
+	}
+	return paths
+}
+
+// decide selects the best path to every prefix and, when they change, installs
+// them and schedules the updates to the peers
+func (d *bgp) decide(proc *bgpProcess, now int) {
+	best := make(map[IP]*bgpPath)
+	for prefix, paths := range proc.candidates() {
+		for _, path := range paths {
+			if best[prefix] == nil || better(path, best[prefix]) {
+				best[prefix] = path
+			}
+		}
+	}
+	proc.best = best
+
+	entries := make([]*routerTableEntry, 0)
+	ibgp := make([]*routerTableEntry, 0)
+	description := make([]string, 0)
+	for _, prefix := range sortedPrefixes(best) {
+		path := best[prefix]
+		description = append(description, path.String())
+		if path.from == nil {
+			continue
+		}
+		port, nexthop, _ := proc.resolve(path.nexthop)
+		ent := &routerTableEntry{netdest: prefix, nexthop: nexthop, port: port.number, metric: uint32(len(path.asPath))}
+		description = append(description, fmt.Sprintf("via %v port %v", nexthop.ip, port.number))
+		if path.from.ebgp {
+			entries = append(entries, ent)
+		} else {
+			ibgp = append(ibgp, ent)
+		}
+	}
+
+	installed := strings.Join(description, "\n")
+	if installed == proc.installed {
+		return
+	}
+	proc.installed = installed
+	proc.router.installRoutes(EBGP_ROUTE, entries)
+	proc.router.installRoutes(IBGP_ROUTE, ibgp)
+	d.lastChange = now
+
+	if proc.pending {
+		return
+	}
+	proc.pending = true
+	d.scheduler.at(now+BGP_UPDATE_DELAY, func(now int) {
+		proc.pending = false
+		for _, s := range proc.sessions {
+			if s.up {
+				d.sendUpdate(s, now)
+			}
+		}
+	})
+}
+
+func sortedPrefixes(paths map[IP]*bgpPath) []IP {
+	prefixes := make([]IP, 0, len(paths))
+	for prefix := range paths {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Slice(prefixes, func(i, j int) bool {
+		if prefixes[i].ToBit() != prefixes[j].ToBit() {
+			return prefixes[i].ToBit() < prefixes[j].ToBit()
+		}
+		return prefixes[i].prefix < prefixes[j].prefix
+	})
+	return prefixes
+}
+
+/*
+----------------------------------------------------
+Updates
+----------------------------------------------------
+*/
+
+// advertise returns the path sent to the peer for the best path, or nil when it
+// is not advertised on the session
+func (s *bgpSession) advertise(path *bgpPath) *bgpPath {
+	// paths learned on iBGP are not sent to other iBGP peers
+	if path.from == s || (!s.ebgp && path.from != nil && !path.from.ebgp) || denied(s.policy.DenyOut, path.prefix) {
+		return nil
+	}
+
+	out := &bgpPath{prefix: path.prefix, nexthop: path.nexthop, asPath: path.asPath, med: path.med}
+	if s.ebgp {
+		out.asPath = make([]uint32, 0, len(path.asPath)+1+s.policy.Prepend)
+		for i := 0; i <= s.policy.Prepend; i++ {
+			out.asPath = append(out.asPath, s.proc.asn)
+		}
+		out.asPath = append(out.asPath, path.asPath...)
+		out.med = 0
+	} else {
+		out.localPref = path.localPref
+	}
+	if s.ebgp || s.policy.NextHopSelf || path.from == nil {
+		out.nexthop = s.localIp
+	}
+	if s.policy.Med != 0 {
+		out.med = s.policy.Med
+	}
+	return out
+}
+
+// sendUpdate advertises to the peer the paths that changed since the last
+// update, and withdraws the ones that are gone
+func (d *bgp) sendUpdate(s *bgpSession, now int) {
+	announced := make([]*bgpPath, 0)
+	withdrawn := make([]IP, 0)
+	sent := make(map[IP]string)
+	for _, prefix := range sortedPrefixes(s.proc.best) {
+		out := s.advertise(s.proc.best[prefix])
+		if out == nil {
+			continue
+		}
+		sent[prefix] = out.String()
+		if s.ribOut[prefix] != sent[prefix] {
+			announced = append(announced, out)
+		}
+	}
+	for prefix := range s.ribOut {
+		if _, kept := sent[prefix]; !kept {
+			withdrawn = append(withdrawn, prefix)
+		}
+	}
+	sort.Slice(withdrawn, func(i, j int) bool { return withdrawn[i].ToBit() < withdrawn[j].ToBit() })
+	s.ribOut = sent
+	if len(announced) == 0 && len(withdrawn) == 0 {
+		return
+	}
+
+	text := make([]string, 0, len(announced)+len(withdrawn))
+	for _, path := range announced {
+		text = append(text, path.String())
+	}
+	for _, prefix := range withdrawn {
+		text = append(text, "withdraw "+prefix.ToString())
+	}
+	ttl := uint8(1)
+	if !s.ebgp {
+		ttl = BGP_IBGP_TTL
+	}
+	_, peerPort := d.owner(s.peerIp)
+	d.emit(event.Event{
+		Kind:   event.BGP_UPDATE,
+		Src:    s.proc.router.name,
+		Dst:    s.peer.router.name,
+		SrcMac: string(s.port.mac),
+		DstMac: string(peerPort.mac),
+		SrcIp:  s.localIp.ip,
+		DstIp:  s.peerIp.ip,
+		Ttl:    ttl,
+		Data:   strings.Join(text, ", "),
+		Time:   now,
+	})
+	d.receive(s.remote, announced, withdrawn, now)
+}
+
+// receive applies the import policy to the paths sent by the peer
+func (d *bgp) receive(s *bgpSession, announced []*bgpPath, withdrawn []IP, now int) {
+	if !s.up {
+		return
+	}
+	for _, prefix := range withdrawn {
+		delete(s.ribIn, prefix)
+	}
+	for _, out := range announced {
+		delete(s.ribIn, out.prefix)
+		if denied(s.policy.DenyIn, out.prefix) {
+			continue
+		}
+		loop := false
+		for _, asn := range out.asPath {
+			loop = loop || asn == s.proc.asn
+		}
+		if loop {
+			continue
+		}
+
+		path := *out
+		path.from = s
+		if s.ebgp {
+			path.localPref = BGP_DEFAULT_LOCAL_PREF
+		}
+		if s.policy.LocalPref != 0 {
+			path.localPref = s.policy.LocalPref
+		}
+		s.ribIn[out.prefix] = &path
+	}
+	d.decide(s.proc, now)
+}
+
+// stable tells if the best paths did not change on the last scan interval, and
+// no update nor session failure is pending
+func (d *bgp) stable(now int) bool {
+	if now-d.lastChange < BGP_SCAN_INTERVAL {
+		return false
+	}
+	for _, proc := range d.procs {
+		if proc.pending {
+			return false
+		}
+		for _, s := range proc.sessions {
+			if s.closing {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+/*
+----------------------------------------------------
+BGP table dump
+----------------------------------------------------
+*/
+
+// WriteBgp prints the paths received by the router, marking the best ones with
+// ">", or of every router running BGP when no name is given
+func (e *environment) WriteBgp(w io.Writer, routerName string) error {
+	d := e.bgpDomain()
+	if d == nil {
+		return &UsageError{Msg: "No router runs BGP"}
+	}
+	procs := d.procs
+	if routerName != "" {
+		proc := d.process(routerName)
+		if proc == nil {
+			return &UsageError{Msg: fmt.Sprintf("Router %v does not run BGP", routerName)}
+		}
+		procs = []*bgpProcess{proc}
+	}
+
+	for i, proc := range procs {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "Router %v AS %v\n", proc.router.name, proc.asn)
+
+		fmt.Fprintln(w, "  Sessions")
+		for _, s := range proc.sessions {
+			kind, state := "iBGP", "down"
+			if s.ebgp {
+				kind = "eBGP"
+			}
+			if s.up {
+				state = "up"
+			}
+			peer := "unknown"
+			if s.peer != nil {
+				peer = fmt.Sprintf("%v AS %v", s.peer.router.name, s.peer.asn)
+			}
+			fmt.Fprintf(w, "    %v %v (%v) %v\n", kind, s.peerIp.ip, peer, state)
+		}
+
+		fmt.Fprintln(w, "  Paths")
+		candidates := proc.candidates()
+		for _, prefix := range sortedPrefixes(proc.best) {
+			for _, path := range candidates[prefix] {
+				mark, from := " ", "local"
+				if path == proc.best[prefix] {
+					mark = ">"
+				}
+				if path.from != nil {
+					from = path.from.peerIp.ip
+				}
+				fmt.Fprintf(w, "   %v %v from %v\n", mark, path, from)
+			}
+		}
+	}
+	return nil
+}
+
+// Bgp runs the routing protocols of the topology and prints the BGP paths of
+// the routers running BGP
+func Bgp(ctx *cli.Context) error {
+	args := ctx.Args()
+	if len(args) < 1 || len(args) > 2 {
+		return &UsageError{Msg: "Invalid bgp arguments"}
+	}
+
+	env, err := LoadEnvironment(args.Get(0))
+	if err != nil {
+		return err
+	}
+	if err := SetLinkFailures(env, ctx.StringSlice("down")); err != nil {
+		return err
+	}
+
+	env.SetSink(event.Writer{W: os.Stdout})
+	env.ConvergeRouting(ctx.Bool("show-routing"))
+	if ctx.Bool("show-routing") {
+		fmt.Println()
+	}
+	return env.WriteBgp(os.Stdout, args.Get(1))
+}
@@ -0,0 +1,158 @@
+package simulator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/arielril/network-simulator/internal/event"
+)
+
+// bgpSquare has three autonomous systems: r1 (AS 65001) reaches 20.0.0.0/24 on
+// AS 65002 (r2 and r3, on iBGP) directly or through r4 (AS 65003)
+const bgpSquare = `
+#NODE
+n1,00:00:00:00:00:01,10.0.0.2/24,5,10.0.0.1
+n2,00:00:00:00:00:02,20.0.0.2/24,5,20.0.0.1
+#ROUTER
+r1,3,00:00:00:00:00:10,10.0.0.1/24,5,00:00:00:00:00:11,100.0.1.1/24,5,00:00:00:00:00:12,100.0.3.1/24,5
+r2,2,00:00:00:00:00:20,100.0.1.2/24,5,00:00:00:00:00:21,100.0.2.1/24,5
+r3,3,00:00:00:00:00:30,100.0.2.2/24,5,00:00:00:00:00:31,20.0.0.1/24,5,00:00:00:00:00:32,100.0.4.2/24,5
+r4,2,00:00:00:00:00:40,100.0.3.2/24,5,00:00:00:00:00:41,100.0.4.1/24,5
+#ROUTERTABLE
+r1,10.0.0.0/24,0.0.0.0,0
+r3,20.0.0.0/24,0.0.0.0,1
+#BGP
+r1,65001,10.0.0.0/24
+r2,65002
+r3,65002,20.0.0.0/24
+r4,65003
+#BGPNEIGHBOR
+r1,100.0.1.2
+r1,100.0.3.2
+r2,100.0.1.1
+r2,100.0.2.2,next-hop-self
+r3,100.0.2.1,next-hop-self
+r3,100.0.4.1
+r4,100.0.3.1
+r4,100.0.4.2
+`
+
+func TestBgpBestPath(t *testing.T) {
+	env, events := convergeRouting(t, bgpSquare)
+
+	for _, tc := range []struct {
+		router, netdest, nexthop string
+		source                   routeSource
+	}{
+		// the shortest AS path wins
+		{"r1", "20.0.0.0/24", "100.0.1.2", EBGP_ROUTE},
+		{"r4", "10.0.0.0/24", "100.0.3.1", EBGP_ROUTE},
+		// iBGP with next-hop-self
+		{"r3", "10.0.0.0/24", "100.0.2.1", IBGP_ROUTE},
+	} {
+		ent := routeTo(t, env, tc.router, tc.netdest)
+		if ent == nil || ent.nexthop.ip != tc.nexthop || ent.source != tc.source {
+			t.Errorf("%v route to %v: want %v via %v, got %+v", tc.router, tc.netdest, tc.source, tc.nexthop, ent)
+		}
+	}
+
+	// r2 must not send r3 the paths learned from r3 nor accept paths with its AS
+	for _, ev := range events {
+		if ev.Kind == event.BGP_UPDATE && ev.Src == "r2" && ev.Dst == "r3" && strings.Contains(ev.Data, "20.0.0.0/24") {
+			t.Errorf("r2 sent an iBGP path back to iBGP: %v", ev.Data)
+		}
+	}
+	proc := env.(*environment).bgpDomain().process("r2")
+	for _, s := range proc.sessions {
+		for _, path := range s.ribIn {
+			for _, asn := range path.asPath {
+				if asn == proc.asn {
+					t.Errorf("r2 accepted a path with its own AS: %v", path)
+				}
+			}
+		}
+	}
+
+	recorder := &event.Recorder{}
+	env.SetSink(recorder)
+	if err := Ping(env, "n1", "n2", "hello"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBgpPolicies(t *testing.T) {
+	// r1 prefers the paths from r4, and r3 does not advertise 20.0.0.0/24 to r4
+	topology := strings.Replace(bgpSquare, "r1,100.0.3.2\n", "r1,100.0.3.2,localpref=200\n", 1)
+	env, _ := convergeRouting(t, topology)
+	if ent := routeTo(t, env, "r1", "20.0.0.0/24"); ent == nil || ent.nexthop.ip != "100.0.3.2" {
+		t.Errorf("Expected r1 to prefer r4 by local preference, got %+v", ent)
+	}
+
+	topology = strings.Replace(bgpSquare, "r3,100.0.4.1\n", "r3,100.0.4.1,deny-out=20.0.0.0/16\n", 1)
+	env, _ = convergeRouting(t, topology)
+	if ent := routeTo(t, env, "r4", "20.0.0.0/24"); ent == nil || ent.nexthop.ip != "100.0.3.1" {
+		t.Errorf("Expected r4 to reach 20.0.0.0/24 only through r1, got %+v", ent)
+	}
+
+	topology = strings.Replace(bgpSquare, "r2,100.0.1.1\n", "r2,100.0.1.1,prepend=2\n", 1)
+	env, _ = convergeRouting(t, topology)
+	if ent := routeTo(t, env, "r1", "20.0.0.0/24"); ent == nil || ent.nexthop.ip != "100.0.3.2" {
+		t.Errorf("Expected r1 to avoid the prepended path of r2, got %+v", ent)
+	}
+}
+
+func TestBgpMed(t *testing.T) {
+	// r1 has two sessions with AS 65002, the lowest MED wins
+	topology := `
+#NODE
+n1,00:00:00:00:00:01,10.0.0.2/24,5,10.0.0.1
+n2,00:00:00:00:00:02,20.0.0.2/24,5,20.0.0.1
+#ROUTER
+r1,3,00:00:00:00:00:10,10.0.0.1/24,5,00:00:00:00:00:11,100.0.1.1/24,5,00:00:00:00:00:12,100.0.3.1/24,5
+r2,3,00:00:00:00:00:20,100.0.1.2/24,5,00:00:00:00:00:21,100.0.3.2/24,5,00:00:00:00:00:22,20.0.0.1/24,5
+#ROUTERTABLE
+#BGP
+r1,65001,10.0.0.0/24
+r2,65002,20.0.0.0/24
+#BGPNEIGHBOR
+r1,100.0.1.2
+r1,100.0.3.2
+r2,100.0.1.1,med=50
+r2,100.0.3.1,med=10
+`
+	env, _ := convergeRouting(t, topology)
+	if ent := routeTo(t, env, "r1", "20.0.0.0/24"); ent == nil || ent.nexthop.ip != "100.0.3.2" {
+		t.Errorf("Expected r1 to prefer the lowest MED, got %+v", ent)
+	}
+}
+
+func TestBgpLinkDown(t *testing.T) {
+	env, events := convergeRouting(t, bgpSquare, "r1:1")
+
+	if ent := routeTo(t, env, "r1", "20.0.0.0/24"); ent == nil || ent.nexthop.ip != "100.0.3.2" {
+		t.Errorf("Expected r1 to reach 20.0.0.0/24 through r4, got %+v", ent)
+	}
+	// r2 keeps the session until its hold time expires
+	withdrawn := false
+	for _, ev := range events {
+		if ev.Kind == event.BGP_UPDATE && ev.Src == "r2" && strings.Contains(ev.Data, "withdraw 10.0.0.0/24") {
+			withdrawn = ev.Time > BGP_HOLD_TIME
+		}
+	}
+	if !withdrawn {
+		t.Error("Expected r2 to withdraw 10.0.0.0/24 after the hold time")
+	}
+}
+
+func TestBgpParseErrors(t *testing.T) {
+	for _, tc := range []struct{ from, to, msg string }{
+		{"r4,65003\n", "r4,AS3\n", "Invalid AS number"},
+		{"r4,100.0.4.2\n", "r4,100.0.4.2,weight=1\n", "Unknown BGP option"},
+		{"r4,100.0.4.2\n", "r5,100.0.4.2\n", "does not run BGP"},
+	} {
+		_, err := loadTopology(t, strings.Replace(bgpSquare, tc.from, tc.to, 1))
+		if err == nil || !strings.Contains(err.Error(), tc.msg) {
+			t.Errorf("Expected %q, got %v", tc.msg, err)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package simulator
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/urfave/cli"
+)
+
+// Kinds of the changes between two topologies
+const (
+	CHANGE_ADDED   string = "added"
+	CHANGE_REMOVED string = "removed"
+	CHANGE_CHANGED string = "changed"
+)
+
+// TopologyChange is a node, router port, switch port or static route that is
+// only on one of the topologies, or that is different on them
+type TopologyChange struct {
+	Kind string
+	// What changed, as "node n1", "router r1 port 0", "switch sw1 port 0" or
+	// "route r1 10.0.0.0/24"
+	Item string
+	// Description of the item on each topology, empty where it is missing
+	Old string
+	New string
+}
+
+// PairChange is a ping between two nodes of both topologies whose outcome,
+// path or fragments changed
+type PairChange struct {
+	Src string
+	Dst string
+	Old PingOutcome
+	New PingOutcome
+}
+
+// TopologyDiff is what changed from a topology to another and how it changed
+// the pings between their nodes
+type TopologyDiff struct {
+	Changes []TopologyChange
+	Pairs   []PairChange
+}
+
+// Len returns the number of changes and changed pairs
+func (d TopologyDiff) Len() int {
+	return len(d.Changes) + len(d.Pairs)
+}
+
+/*
+----------------------------------------------------
+Topology changes
+----------------------------------------------------
+*/
+
+// topologyItems describes the nodes, router ports, switch ports and static
+// routes of the environment, by item, keeping the order they were declared
+func (e *environment) topologyItems() ([]string, map[string]string) {
+	items := make([]string, 0)
+	values := make(map[string]string)
+	add := func(item, value string) {
+		if _, seen := values[item]; !seen {
+			items = append(items, item)
+			values[item] = value
+			return
+		}
+		values[item] += "; " + value
+	}
+
+	for _, n := range e.nodes {
+		add("node "+n.name, fmt.Sprintf(
+			"%v %v mtu %v gateway %v", n.netPort.mac, n.netPort.ip.ToString(), n.netPort.mtu, n.gateway.ip,
+		))
+	}
+	for _, r := range e.routers {
+		for _, p := range r.ports {
+			add(fmt.Sprintf("router %v port %v", r.name, p.number), fmt.Sprintf(
+				"%v %v mtu %v", p.macField(), p.ip.ToString(), p.mtu,
+			))
+		}
+	}
+	for _, s := range e.switches {
+		add("switch "+s.name, fmt.Sprintf("%v ports aging %v", len(s.ports), s.aging))
+		for number, p := range s.ports {
+			if !p.empty() {
+				add(fmt.Sprintf("switch %v port %v", s.name, number), p.String())
+			}
+		}
+	}
+	for _, r := range e.routers {
+		for _, ent := range r.routerTable {
+			if ent.source == STATIC_ROUTE {
+				add(fmt.Sprintf("route %v %v", r.name, ent.netdest.ToString()), fmt.Sprintf(
+					"via %v port %v", ent.nexthop.ip, ent.port,
+				))
+			}
+		}
+	}
+	return items, values
+}
+
+// DiffTopology compares the nodes, router ports, switches and static routes of
+// the environments. The names are compared without case.
+func DiffTopology(oldEnv, newEnv Environment) []TopologyChange {
+	oldItems, oldValues := oldEnv.(*environment).topologyItems()
+	newItems, newValues := newEnv.(*environment).topologyItems()
+	lower := func(values map[string]string) map[string]string {
+		byKey := make(map[string]string, len(values))
+		for item, value := range values {
+			byKey[strings.ToLower(item)] = value
+		}
+		return byKey
+	}
+	oldByKey, newByKey := lower(oldValues), lower(newValues)
+
+	changes := make([]TopologyChange, 0)
+	for _, item := range oldItems {
+		value, kept := newByKey[strings.ToLower(item)]
+		switch {
+		case !kept:
+			changes = append(changes, TopologyChange{Kind: CHANGE_REMOVED, Item: item, Old: oldValues[item]})
+		case value != oldValues[item]:
+			changes = append(changes, TopologyChange{Kind: CHANGE_CHANGED, Item: item, Old: oldValues[item], New: value})
+		}
+	}
+	for _, item := range newItems {
+		if _, existed := oldByKey[strings.ToLower(item)]; !existed {
+			changes = append(changes, TopologyChange{Kind: CHANGE_ADDED, Item: item, New: newValues[item]})
+		}
+	}
+	return changes
+}
+
+/*
+----------------------------------------------------
+Reachability changes
+----------------------------------------------------
+*/
+
+func samePath(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !strings.EqualFold(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// DiffReachability returns the pings between nodes of both topologies whose
+// outcome, path or fragments changed, in the order of the new topology
+func DiffReachability(oldPings, newPings []PingOutcome) []PairChange {
+	byPair := make(map[string]PingOutcome, len(oldPings))
+	for _, o := range oldPings {
+		byPair[strings.ToLower(o.Src+" "+o.Dst)] = o
+	}
+
+	pairs := make([]PairChange, 0)
+	for _, o := range newPings {
+		before, existed := byPair[strings.ToLower(o.Src+" "+o.Dst)]
+		if !existed {
+			continue
+		}
+		if before.Outcome != o.Outcome || before.Fragments != o.Fragments || !samePath(before.Path, o.Path) {
+			pairs = append(pairs, PairChange{Src: o.Src, Dst: o.Dst, Old: before, New: o})
+		}
+	}
+	return pairs
+}
+
+// DiffEnvironments compares the topologies and pings every node from every
+// other on both, as the matrix command does
+func DiffEnvironments(oldEnv, newEnv Environment, msg string, workers int) TopologyDiff {
+	_, oldPings := PingMatrix(oldEnv, false, msg, workers)
+	_, newPings := PingMatrix(newEnv, false, msg, workers)
+	return TopologyDiff{
+		Changes: DiffTopology(oldEnv, newEnv),
+		Pairs:   DiffReachability(oldPings, newPings),
+	}
+}
+
+func (o PingOutcome) pathText() string {
+	if len(o.Path) == 0 {
+		return "none"
+	}
+	return strings.Join(o.Path, " ")
+}
+
+// describe tells what changed on the ping of the pair
+func (p PairChange) describe() string {
+	changes := make([]string, 0, 3)
+	if p.Old.Outcome != p.New.Outcome {
+		changes = append(changes, fmt.Sprintf("%v -> %v", p.Old.Outcome, p.New.Outcome))
+	}
+	if !samePath(p.Old.Path, p.New.Path) {
+		changes = append(changes, fmt.Sprintf("path %v -> %v", p.Old.pathText(), p.New.pathText()))
+	}
+	if p.Old.Fragments != p.New.Fragments {
+		changes = append(changes, fmt.Sprintf("fragments %v -> %v", p.Old.Fragments, p.New.Fragments))
+	}
+	return strings.Join(changes, ", ")
+}
+
+// Write prints the changes of the topology, followed by the changed pairs
+func (d TopologyDiff) Write(w io.Writer) {
+	fmt.Fprintln(w, "Topology")
+	for _, c := range d.Changes {
+		switch c.Kind {
+		case CHANGE_ADDED:
+			fmt.Fprintf(w, "  + %v: %v\n", c.Item, c.New)
+		case CHANGE_REMOVED:
+			fmt.Fprintf(w, "  - %v: %v\n", c.Item, c.Old)
+		default:
+			fmt.Fprintf(w, "  ~ %v: %v -> %v\n", c.Item, c.Old, c.New)
+		}
+	}
+	if len(d.Changes) == 0 {
+		fmt.Fprintln(w, "  none")
+	}
+
+	fmt.Fprintln(w, "Reachability")
+	for _, p := range d.Pairs {
+		fmt.Fprintf(w, "  %v -> %v: %v\n", p.Src, p.Dst, p.describe())
+	}
+	if len(d.Pairs) == 0 {
+		fmt.Fprintln(w, "  none")
+	}
+	fmt.Fprintf(w, "\nchanges: %v, changed pairs: %v\n", len(d.Changes), len(d.Pairs))
+}
+
+/*
+----------------------------------------------------
+Diff command
+----------------------------------------------------
+*/
+
+// Diff prints what changed from a topology to another and the pings it changed
+func Diff(ctx *cli.Context) error {
+	args := ctx.Args()
+	if len(args) != 2 {
+		return &UsageError{Msg: "Invalid diff arguments, expected the old and the new topology"}
+	}
+
+	envs := make([]Environment, 0, 2)
+	for _, path := range args {
+		env, err := LoadEnvironment(path)
+		if err != nil {
+			return err
+		}
+		PrintWarnings(env)
+		if err := SetLinkFailures(env, ctx.StringSlice("down")); err != nil {
+			return err
+		}
+		envs = append(envs, env)
+	}
+
+	diff := DiffEnvironments(envs[0], envs[1], ctx.String("message"), ctx.Int("jobs"))
+	diff.Write(os.Stdout)
+	if diff.Len() > 0 {
+		return cli.NewExitError("", 1)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package simulator
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func exampleText(t *testing.T, name string) string {
+	t.Helper()
+
+	text, err := os.ReadFile(filepath.Join(EXAMPLES_DIR, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(text)
+}
+
+func TestDiffSameTopology(t *testing.T) {
+	diff := DiffEnvironments(loadExample(t, "example3.txt"), loadExample(t, "example3.txt"), "hello", 0)
+	if diff.Len() != 0 {
+		var out bytes.Buffer
+		diff.Write(&out)
+		t.Errorf("Expected no changes, got:\n%v", out.String())
+	}
+}
+
+func TestDiffTopology(t *testing.T) {
+	oldEnv, err := loadTopology(t, twoNetworks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed := strings.NewReplacer(
+		"n2,00:00:00:00:00:02,20.0.0.2/24,5", "N2,00:00:00:00:00:02,20.0.0.2/24,3",
+		"r1,20.0.0.0/24,0.0.0.0,1\n", "",
+		"#ROUTERTABLE", "#NODE\nn3,00:00:00:00:00:03,10.0.0.3/24,5,10.0.0.1\n#ROUTERTABLE",
+	).Replace(twoNetworks)
+	newEnv, err := loadTopology(t, changed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []TopologyChange{
+		{CHANGE_CHANGED, "node n2", "00:00:00:00:00:02 20.0.0.2/24 mtu 5 gateway 20.0.0.1", "00:00:00:00:00:02 20.0.0.2/24 mtu 3 gateway 20.0.0.1"},
+		{CHANGE_REMOVED, "route r1 20.0.0.0/24", "via 0.0.0.0 port 1", ""},
+		{CHANGE_ADDED, "node n3", "", "00:00:00:00:00:03 10.0.0.3/24 mtu 5 gateway 10.0.0.1"},
+	}
+	changes := DiffTopology(oldEnv, newEnv)
+	if len(changes) != len(expected) {
+		t.Fatalf("Expected %v changes, got %+v", len(expected), changes)
+	}
+	for i, c := range changes {
+		if c != expected[i] {
+			t.Errorf("Change %v: expected %+v, got %+v", i, expected[i], c)
+		}
+	}
+}
+
+func TestDiffReachability(t *testing.T) {
+	oldEnv := loadExample(t, "example1.txt")
+	// n4 moves to a network that r1 does not reach
+	newEnv, err := loadTopology(t, strings.Replace(
+		exampleText(t, "example1.txt"), "n4,00:00:00:00:00:04,192.168.1.3/24,5,192.168.1.1", "n4,00:00:00:00:00:04,192.168.2.3/24,5,192.168.2.1", 1,
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diff := DiffEnvironments(oldEnv, newEnv, "hello", 0)
+	if len(diff.Changes) != 1 || diff.Changes[0].Item != "node n4" {
+		t.Errorf("Expected n4 to change, got %+v", diff.Changes)
+	}
+	for _, p := range diff.Pairs {
+		if p.Src != "n4" && p.Dst != "n4" {
+			t.Errorf("Unexpected change of %v -> %v: %v", p.Src, p.Dst, p.describe())
+		}
+		if p.Old.Outcome != PING_REPLY || p.New.Outcome != PING_UNREACHABLE {
+			t.Errorf("%v -> %v: expected reply -> unreachable, got %v", p.Src, p.Dst, p.describe())
+		}
+	}
+	if len(diff.Pairs) != 6 {
+		t.Errorf("Expected the 6 pings of n4 to change, got %v", len(diff.Pairs))
+	}
+
+	var out bytes.Buffer
+	diff.Write(&out)
+	if !strings.Contains(out.String(), "  n1 -> n4: reply -> unreachable, path n1 r1 n4 -> n1 r1\n") {
+		t.Errorf("Unexpected diff:\n%v", out.String())
+	}
+}
@@ -0,0 +1,71 @@
+package simulator
+
+import "fmt"
+
+// UsageError is an invalid command line
+type UsageError struct {
+	Msg string
+}
+
+func (e *UsageError) Error() string {
+	return e.Msg
+}
+
+// TopologyError is an invalid line of the topology file
+type TopologyError struct {
+	// File of the topology, when it was read from one
+	Path string
+	// Number of the line, starting at 1, and its content
+	Line int
+	Text string
+	Msg  string
+}
+
+func (e *TopologyError) Error() string {
+	if e.Path != "" {
+		return fmt.Sprintf("%v:%v: %v (%q)", e.Path, e.Line, e.Msg, e.Text)
+	}
+	return fmt.Sprintf("line %v: %v (%q)", e.Line, e.Msg, e.Text)
+}
+
+// UnknownNodeError is a source or destination that is not a node of the topology
+type UnknownNodeError struct {
+	Name string
+	// Either "source" or "destination"
+	Role string
+}
+
+func (e *UnknownNodeError) Error() string {
+	return fmt.Sprintf("The %v %v is not a node of the topology", e.Role, e.Name)
+}
+
+// NoGatewayError is a node whose default gateway is not the port of any router
+type NoGatewayError struct {
+	Node    string
+	Gateway string
+}
+
+func (e *NoGatewayError) Error() string {
+	return fmt.Sprintf("Node %v has no router on its default gateway %v", e.Node, e.Gateway)
+}
+
+// NoRouteError is a router without a route to the destination
+type NoRouteError struct {
+	Router string
+	Dest   string
+}
+
+func (e *NoRouteError) Error() string {
+	return fmt.Sprintf("Router %v has no route to %v", e.Router, e.Dest)
+}
+
+// ArpError is an ARP request that no device answers
+type ArpError struct {
+	// Device that sent the request and the IP it asked for
+	Device string
+	Ip     string
+}
+
+func (e *ArpError) Error() string {
+	return fmt.Sprintf("No device answered the ARP request of %v for %v", e.Device, e.Ip)
+}
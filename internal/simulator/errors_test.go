@@ -0,0 +1,209 @@
+package simulator
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/arielril/network-simulator/internal/event"
+)
+
+// loadTopology writes the topology on a temporary file and loads it
+func loadTopology(t *testing.T, topology string) (Environment, error) {
+	t.Helper()
+
+	dir, err := os.MkdirTemp("", "topology")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "topology.txt")
+	if err := os.WriteFile(path, []byte(strings.TrimSpace(topology)+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return LoadEnvironment(path)
+}
+
+// pingTopology loads the topology and pings the destination, discarding the output
+func pingTopology(t *testing.T, topology, src, dst string) error {
+	t.Helper()
+
+	env, err := loadTopology(t, topology)
+	if err != nil {
+		t.Fatalf("Failed to load the topology: %v", err)
+	}
+	env.SetSink(&event.Recorder{})
+	return Ping(env, src, dst, "hello")
+}
+
+const twoNetworks = `
+#NODE
+n1,00:00:00:00:00:01,10.0.0.2/24,5,10.0.0.1
+n2,00:00:00:00:00:02,20.0.0.2/24,5,20.0.0.1
+#ROUTER
+r1,2,00:00:00:00:00:10,10.0.0.1/24,5,00:00:00:00:00:11,20.0.0.1/24,5
+#ROUTERTABLE
+r1,10.0.0.0/24,0.0.0.0,0
+r1,20.0.0.0/24,0.0.0.0,1
+`
+
+func TestMissingFile(t *testing.T) {
+	_, err := LoadEnvironment(filepath.Join(EXAMPLES_DIR, "missing.txt"))
+	var pathErr *os.PathError
+	if !errors.As(err, &pathErr) {
+		t.Fatalf("expected an *os.PathError, got %v", err)
+	}
+}
+
+func TestTopologyErrors(t *testing.T) {
+	cases := []struct {
+		name string
+		old  string
+		new  string
+		line int
+		msg  string
+	}{
+		{"node fields", "n2,00:00:00:00:00:02,20.0.0.2/24,5,20.0.0.1", "n2,00:00:00:00:00:02", 3, "Expected 5 fields"},
+		{"node MAC", "00:00:00:00:00:02", "00:00:00:00:02", 3, "Invalid MAC address"},
+		{"node IP", "20.0.0.2/24", "20.0.0.2", 3, "Invalid IP address"},
+		{"node MTU", "20.0.0.2/24,5", "20.0.0.2/24,0", 3, "Invalid MTU"},
+		{"router ports", "r1,2,", "r1,3,", 5, "Expected 11 fields for 3 ports"},
+		{"unknown router", "r1,20.0.0.0/24", "r2,20.0.0.0/24", 8, "Unknown router r2"},
+		{"unknown port", "0.0.0.0,1", "0.0.0.0,2", 8, "has no port 2"},
+	}
+
+	for _, tc := range cases {
+		_, err := loadTopology(t, strings.Replace(twoNetworks, tc.old, tc.new, 1))
+		var topoErr *TopologyError
+		if !errors.As(err, &topoErr) {
+			t.Errorf("%v: expected a *TopologyError, got %v", tc.name, err)
+			continue
+		}
+		if topoErr.Line != tc.line || !strings.Contains(topoErr.Msg, tc.msg) {
+			t.Errorf("%v: want line %v with %q, got %v", tc.name, tc.line, tc.msg, err)
+		}
+	}
+}
+
+func TestUnknownNode(t *testing.T) {
+	for _, tc := range []struct{ src, dst, role string }{
+		{"n9", "n2", "source"},
+		{"n1", "n9", "destination"},
+		{"r1", "n2", "source"},
+	} {
+		err := pingTopology(t, twoNetworks, tc.src, tc.dst)
+		var nodeErr *UnknownNodeError
+		if !errors.As(err, &nodeErr) || nodeErr.Role != tc.role {
+			t.Errorf("%v -> %v: expected an unknown %v, got %v", tc.src, tc.dst, tc.role, err)
+		}
+	}
+}
+
+func TestNoGateway(t *testing.T) {
+	topology := strings.Replace(twoNetworks, "5,10.0.0.1", "5,10.0.0.9", 1)
+	err := pingTopology(t, topology, "n1", "n2")
+	var gatewayErr *NoGatewayError
+	if !errors.As(err, &gatewayErr) || gatewayErr.Node != "n1" || gatewayErr.Gateway != "10.0.0.9" {
+		t.Errorf("expected a missing gateway of n1, got %v", err)
+	}
+}
+
+// behindRouter moves n2 to a network that is not connected to r1
+func behindRouter(topology string) string {
+	return strings.Replace(topology, "20.0.0.2/24,5,20.0.0.1", "30.0.0.2/24,5,30.0.0.1", 1)
+}
+
+func TestNoRoute(t *testing.T) {
+	err := pingTopology(t, behindRouter(twoNetworks), "n1", "n2")
+	var routeErr *NoRouteError
+	if !errors.As(err, &routeErr) || routeErr.Router != "r1" || routeErr.Dest != "30.0.0.2" {
+		t.Errorf("expected r1 without route to 30.0.0.2, got %v", err)
+	}
+}
+
+func TestUnresolvedArp(t *testing.T) {
+	topology := strings.Replace(behindRouter(twoNetworks), "r1,20.0.0.0/24,0.0.0.0,1", "r1,30.0.0.0/24,20.0.0.7,1", 1)
+	err := pingTopology(t, topology, "n1", "n2")
+	var arpErr *ArpError
+	if !errors.As(err, &arpErr) || arpErr.Device != "r1" || arpErr.Ip != "20.0.0.7" {
+		t.Errorf("expected an unanswered ARP request of r1 for 20.0.0.7, got %v", err)
+	}
+}
+
+func TestEmptyMessage(t *testing.T) {
+	env, err := loadTopology(t, twoNetworks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var usageErr *UsageError
+	if err := Ping(env, "n1", "n2", ""); !errors.As(err, &usageErr) {
+		t.Errorf("expected a *UsageError, got %v", err)
+	}
+}
+
+func TestStaticRouteWarnings(t *testing.T) {
+	topology := twoNetworks + "r1,30.0.0.0/24,20.0.0.9,0\nr1,40.0.0.0/24,50.0.0.1,1\nr1,20.0.0.0/24,10.0.0.5,0\n"
+	env, err := loadTopology(t, topology)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	warnings := env.Warnings()
+	for i, want := range []string{
+		":7: The route to 10.0.0.0/24 duplicates the connected route of port 0",
+		":8: The route to 20.0.0.0/24 duplicates the connected route of port 1",
+		":9: The next hop 20.0.0.9 is on the network of port 1, not of port 0",
+		":10: The next hop 50.0.0.1 is not on a network connected to router r1",
+		":11: The route to 20.0.0.0/24 contradicts the connected route of port 1",
+	} {
+		if i >= len(warnings) || !strings.Contains(warnings[i], want) {
+			t.Errorf("Expected warning %q, got %q", want, warnings)
+		}
+	}
+	if len(warnings) != 5 {
+		t.Errorf("Expected 5 warnings, got %q", warnings)
+	}
+
+	// the connected route wins over the contradicting static route
+	env.SetSink(&event.Recorder{})
+	if err := Ping(env, "n1", "n2", "hello"); err != nil {
+		t.Error(err)
+	}
+}
+
+// The static route to a network inside the one of a connected route wins, as
+// its prefix is longer
+func TestStaticRouteInConnectedNetwork(t *testing.T) {
+	topology := `
+#NODE
+n1,00:00:00:00:00:01,30.0.0.2/24,5,30.0.0.1
+n2,00:00:00:00:00:02,10.1.2.2/24,5,10.1.2.1
+#ROUTER
+r1,3,00:00:00:00:00:10,30.0.0.1/24,5,00:00:00:00:00:11,100.0.1.1/24,5,00:00:00:00:00:12,10.0.0.1/8,5
+r2,2,00:00:00:00:00:20,100.0.1.2/24,5,00:00:00:00:00:21,10.1.2.1/24,5
+#ROUTERTABLE
+r1,10.1.2.0/24,100.0.1.2,1
+r2,0.0.0.0/0,100.0.1.1,0
+`
+	env, err := loadTopology(t, topology)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if warnings := env.Warnings(); len(warnings) != 0 {
+		t.Errorf("Expected no warnings, got %q", warnings)
+	}
+
+	rec := &event.Recorder{}
+	env.SetSink(rec)
+	if err := Ping(env, "n1", "n2", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	for _, ev := range rec.Events {
+		if ev.Kind == event.ECHO_REQUEST && ev.Src == "r1" && ev.Dst != "r2" {
+			t.Errorf("Expected r1 to forward the echo request to r2, not to %v", ev.Dst)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package simulator
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/arielril/network-simulator/internal/event"
+)
+
+var update = flag.Bool("update", false, "regenerate the golden files of the simulator output")
+
+const (
+	EXAMPLES_DIR = "../../examples"
+	GOLDEN_DIR   = "testdata/golden"
+	README_PATH  = "../../README.md"
+	// Topology used by the transcripts of the README
+	README_TOPOLOGY = "example1.txt"
+)
+
+// goldenCase is a ping whose output is kept on testdata/golden/<name>.txt
+type goldenCase struct {
+	name     string
+	topology string
+	src      string
+	dst      string
+	msg      string
+}
+
+var goldenCases = []goldenCase{
+	{"example1_n1_n4_hello", "example1.txt", "n1", "n4", "hello"},
+	{"example1_n3_n1_helloworld", "example1.txt", "n3", "n1", "helloworld"},
+	{"example2_n1_n5_hello", "example2.txt", "n1", "n5", "hello"},
+	{"example2_n1_n4_helloworld", "example2.txt", "n1", "n4", "helloworld"},
+	{"example3_n1_n4_time_exceeded", "example3.txt", "n1", "n4", "hello"},
+	{"example3_n5_n2_time_exceeded", "example3.txt", "n5", "n2", "hello"},
+	{"example4_n3_n6_hello", "example4.txt", "n3", "n6", "hello"},
+	{"example5_n3_n2_hello", "example5.txt", "n3", "n2", "hello"},
+	{"example6_n1_n6_helloworldabc", "example6.txt", "n1", "n6", "helloworldabc"},
+	{"example6_n3_n6_fragmented", "example6.txt", "n3", "n6", "abcdefghijklmnopqrstuvwxyz"},
+	{"example10_n1_n4_switched", "example10.txt", "n1", "n4", "hello"},
+	{"example11_n1_n4_vlans", "example11.txt", "n1", "n4", "hello"},
+}
+
+// simulate runs the ping and returns the lines printed by the simulator
+func simulate(t *testing.T, topology, src, dst, msg string) []string {
+	t.Helper()
+
+	env, err := LoadEnvironment(filepath.Join(EXAMPLES_DIR, topology))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	env.SetSink(event.Writer{W: &out})
+
+	if err := Ping(env, src, dst, msg); err != nil {
+		t.Fatalf("Failed to ping %v from %v: %v", dst, src, err)
+	}
+	return splitLines(out.String())
+}
+
+func splitLines(text string) []string {
+	text = strings.TrimRight(text, "\n")
+	if text == "" {
+		return []string{}
+	}
+	return strings.Split(text, "\n")
+}
+
+// diffLines compares the output line by line and describes every difference
+func diffLines(want, got []string) string {
+	var report strings.Builder
+
+	for i := 0; i < len(want) || i < len(got); i++ {
+		switch {
+		case i >= len(got):
+			fmt.Fprintf(&report, "line %v: missing\n  want: %v\n", i+1, want[i])
+		case i >= len(want):
+			fmt.Fprintf(&report, "line %v: unexpected\n  got:  %v\n", i+1, got[i])
+		case want[i] != got[i]:
+			fmt.Fprintf(&report, "line %v:\n  want: %v\n  got:  %v\n", i+1, want[i], got[i])
+		}
+	}
+	return report.String()
+}
+
+func TestGolden(t *testing.T) {
+	for _, tc := range goldenCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			got := simulate(t, tc.topology, tc.src, tc.dst, tc.msg)
+			path := filepath.Join(GOLDEN_DIR, tc.name+".txt")
+
+			if *update {
+				if err := os.MkdirAll(GOLDEN_DIR, 0755); err != nil {
+					t.Fatal(err)
+				}
+				content := strings.Join(got, "\n") + "\n"
+				if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			content, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("Failed to read golden file (run with -update to create it): %v", err)
+			}
+			if diff := diffLines(splitLines(string(content)), got); diff != "" {
+				t.Errorf(
+					"simulador %v %v %v %v differs from %v:\n%v",
+					tc.topology, tc.src, tc.dst, tc.msg, path, diff,
+				)
+			}
+		})
+	}
+}
+
+// readmeTranscript is an execution example documented on the README
+type readmeTranscript struct {
+	args  []string
+	lines []string
+}
+
+// redirected tells if the output of the command goes to a file or another
+// command, so the README does not show it
+func redirected(command string) bool {
+	for _, field := range strings.Fields(command) {
+		if field == ">" || field == "|" {
+			return true
+		}
+	}
+	return false
+}
+
+// readReadmeTranscripts returns the "$ simulador ..." code blocks of the README
+func readReadmeTranscripts(t *testing.T) []readmeTranscript {
+	t.Helper()
+
+	f, err := os.Open(README_PATH)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	transcripts := make([]readmeTranscript, 0)
+	var current *readmeTranscript
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "$ simulador topologia.txt ") && !redirected(line):
+			fields := strings.Fields(line)
+			current = &readmeTranscript{args: fields[3:]}
+		case current != nil && strings.HasPrefix(line, "```"):
+			transcripts = append(transcripts, *current)
+			current = nil
+		case current != nil:
+			current.lines = append(current.lines, line)
+		}
+	}
+	return transcripts
+}
+
+func TestReadmeTranscripts(t *testing.T) {
+	transcripts := readReadmeTranscripts(t)
+	if len(transcripts) != 4 {
+		t.Fatalf("Expected the 4 transcripts of the README, found %v", len(transcripts))
+	}
+
+	for _, tr := range transcripts {
+		tr := tr
+		t.Run(strings.Join(tr.args, "_"), func(t *testing.T) {
+			if len(tr.args) != 3 {
+				t.Fatalf("Invalid README command: %v", tr.args)
+			}
+			got := simulate(t, README_TOPOLOGY, tr.args[0], tr.args[1], tr.args[2])
+			if diff := diffLines(tr.lines, got); diff != "" {
+				t.Errorf("Output differs from the README:\n%v", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package simulator
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/arielril/network-simulator/internal/event"
+	"github.com/urfave/cli"
+)
+
+// Colors of the path of a ping on the graph
+const (
+	DOT_REQUEST_COLOR       string = "#2ca02c"
+	DOT_REPLY_COLOR         string = "#1f77b4"
+	DOT_TIME_EXCEEDED_COLOR string = "#d62728"
+	DOT_FRAGMENT_COLOR      string = "#ff7f0e"
+)
+
+/*
+----------------------------------------------------
+Path of a ping
+----------------------------------------------------
+*/
+
+// hop is a transmission between two components, with all of its fragments
+type hop struct {
+	number    int
+	kind      event.Kind
+	src       string
+	dst       string
+	fragments int
+	// tells if the sender split the packet in more fragments than it received
+	fragmented bool
+}
+
+// pathHops groups the IP frames of a simulation in hops
+func pathHops(events []event.Event) []hop {
+	hops := make([]hop, 0)
+	lastFragments := make(map[event.Kind]int)
+
+	for _, ev := range events {
+		if ev.Kind != event.ECHO_REQUEST && ev.Kind != event.ECHO_REPLY && ev.Kind != event.TIME_EXCEEDED {
+			continue
+		}
+
+		last := len(hops) - 1
+		if last >= 0 && hops[last].kind == ev.Kind && hops[last].src == ev.Src && hops[last].dst == ev.Dst {
+			hops[last].fragments++
+			continue
+		}
+		hops = append(hops, hop{
+			number:    len(hops) + 1,
+			kind:      ev.Kind,
+			src:       ev.Src,
+			dst:       ev.Dst,
+			fragments: 1,
+		})
+	}
+
+	for i := range hops {
+		prev, seen := lastFragments[hops[i].kind]
+		if !seen {
+			prev = 1
+		}
+		hops[i].fragmented = hops[i].fragments > prev
+		lastFragments[hops[i].kind] = hops[i].fragments
+	}
+	return hops
+}
+
+/*
+----------------------------------------------------
+DOT writer
+----------------------------------------------------
+*/
+
+var dotRecordEscaper = strings.NewReplacer(
+	`"`, `\"`, "{", `\{`, "}", `\}`, "|", `\|`, "<", `\<`, ">", `\>`,
+)
+
+func dotQuote(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
+
+func dotSegmentId(net IP) string {
+	return dotQuote("net:" + net.ToString())
+}
+
+func dotInterfaceLabel(netInt netInterface) string {
+	label := fmt.Sprintf("%v\\n%v\\nmtu=%v", netInt.ip.ToString(), netInt.mac, netInt.mtu)
+	if netInt.vlan != 0 {
+		label += fmt.Sprintf("\\nvlan=%v", netInt.vlan)
+	}
+	return label
+}
+
+func dotHopColor(kind event.Kind) string {
+	switch kind {
+	case event.ECHO_REPLY:
+		return DOT_REPLY_COLOR
+	case event.TIME_EXCEEDED:
+		return DOT_TIME_EXCEEDED_COLOR
+	}
+	return DOT_REQUEST_COLOR
+}
+
+// WriteDot writes the topology as a Graphviz graph. The IP frames of the
+// events, if any, are drawn over it as the path taken by the ping.
+func (e *environment) WriteDot(w io.Writer, events []event.Event) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "graph topology {")
+	fmt.Fprintln(bw, "  rankdir=LR;")
+	fmt.Fprintln(bw, "  node [fontname=\"monospace\", fontsize=10];")
+	fmt.Fprintln(bw, "  edge [fontname=\"monospace\", fontsize=10];")
+
+	segments := make([]IP, 0)
+	seen := make(map[IP]bool)
+	addSegment := func(ip IP) IP {
+		net := ip.Network()
+		if !seen[net] {
+			seen[net] = true
+			segments = append(segments, net)
+		}
+		return net
+	}
+
+	links := make([]string, 0)
+	for _, n := range e.nodes {
+		fmt.Fprintf(
+			bw, "  %v [shape=box, label=\"%v\\n%v\\ngw=%v\"];\n",
+			dotQuote(n.name), strings.ReplaceAll(n.name, `"`, `\"`), dotInterfaceLabel(n.netPort), n.gateway.ip,
+		)
+		net := addSegment(n.netPort.ip)
+		links = append(links, fmt.Sprintf("  %v -- %v;", dotQuote(n.name), dotSegmentId(net)))
+	}
+
+	for _, r := range e.routers {
+		ports := make([]string, len(r.ports))
+		for i, p := range r.ports {
+			ports[i] = fmt.Sprintf("<p%v> %v\\n%v", p.number, p.number, dotInterfaceLabel(p.netInterface))
+
+			net := addSegment(p.ip)
+			links = append(links, fmt.Sprintf("  %v:p%v -- %v;", dotQuote(r.name), p.number, dotSegmentId(net)))
+		}
+		fmt.Fprintf(
+			bw, "  %v [shape=record, label=\"{%v|{%v}}\"];\n",
+			dotQuote(r.name), dotRecordEscaper.Replace(r.name), strings.Join(ports, "|"),
+		)
+	}
+
+	for _, net := range segments {
+		fmt.Fprintf(bw, "  %v [shape=ellipse, style=dashed, label=%v];\n", dotSegmentId(net), dotQuote(net.ToString()))
+	}
+	for _, l := range links {
+		fmt.Fprintln(bw, l)
+	}
+
+	for _, h := range pathHops(events) {
+		color := dotHopColor(h.kind)
+		label := fmt.Sprintf("%v", h.number)
+		if h.fragments > 1 {
+			label = fmt.Sprintf("%v (%v frags)", h.number, h.fragments)
+		}
+		style := ""
+		if h.fragmented {
+			style = ", penwidth=3, style=bold"
+			fmt.Fprintf(bw, "  %v [style=filled, fillcolor=%v];\n", dotQuote(h.src), dotQuote(DOT_FRAGMENT_COLOR))
+		}
+		fmt.Fprintf(
+			bw, "  %v -- %v [dir=forward, constraint=false, color=%v, fontcolor=%v, label=%v%v];\n",
+			dotQuote(h.src), dotQuote(h.dst), dotQuote(color), dotQuote(color), dotQuote(label), style,
+		)
+	}
+
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
+
+/*
+----------------------------------------------------
+Graph command
+----------------------------------------------------
+*/
+
+// Graph prints the topology as a DOT graph, with the path of a ping when the
+// source, destination and message are given
+func Graph(ctx *cli.Context) error {
+	args := ctx.Args()
+	if !args.Present() {
+		return &UsageError{Msg: "Invalid graph arguments"}
+	}
+
+	env, err := LoadEnvironment(args.Get(0))
+	if err != nil {
+		return err
+	}
+
+	recorder := &event.Recorder{}
+	if len(args) > 1 {
+		if len(args) != 4 {
+			return &UsageError{Msg: "The path of a ping needs the source, destination and message"}
+		}
+		env.SetSink(recorder)
+		err := Ping(env, args.Get(1), args.Get(2), args.Get(3))
+		if err != nil {
+			return err
+		}
+	}
+
+	return env.WriteDot(os.Stdout, recorder.Events)
+}
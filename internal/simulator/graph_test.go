@@ -0,0 +1,97 @@
+package simulator
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/arielril/network-simulator/internal/event"
+)
+
+// The graphs of the topologies, with the path of a ping, are kept on
+// testdata/golden/<name>.dot
+var graphCases = []goldenCase{
+	{"example3_n1_n4_time_exceeded", "example3.txt", "n1", "n4", "hello"},
+	{"example6_n3_n6_fragmented", "example6.txt", "n3", "n6", "abcdefghijklmnopqrstuvwxyz"},
+	{"example11_n1_n4_vlans", "example11.txt", "n1", "n4", "hello"},
+}
+
+func TestGraphGolden(t *testing.T) {
+	for _, tc := range graphCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			env, err := LoadEnvironment(filepath.Join(EXAMPLES_DIR, tc.topology))
+			if err != nil {
+				t.Fatal(err)
+			}
+			recorder := &event.Recorder{}
+			env.SetSink(recorder)
+			if err := Ping(env, tc.src, tc.dst, tc.msg); err != nil {
+				t.Fatal(err)
+			}
+			var out bytes.Buffer
+			if err := env.WriteDot(&out, recorder.Events); err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(GOLDEN_DIR, tc.name+".dot")
+
+			if *update {
+				if err := os.MkdirAll(GOLDEN_DIR, 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, out.Bytes(), 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			content, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("Failed to read golden file (run with -update to create it): %v", err)
+			}
+			if diff := diffLines(splitLines(string(content)), splitLines(out.String())); diff != "" {
+				t.Errorf(
+					"simulador graph %v %v %v %v differs from %v:\n%v",
+					tc.topology, tc.src, tc.dst, tc.msg, path, diff,
+				)
+			}
+		})
+	}
+}
+
+// The fragments of each hop are counted, and the hops where the sender split
+// the packet in more fragments are marked
+func TestPathHops(t *testing.T) {
+	env, err := LoadEnvironment(filepath.Join(EXAMPLES_DIR, "example6.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := &event.Recorder{}
+	env.SetSink(recorder)
+	if err := Ping(env, "n3", "n6", "abcdefghijklmnopqrstuvwxyz"); err != nil {
+		t.Fatal(err)
+	}
+
+	request, reply := event.ECHO_REQUEST, event.ECHO_REPLY
+	want := []hop{
+		{1, request, "N3", "R2", 4, true},
+		{2, request, "R2", "R1", 4, false},
+		{3, request, "R1", "R3", 4, false},
+		{4, request, "R3", "R4", 4, false},
+		{5, request, "R4", "N6", 4, false},
+		{6, reply, "N6", "R4", 7, true},
+		{7, reply, "R4", "R3", 7, false},
+		{8, reply, "R3", "R2", 7, false},
+		{9, reply, "R2", "N3", 7, false},
+	}
+	got := pathHops(recorder.Events)
+	if len(got) != len(want) {
+		t.Fatalf("expected %v hops, got %+v", len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("hop %v: expected %+v, got %+v", i+1, want[i], got[i])
+		}
+	}
+}
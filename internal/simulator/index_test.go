@@ -0,0 +1,134 @@
+package simulator
+
+import (
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/arielril/network-simulator/internal/event"
+)
+
+// LARGE_TOPOLOGY_ROUTERS is the size of the topology of the benchmarks
+const LARGE_TOPOLOGY_ROUTERS = 10000
+
+// largeTopology has a core router and access routers on the same backbone,
+// each with a node on its own network. The access routers send everything to
+// the core, that has a route to every network.
+func largeTopology(routers int) []string {
+	lines := []string{NODE_LABEL}
+	for i := 1; i < routers; i++ {
+		lines = append(lines, fmt.Sprintf(
+			"n%v,00:00:00:01:%02X:%02X,10.%v.%v.2/24,255,10.%v.%v.1",
+			i, i>>8, i&0xFF, i>>8, i&0xFF, i>>8, i&0xFF,
+		))
+	}
+
+	lines = append(lines, ROUTER_LABEL, "core,1,00:00:00:02:00:00,100.64.0.1/16,255")
+	for i := 1; i < routers; i++ {
+		lines = append(lines, fmt.Sprintf(
+			"r%v,2,00:00:00:03:%02X:%02X,100.64.%v.%v/16,255,00:00:00:04:%02X:%02X,10.%v.%v.1/24,255",
+			i, i>>8, i&0xFF, (i+1)>>8, (i+1)&0xFF, i>>8, i&0xFF, i>>8, i&0xFF,
+		))
+	}
+
+	lines = append(lines, ROUTER_TABLE_LABEL)
+	for i := 1; i < routers; i++ {
+		lines = append(lines, fmt.Sprintf("r%v,0.0.0.0/0,100.64.0.1,0", i))
+		lines = append(lines, fmt.Sprintf("core,10.%v.%v.0/24,100.64.%v.%v,0", i>>8, i&0xFF, (i+1)>>8, (i+1)&0xFF))
+	}
+	return lines
+}
+
+func loadLargeTopology(tb testing.TB) Environment {
+	tb.Helper()
+
+	env := NewEnvironment()
+	if err := env.ParseLines(largeTopology(LARGE_TOPOLOGY_ROUTERS)); err != nil {
+		tb.Fatal(err)
+	}
+	env.SetSink(event.Writer{W: io.Discard})
+	return env
+}
+
+func TestLargeTopologyPing(t *testing.T) {
+	env := loadLargeTopology(t)
+	recorder := &event.Recorder{}
+	env.SetSink(recorder)
+
+	last := fmt.Sprintf("n%v", LARGE_TOPOLOGY_ROUTERS-1)
+	if err := Ping(env, "n1", last, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	received := recorder.Events[len(recorder.Events)-1]
+	if received.Kind != event.RECEIVED || received.Src != "n1" {
+		t.Errorf("expected n1 to receive the reply, got %v", received)
+	}
+}
+
+func TestIndexedLookups(t *testing.T) {
+	env := loadLargeTopology(t).(*environment)
+
+	if n, isNode := env.GetNetComponentByName("N42").(*node); !isNode || n.name != "n42" {
+		t.Errorf("expected n42 by name, got %v", n)
+	}
+	if r := env.GetNetComponentByMac("00:00:00:04:00:2A"); r == nil || r.GetName() != "r42" {
+		t.Errorf("expected r42 by MAC, got %v", r)
+	}
+	if r := env.GetNetComponentByIp(*NewIp("10.0.42.1/24")); r == nil || r.GetName() != "r42" {
+		t.Errorf("expected r42 by IP, got %v", r)
+	}
+	if r := env.GetNetComponentByIpOnly(*NewIp("100.64.0.1")); r == nil || r.GetName() != "core" {
+		t.Errorf("expected core by address, got %v", r)
+	}
+	if env.GetNetComponentByIp(*NewIp("10.0.42.1/16")) != nil {
+		t.Error("expected no device with a different prefix")
+	}
+}
+
+func BenchmarkLoadLargeTopology(b *testing.B) {
+	lines := largeTopology(LARGE_TOPOLOGY_ROUTERS)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := NewEnvironment().ParseLines(lines); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkPingLargeTopology(b *testing.B) {
+	env := loadLargeTopology(b)
+	last := fmt.Sprintf("n%v", LARGE_TOPOLOGY_ROUTERS-1)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := Ping(env, "n1", last, "hello"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// The ARP requests of the first ping are part of each run
+func BenchmarkFirstPingLargeTopology(b *testing.B) {
+	env := loadLargeTopology(b)
+	last := fmt.Sprintf("n%v", LARGE_TOPOLOGY_ROUTERS-1)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		clone := env.Clone()
+		clone.SetSink(event.Writer{W: io.Discard})
+		b.StartTimer()
+		if err := Ping(clone, "n1", last, "hello"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLookups(b *testing.B) {
+	env := loadLargeTopology(b).(*environment)
+	ip := *NewIp("10.39.15.1/24")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		env.GetNetComponentByName("n9999")
+		env.GetNetComponentByMac("00:00:00:04:27:0F")
+		env.GetNetComponentByIp(ip)
+	}
+}
@@ -4,13 +4,13 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
-
-	fp "github.com/novalagung/gubrak"
 )
 
 type IP struct {
 	ip     string
 	prefix uint8
+	// Address as a number, parsed once when the IP is created
+	bits uint32
 }
 
 func NewIp(ip string) *IP {
@@ -27,32 +27,22 @@ func NewIp(ip string) *IP {
 	netIp := &IP{
 		ip:     ipSplit[0],
 		prefix: prefix,
+		bits:   parseBits(ipSplit[0]),
 	}
 	return netIp
 }
 
-func (ip IP) ToBit() uint32 {
-	list, err := fp.Map(
-		strings.Split(ip.ip, "."),
-		func(part string) uint64 {
-			val, _ := strconv.ParseUint(part, 10, 32)
-			return val
-		},
-	)
-
-	if err != nil {
-		panic("Failed to convert IP")
+func parseBits(ip string) uint32 {
+	var bits uint32
+	for _, part := range strings.Split(ip, ".") {
+		val, _ := strconv.ParseUint(part, 10, 8)
+		bits = bits<<8 | uint32(val)
 	}
-	ipList := list.([]uint64)
-	bits := fmt.Sprintf(
-		"%08b%08b%08b%08b",
-		ipList[0],
-		ipList[1],
-		ipList[2],
-		ipList[3],
-	)
-	res, _ := strconv.ParseUint(bits, 2, 32)
-	return uint32(res)
+	return bits
+}
+
+func (ip IP) ToBit() uint32 {
+	return ip.bits
 }
 
 func (ip IP) IsSameNet(ipDest IP) bool {
@@ -70,3 +60,20 @@ func (ip IP) ToString() string {
 		ip.ip, ip.prefix,
 	)
 }
+
+func bitToIp(bits uint32) string {
+	return fmt.Sprintf(
+		"%v.%v.%v.%v",
+		bits>>24, (bits>>16)&0xFF, (bits>>8)&0xFF, bits&0xFF,
+	)
+}
+
+// Network returns the address of the subnet of the IP, with the same prefix
+func (ip IP) Network() IP {
+	bits := ip.ToBit() & (MASK << (32 - ip.prefix))
+	return IP{
+		ip:     bitToIp(bits),
+		prefix: ip.prefix,
+		bits:   bits,
+	}
+}
@@ -1,53 +1,59 @@
 package simulator
 
 import (
-	"fmt"
-
-	fp "github.com/novalagung/gubrak"
+	"github.com/arielril/network-simulator/internal/event"
 )
 
-func logArpRequest(pkt packet) {
-	fmt.Printf(
-		"%v box %v : ETH (src=%v dst=%v) \\n ARP - Who has %v? Tell %v;\n",
-		pkt.src.name, pkt.src.name, pkt.src.mac, pkt.dst.mac,
-		pkt.dst.ip.ip, pkt.src.ip.ip,
-	)
+func packetEvent(kind event.Kind, pkt packet) event.Event {
+	return event.Event{
+		Kind:   kind,
+		Src:    pkt.src.name,
+		Dst:    pkt.dst.name,
+		SrcMac: string(pkt.src.mac),
+		DstMac: string(pkt.dst.mac),
+		Vlan:   pkt.src.vlan,
+		SrcIp:  pkt.src.ip.ip,
+		DstIp:  pkt.dst.ip.ip,
+		Ttl:    pkt.ttl,
+		Mf:     pkt.mf,
+		Off:    pkt.off,
+		Data:   pkt.data,
+	}
 }
 
-func logArpReply(pkt packet) {
-	fmt.Printf(
-		"%v => %v : ETH (src=%v dst=%v) \\n ARP - %v is at %v;\n",
-		pkt.src.name, pkt.dst.name, pkt.src.mac, pkt.dst.mac,
-		pkt.src.ip.ip, pkt.src.mac,
-	)
+func logArpRequest(sink event.Sink, pkt packet) {
+	ev := packetEvent(event.ARP_REQUEST, pkt)
+	ev.Dst = ""
+	sink.Emit(ev)
 }
 
-func logIcmpRequest(pkts []*packet) {
-	fp.ForEach(pkts, func(pkt *packet) {
-		fmt.Printf(
-			"%v => %v : ETH (src=%v dst=%v) \\n IP (src=%v dst=%v ttl=%v mf=%v off=%v) \\n ICMP - Echo request (data=%v);\n",
-			pkt.src.name, pkt.dst.name, pkt.src.mac, pkt.dst.mac,
-			pkt.src.ip.ip, pkt.dst.ip.ip, pkt.ttl, pkt.mf, pkt.off, pkt.data,
-		)
-	})
+func logArpReply(sink event.Sink, pkt packet) {
+	sink.Emit(packetEvent(event.ARP_REPLY, pkt))
 }
 
-func logIcmpReply(pkts []*packet) {
-	fp.ForEach(pkts, func(pkt *packet) {
-		fmt.Printf(
-			"%v => %v : ETH (src=%v dst=%v) \\n IP (src=%v dst=%v ttl=%v mf=%v off=%v) \\n ICMP - Echo reply (data=%v);\n",
-			pkt.src.name, pkt.dst.name, pkt.src.mac, pkt.dst.mac,
-			pkt.src.ip.ip, pkt.dst.ip.ip, pkt.ttl, pkt.mf, pkt.off, pkt.data,
-		)
-	})
+func logIcmpRequest(sink event.Sink, pkts []*packet) {
+	for _, pkt := range pkts {
+		sink.Emit(packetEvent(event.ECHO_REQUEST, *pkt))
+	}
+}
+
+func logIcmpReply(sink event.Sink, pkts []*packet) {
+	for _, pkt := range pkts {
+		sink.Emit(packetEvent(event.ECHO_REPLY, *pkt))
+	}
+}
+
+func logIcmpTimeExceeded(sink event.Sink, pkts []*packet) {
+	for _, pkt := range pkts {
+		sink.Emit(packetEvent(event.TIME_EXCEEDED, *pkt))
+	}
 }
 
-func logIcmpTimeExceeded(pkts []*packet) {
-	fp.ForEach(pkts, func(pkt *packet) {
-		fmt.Printf(
-			"%v => %v : ETH (src=%v dst=%v) \\n IP (src=%v dst=%v ttl=%v mf=%v off=%v) \\n ICMP - Time Exceeded;\n",
-			pkt.src.name, pkt.dst.name, pkt.src.mac, pkt.dst.mac,
-			pkt.src.ip.ip, pkt.dst.ip.ip, pkt.ttl, pkt.mf, pkt.off,
-		)
+func logReceived(sink event.Sink, name, data string) {
+	sink.Emit(event.Event{
+		Kind: event.RECEIVED,
+		Src:  name,
+		Dst:  name,
+		Data: data,
 	})
 }
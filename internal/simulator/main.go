@@ -1,15 +1,21 @@
 package simulator
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net"
+	"os"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 
+	"github.com/arielril/network-simulator/internal/event"
 	"github.com/arielril/network-simulator/internal/file"
+	"github.com/arielril/network-simulator/internal/output"
 	"github.com/urfave/cli"
-
-	fp "github.com/novalagung/gubrak"
 )
 
 type MAC string
@@ -20,6 +26,12 @@ const (
 	NODE_LABEL         string = "#NODE"
 	ROUTER_LABEL       string = "#ROUTER"
 	ROUTER_TABLE_LABEL string = "#ROUTERTABLE"
+	RIP_LABEL          string = "#RIP"
+	OSPF_LABEL         string = "#OSPF"
+	BGP_LABEL          string = "#BGP"
+	BGP_NEIGHBOR_LABEL string = "#BGPNEIGHBOR"
+	SWITCH_LABEL       string = "#SWITCH"
+	INCLUDE_LABEL      string = "#INCLUDE"
 	MASK               uint32 = 0xFFFFFFFF
 )
 
@@ -33,10 +45,15 @@ const (
 	ICMP_TIME_EXCEEDED
 )
 
+// TTL of the packets sent by the nodes and routers
+const ICMP_TTL uint8 = 8
+
 type netInterface struct {
 	ip  IP
 	mac MAC
 	mtu MTU
+	// VLAN of the frames of the interface, 0 when they are not tagged
+	vlan uint16
 }
 
 /*
@@ -49,6 +66,8 @@ type packetHost struct {
 	name string
 	mac  MAC
 	ip   IP
+	// VLAN tag of the frames sent by the host, 0 when they are not tagged
+	vlan uint16
 }
 
 type packet struct {
@@ -79,6 +98,7 @@ func createBroadcastArpReq(srcName string, srcNetPort netInterface, ipDst IP) pa
 		name: srcName,
 		ip:   srcNetPort.ip,
 		mac:  srcNetPort.mac,
+		vlan: srcNetPort.vlan,
 	}
 	dstHost := packetHost{
 		ip:  ipDst,
@@ -92,13 +112,14 @@ func createIcmpReq(srcName, dstName string, srcNetPort, dstNetPort netInterface,
 		mac:  srcNetPort.mac,
 		ip:   srcNetPort.ip,
 		name: srcName,
+		vlan: srcNetPort.vlan,
 	}
 	dstHost := packetHost{
 		name: dstName,
 		ip:   dstNetPort.ip,
 		mac:  dstNetPort.mac,
 	}
-	return NewPacket(srcHost, dstHost, ICMP_REQ, data, 8, 0, 0)
+	return NewPacket(srcHost, dstHost, ICMP_REQ, data, ICMP_TTL, 0, 0)
 }
 
 func SetHosts(pkts []*packet, src, dest *packetHost) {
@@ -112,56 +133,58 @@ func SetHosts(pkts []*packet, src, dest *packetHost) {
 	}
 }
 
+// Fragment splits the data of the packet in fragments of up to MTU characters
 func Fragment(p *packet, mtu MTU) []*packet {
-	dataChunk, _ := fp.Chunk(
-		strings.Split(p.data, ""),
-		int(mtu),
-	)
-
-	fragments, _ := fp.Map(dataChunk, func(chunk []string, i int) *packet {
-		var off uint8 = p.off + (uint8(mtu) * uint8(i))
-		var mf uint8
-
-		pk := NewPacket(p.src, p.dst, p.typ, strings.Join(chunk, ""), p.ttl, mf, off)
-		return &pk
-	})
+	// byte index of the first character of each fragment, and the end of the data
+	bounds := make([]int, 0, len(p.data)/int(mtu)+2)
+	chars := 0
+	for i := range p.data {
+		if chars%int(mtu) == 0 {
+			bounds = append(bounds, i)
+		}
+		chars++
+	}
+	bounds = append(bounds, len(p.data))
+
+	count := len(bounds) - 1
+	pkts := make([]packet, count)
+	frags := make([]*packet, count)
+	for i := range frags {
+		off := p.off + uint8(mtu)*uint8(i)
+		// only the last fragment keeps the flag of the original packet
+		var mf uint8 = 1
+		if i == count-1 {
+			mf = p.mf
+		}
 
-	frags := fragments.([]*packet)
+		pkts[i] = NewPacket(p.src, p.dst, p.typ, p.data[bounds[i]:bounds[i+1]], p.ttl, mf, off)
+		frags[i] = &pkts[i]
+	}
 	return frags
 }
 
-type HashMap map[string]interface{}
-
-func reducePacket(acc HashMap, pkt *packet, i int) HashMap {
-	acc["src"] = pkt.src
-	acc["dst"] = pkt.dst
-	acc["type"] = pkt.typ
-	acc["ttl"] = pkt.ttl
-	return acc
-}
-
+// Defragment joins the data of the fragments on a packet with the hosts, type
+// and TTL of the last fragment
 func Defragment(pkts []*packet) *packet {
-	data := DefragmentData(pkts)
-
-	hash, _ := fp.Reduce(pkts, reducePacket, HashMap{})
-	hashMap := hash.(HashMap)
-
-	pkt := NewPacket(
-		hashMap["src"].(packetHost),
-		hashMap["dst"].(packetHost),
-		hashMap["type"].(packetType),
-		data,
-		hashMap["ttl"].(uint8),
-		0, 0,
-	)
+	last := pkts[len(pkts)-1]
+	pkt := NewPacket(last.src, last.dst, last.typ, DefragmentData(pkts), last.ttl, 0, 0)
 	return &pkt
 }
 
 func DefragmentData(pkts []*packet) string {
-	dataList, _ := fp.Map(pkts, func(p *packet) string {
-		return p.data
-	})
-	return strings.Join(dataList.([]string), "")
+	if len(pkts) == 1 {
+		return pkts[0].data
+	}
+	size := 0
+	for _, p := range pkts {
+		size += len(p.data)
+	}
+	var data strings.Builder
+	data.Grow(size)
+	for _, p := range pkts {
+		data.WriteString(p.data)
+	}
+	return data.String()
 }
 
 func IsTimeExceeded(pkts []*packet) bool {
@@ -187,6 +210,11 @@ func GetPktsSrc(pkts []*packet) *packetHost {
 	return &pkts[0].src
 }
 
+// srcInterface returns the interface of the host that sends the frames
+func srcInterface(h packetHost) netInterface {
+	return netInterface{ip: h.ip, mac: h.mac, vlan: h.vlan}
+}
+
 func GetPktsType(pkts []*packet) packetType {
 	if len(pkts) > 0 {
 		return pkts[0].typ
@@ -202,9 +230,9 @@ func GetPktsTTL(pkts []*packet) uint8 {
 }
 
 func DecrementPktsTTL(pkts []*packet) {
-	fp.ForEach(pkts, func(pkt *packet) {
+	for _, pkt := range pkts {
 		pkt.ttl--
-	})
+	}
 }
 
 /*
@@ -263,6 +291,7 @@ func NewNode(name, ip, gateway string, mac MAC, mtu MTU) *node {
 		gateway: IP{
 			ip:     gateway,
 			prefix: uint8(ipPref),
+			bits:   parseBits(gateway),
 		},
 		arpTable: arpTb,
 	}
@@ -287,14 +316,12 @@ func (n *node) ReceiveArpRequest(pkt packet) {
 }
 
 func (n *node) ReceiveIcmpRequest(pkt []*packet, env Environment) bool {
-	data := DefragmentData(pkt)
-	fmt.Printf("%v rbox %v : Received %v;\n", n.name, n.name, data)
+	logReceived(env, n.name, DefragmentData(pkt))
 	return true
 }
 
 func (n *node) ReceiveIcmpReply(pkt []*packet, env Environment) {
-	data := DefragmentData(pkt)
-	fmt.Printf("%v rbox %v : Received %v;\n", n.name, n.name, data)
+	logReceived(env, n.name, DefragmentData(pkt))
 }
 
 func (n *node) ReceiveTimeExceeded(pkt []*packet, env Environment) {}
@@ -309,7 +336,7 @@ func (n *node) SendArpReply(pkt packet) packet {
 }
 
 func (n *node) SendIcmpReply(pkt []*packet, mtu MTU, env Environment) []*packet {
-	nPkt := NewPacket(*GetPktsDest(pkt), *GetPktsSrc(pkt), ICMP_REP, DefragmentData(pkt), 8, 0, 0)
+	nPkt := NewPacket(*GetPktsDest(pkt), *GetPktsSrc(pkt), ICMP_REP, DefragmentData(pkt), ICMP_TTL, 0, 0)
 	return Fragment(&nPkt, mtu)
 }
 
@@ -322,18 +349,19 @@ func (n *node) SendMessage(msg string, dest NetComponent, destNetInterface netIn
 
 	if !isSameNet {
 		rt := env.GetDefaultGateway(n)
-		prt, _ := fp.Find(rt.ports, func(p routerPort) bool {
-			return p.ip == n.gateway
-		})
+		if rt == nil {
+			env.Fail(&NoGatewayError{Node: n.name, Gateway: n.gateway.ip})
+			return
+		}
+		prt, _ := rt.GetPortByIp(n.gateway)
 
 		dstNetPort = netInterface{
 			ip:  destNetInterface.ip,
-			mac: (prt.(routerPort)).mac,
-			mtu: (prt.(routerPort)).mtu,
+			mac: prt.mac,
+			mtu: prt.mtu,
 		}
 		dstName = rt.name
-		routerPortIp := (prt.(routerPort)).ip
-		arpTbIpSearch = routerPortIp
+		arpTbIpSearch = prt.ip
 	} else {
 		dstNetPort = destNetInterface
 		arpTbIpSearch = dstNetPort.ip
@@ -344,7 +372,11 @@ func (n *node) SendMessage(msg string, dest NetComponent, destNetInterface netIn
 
 	if !hasMac {
 		pkt := createBroadcastArpReq(n.name, n.netPort, arpTbIpSearch)
-		arpReply := env.SendArpReq(pkt)
+		arpReply, err := env.SendArpReq(pkt)
+		if err != nil {
+			env.Fail(err)
+			return
+		}
 		n.ReceiveArpRequest(arpReply)
 	}
 
@@ -363,11 +395,26 @@ type routerTableEntry struct {
 	netdest IP
 	nexthop IP
 	port    uint8
+	// Protocol that installed the route and its metric on the protocol
+	source routeSource
+	metric uint32
+}
+
+// NewRouterTableEntry creates a route to the network through the next hop, sent
+// by the port of the router
+func NewRouterTableEntry(netdest, nexthop string, port uint8) *routerTableEntry {
+	return &routerTableEntry{
+		netdest: *NewIp(netdest),
+		nexthop: *NewIp(nexthop),
+		port:    port,
+	}
 }
 
 type routerPort struct {
 	number uint8
 	netInterface
+	// The link of the port is down: it does not send nor receive frames
+	down bool
 }
 
 // NewRouterPort function creates a new port for a router
@@ -379,13 +426,15 @@ func NewRouterPort(number uint8, ip string, mac MAC, mtu MTU) *routerPort {
 		ip:  *netIp,
 	}
 	port := &routerPort{
-		number,
-		netInt,
+		number:       number,
+		netInterface: netInt,
 	}
 	return port
 }
 
 type Router interface {
+	AddPort(port routerPort)
+	AddRouterTableEntry(entry *routerTableEntry)
 	SendIcmpTimeExceeded(pkts []*packet, env Environment) []*packet
 }
 
@@ -398,6 +447,8 @@ type router struct {
 	routerTable []*routerTableEntry
 	// Arp Table
 	arpTable map[IP]MAC
+	// Index of the environment of the router, that receives the ports added to it
+	index *componentIndex
 }
 
 /*
@@ -423,26 +474,154 @@ func (r *router) GetName() string {
 	return r.name
 }
 
+// AddPort adds the port to the router, with the route to its network
 func (r *router) AddPort(port routerPort) {
 	r.ports = append(r.ports, port)
+	if r.index != nil {
+		r.index.addPort(r, port)
+	}
+
+	connected := make([]*routerTableEntry, len(r.ports))
+	for i, p := range r.ports {
+		connected[i] = &routerTableEntry{netdest: p.ip.Network(), nexthop: *NewIp("0.0.0.0"), port: p.number}
+	}
+	r.installRoutes(CONNECTED_ROUTE, connected)
 }
 
 func (r *router) AddRouterTableEntry(entry *routerTableEntry) {
 	r.routerTable = append(r.routerTable, entry)
 }
 
-func (r *router) GetPortByMac(mac MAC) routerPort {
-	rp, _ := fp.Find(r.ports, func(prt routerPort) bool {
-		return prt.mac == mac
-	})
-	return rp.(routerPort)
+func (r *router) GetPortByMac(mac MAC) (routerPort, bool) {
+	for _, prt := range r.ports {
+		if prt.mac == mac {
+			return prt, true
+		}
+	}
+	return routerPort{}, false
 }
 
-func (r *router) GetPortByIp(ip IP) routerPort {
-	rp, _ := fp.Find(r.ports, func(prt routerPort) bool {
-		return prt.ip == ip
-	})
-	return rp.(routerPort)
+func (r *router) GetPortByIp(ip IP) (routerPort, bool) {
+	for _, prt := range r.ports {
+		if prt.ip == ip {
+			return prt, true
+		}
+	}
+	return routerPort{}, false
+}
+
+func (r *router) GetPortByNumber(number uint8) (routerPort, bool) {
+	for _, prt := range r.ports {
+		if prt.number == number {
+			return prt, true
+		}
+	}
+	return routerPort{}, false
+}
+
+// checkStaticRoute describes the problems of a static route: a network that is
+// already connected to the router, or a next hop out of the network of the port
+func (r *router) checkStaticRoute(ent *routerTableEntry) []string {
+	msgs := make([]string, 0)
+	direct := ent.nexthop.ip == "0.0.0.0"
+	for _, p := range r.ports {
+		if p.ip.Network() != ent.netdest.Network() {
+			continue
+		}
+		if p.number == ent.port && direct {
+			msgs = append(msgs, fmt.Sprintf("The route to %v duplicates the connected route of port %v", ent.netdest.ToString(), p.number))
+		} else {
+			msgs = append(msgs, fmt.Sprintf("The route to %v contradicts the connected route of port %v", ent.netdest.ToString(), p.number))
+		}
+	}
+
+	port, _ := r.GetPortByNumber(ent.port)
+	if direct || port.ip.IsSameNet(ent.nexthop) {
+		return msgs
+	}
+	for _, p := range r.ports {
+		if p.ip.IsSameNet(ent.nexthop) {
+			return append(msgs, fmt.Sprintf("The next hop %v is on the network of port %v, not of port %v", ent.nexthop.ip, p.number, ent.port))
+		}
+	}
+	return append(msgs, fmt.Sprintf("The next hop %v is not on a network connected to router %v", ent.nexthop.ip, r.name))
+}
+
+// nextHop is where the router forwards the packets to a destination
+type nextHop struct {
+	// Port of the router that sends the packets
+	port netInterface
+	// Device that receives the packets and its interface
+	comp   NetComponent
+	netInt netInterface
+}
+
+// lookupRoute returns the route to the destination and the port it leaves by,
+// or nil when there is none. Among the routes whose port is up, the longest
+// prefix wins, and then the preference of the routes, as the table is ordered.
+func (r *router) lookupRoute(dest IP) (*routerTableEntry, routerPort) {
+	var best *routerTableEntry
+	var bestPort routerPort
+	for _, ent := range r.routerTable {
+		if !ent.netdest.IsSameNet(dest) || best != nil && ent.netdest.prefix <= best.netdest.prefix {
+			continue
+		}
+		if prt, hasPort := r.GetPortByNumber(ent.port); hasPort && !prt.down {
+			best, bestPort = ent, prt
+		}
+	}
+	return best, bestPort
+}
+
+// findNextHop looks up the route to the destination and resolves the MAC of the
+// next hop, sending an ARP request when it is not on the ARP table
+func (r *router) findNextHop(dest IP, env Environment) (nextHop, error) {
+	var hop nextHop
+
+	rtEntry, port := r.lookupRoute(dest)
+	if rtEntry == nil {
+		return hop, &NoRouteError{Router: r.name, Dest: dest.ip}
+	}
+	hop.port = port.netInterface
+
+	defaultIp := *NewIp("0.0.0.0/0")
+	target := rtEntry.nexthop
+	if rtEntry.nexthop == defaultIp {
+		target = dest
+		hop.comp = env.GetNetComponentByIp(dest)
+		if hop.comp != nil {
+			hop.netInt = env.GetComponentNetInterfaceByIp(hop.comp, dest)
+		}
+	} else {
+		hop.comp = env.GetNetComponentByIpOnly(rtEntry.nexthop)
+		if hop.comp != nil {
+			hop.netInt = env.GetComponentNetInterfaceByIpOnly(hop.comp, rtEntry.nexthop)
+		}
+	}
+	if hop.comp == nil {
+		return hop, &ArpError{Device: r.name, Ip: target.ip}
+	}
+
+	// verify if the destination is known by the router
+	_, hasMacArpTable := r.arpTable[hop.netInt.ip]
+	if !hasMacArpTable {
+		pkt := createBroadcastArpReq(r.name, hop.port, hop.netInt.ip)
+		arpReply, err := env.SendArpReq(pkt)
+		if err != nil {
+			return hop, err
+		}
+		r.ReceiveArpRequest(arpReply)
+	}
+	return hop, nil
+}
+
+// fragmentAll fragments every packet to fit on the MTU
+func fragmentAll(pkts []*packet, mtu MTU) []*packet {
+	frags := make([]*packet, 0, len(pkts))
+	for _, p := range pkts {
+		frags = append(frags, Fragment(p, mtu)...)
+	}
+	return frags
 }
 
 /*
@@ -453,71 +632,36 @@ Router send functions implementation
 
 func (r *router) SendArpReply(pkt packet) packet {
 	dstIp := pkt.dst.ip
-	dstPort := r.GetPortByIp(dstIp)
+	dstPort, _ := r.GetPortByIp(dstIp)
 	srcHost := packetHost{
 		name: r.name,
 		ip:   dstPort.ip,
 		mac:  dstPort.mac,
+		vlan: dstPort.vlan,
 	}
 	return NewPacket(srcHost, pkt.src, ARP_REP, "", 8, 0, 0)
 }
 
 func (r *router) SendIcmpReply(pkt []*packet, mtu MTU, env Environment) []*packet {
-	// verify if the router can reach the network
-	entry, _ := fp.Find(r.routerTable, func(ent *routerTableEntry) bool {
-		return ent.netdest.IsSameNet(GetPktsDest(pkt).ip)
-	})
-
-	rtEntry := entry.(*routerTableEntry)
-	defaultIp := *NewIp("0.0.0.0/0")
-
-	var destNetInterface netInterface
-	var routerNetPort netInterface
-	var destNetComp NetComponent
-
-	// retrieve the port that can reach the netork
-	port, _ := fp.Find(r.ports, func(p routerPort) bool {
-		return p.number == rtEntry.port
-	})
-	routerNetPort = port.(routerPort).netInterface
-
-	if rtEntry.nexthop == defaultIp {
-		destNetComp = env.GetNetComponentByIp(GetPktsDest(pkt).ip)
-		destNetInterface = env.GetComponentNetInterfaceByIp(destNetComp, GetPktsDest(pkt).ip)
-	} else {
-		destNetComp = env.GetNetComponentByIpOnly(rtEntry.nexthop)
-		destNetInterface = env.GetComponentNetInterfaceByIpOnly(destNetComp, rtEntry.nexthop)
-	}
-
-	// verify if the destination is known by the router
-	_, hasMacArpTable := r.arpTable[destNetInterface.ip]
-	if !hasMacArpTable {
-		pkt := createBroadcastArpReq(r.name, routerNetPort, destNetInterface.ip)
-		arpReply := env.SendArpReq(pkt)
-		r.ReceiveArpRequest(arpReply)
+	hop, err := r.findNextHop(GetPktsDest(pkt).ip, env)
+	if err != nil {
+		env.Fail(err)
+		return nil
 	}
 
 	srcHost := &packetHost{
 		name: r.name,
 		ip:   GetPktsSrc(pkt).ip,
-		mac:  routerNetPort.mac,
+		mac:  hop.port.mac,
+		vlan: hop.port.vlan,
 	}
 	dstHost := &packetHost{
 		ip:   GetPktsDest(pkt).ip,
-		mac:  destNetInterface.mac,
-		name: destNetComp.GetName(),
-	}
-
-	list, _ := fp.Map(pkt, func(pkt *packet) []*packet {
-		return Fragment(pkt, destNetInterface.mtu)
-	})
-	pktList := list.([][]*packet)
-
-	var pkts []*packet = make([]*packet, 0)
-	for _, p := range pktList {
-		pkts = append(pkts, p...)
+		mac:  hop.netInt.mac,
+		name: hop.comp.GetName(),
 	}
 
+	pkts := fragmentAll(pkt, hop.netInt.mtu)
 	SetHosts(pkts, srcHost, dstHost)
 	return pkts
 }
@@ -527,52 +671,25 @@ func (r *router) SendIcmpTimeExceeded(pkt []*packet, env Environment) []*packet
 		return pkt
 	}
 
-	// verify if the router can reach the network
-	entry, _ := fp.Find(r.routerTable, func(ent *routerTableEntry) bool {
-		return ent.netdest.IsSameNet(GetPktsSrc(pkt).ip)
-	})
-
-	rtEntry := entry.(*routerTableEntry)
-	defaultIp := *NewIp("0.0.0.0/0")
-
-	var destNetInterface netInterface
-	var routerNetPort netInterface
-	var destNetComp NetComponent
-
-	// retrieve the port that can reach the netork
-	port, _ := fp.Find(r.ports, func(p routerPort) bool {
-		return p.number == rtEntry.port
-	})
-	routerNetPort = port.(routerPort).netInterface
-
-	if rtEntry.nexthop == defaultIp {
-		destNetComp = env.GetNetComponentByIp(GetPktsSrc(pkt).ip)
-		destNetInterface = env.GetComponentNetInterfaceByIp(destNetComp, GetPktsSrc(pkt).ip)
-	} else {
-		destNetComp = env.GetNetComponentByIpOnly(rtEntry.nexthop)
-		destNetInterface = env.GetComponentNetInterfaceByIpOnly(destNetComp, rtEntry.nexthop)
-	}
-
-	// verify if the destination is known by the router
-	_, hasMacArpTable := r.arpTable[destNetInterface.ip]
-	if !hasMacArpTable {
-		pkt := createBroadcastArpReq(r.name, routerNetPort, destNetInterface.ip)
-		arpReply := env.SendArpReq(pkt)
-		r.ReceiveArpRequest(arpReply)
+	hop, err := r.findNextHop(GetPktsSrc(pkt).ip, env)
+	if err != nil {
+		env.Fail(err)
+		return nil
 	}
 
 	srcHost := packetHost{
 		name: r.name,
-		ip:   routerNetPort.ip,
-		mac:  routerNetPort.mac,
+		ip:   hop.port.ip,
+		mac:  hop.port.mac,
+		vlan: hop.port.vlan,
 	}
 	dstHost := packetHost{
-		name: destNetComp.GetName(),
+		name: hop.comp.GetName(),
 		ip:   GetPktsSrc(pkt).ip,
-		mac:  destNetInterface.mac,
+		mac:  hop.netInt.mac,
 	}
-	timePkt := NewPacket(srcHost, dstHost, ICMP_TIME_EXCEEDED, DefragmentData(pkt), 8, 0, 0)
-	return Fragment(&timePkt, destNetInterface.mtu)
+	timePkt := NewPacket(srcHost, dstHost, ICMP_TIME_EXCEEDED, DefragmentData(pkt), ICMP_TTL, 0, 0)
+	return Fragment(&timePkt, hop.netInt.mtu)
 }
 
 /*
@@ -590,59 +707,32 @@ func (r *router) ReceiveArpRequest(pkt packet) {
 }
 
 func (r *router) ReceiveIcmpRequest(pkt []*packet, env Environment) bool {
-	// verify if the router can reach the network
-	entry, _ := fp.Find(r.routerTable, func(ent *routerTableEntry) bool {
-		return ent.netdest.IsSameNet(GetPktsDest(pkt).ip)
-	})
-
-	rtEntry := entry.(*routerTableEntry)
-	defaultIp := *NewIp("0.0.0.0/0")
-
-	var destNetInterface netInterface
-	var routerNetPort netInterface
-	var destNetComp NetComponent
-
-	// retrieve the port that can reach the netork
-	port, _ := fp.Find(r.ports, func(p routerPort) bool {
-		return p.number == rtEntry.port
-	})
-	routerNetPort = port.(routerPort).netInterface
-
-	if rtEntry.nexthop == defaultIp {
-		destNetComp = env.GetNetComponentByIp(GetPktsDest(pkt).ip)
-		destNetInterface = env.GetComponentNetInterfaceByIp(destNetComp, GetPktsDest(pkt).ip)
-	} else {
-		destNetComp = env.GetNetComponentByIpOnly(rtEntry.nexthop)
-		destNetInterface = env.GetComponentNetInterfaceByIpOnly(destNetComp, rtEntry.nexthop)
-	}
-
-	// verify if the destination is known by the router
-	_, hasMacArpTable := r.arpTable[destNetInterface.ip]
-	if !hasMacArpTable {
-		pkt := createBroadcastArpReq(r.name, routerNetPort, destNetInterface.ip)
-		arpReply := env.SendArpReq(pkt)
-		r.ReceiveArpRequest(arpReply)
+	hop, err := r.findNextHop(GetPktsDest(pkt).ip, env)
+	if err != nil {
+		env.Fail(err)
+		return false
 	}
 
 	srcNetInterface := netInterface{
 		ip:  GetPktsSrc(pkt).ip,
-		mac: routerNetPort.mac,
-		mtu: routerNetPort.mtu,
+		mac: hop.port.mac,
+		mtu: hop.port.mtu,
 	}
-	destNetInterface = netInterface{
+	destNetInterface := netInterface{
 		ip:  GetPktsDest(pkt).ip,
-		mac: destNetInterface.mac,
-		mtu: destNetInterface.mtu,
+		mac: hop.netInt.mac,
+		mtu: hop.netInt.mtu,
 	}
 	srcHost := &packetHost{
 		name: r.name,
 		ip:   GetPktsSrc(pkt).ip,
-		mac:  routerNetPort.mac,
+		mac:  hop.port.mac,
+		vlan: hop.port.vlan,
 	}
 	dstHost := &packetHost{
-		name: destNetComp.GetName(),
+		name: hop.comp.GetName(),
 		ip:   GetPktsDest(pkt).ip,
-		mac:  destNetInterface.mac,
+		mac:  hop.netInt.mac,
 	}
 
 	DecrementPktsTTL(pkt)
@@ -652,120 +742,50 @@ func (r *router) ReceiveIcmpRequest(pkt []*packet, env Environment) bool {
 }
 
 func (r *router) ReceiveIcmpReply(pkts []*packet, env Environment) {
-	// verify if the router can reach the network
-	entry, _ := fp.Find(r.routerTable, func(ent *routerTableEntry) bool {
-		return ent.netdest.IsSameNet(GetPktsDest(pkts).ip)
-	})
-
-	rtEntry := entry.(*routerTableEntry)
-	defaultIp := *NewIp("0.0.0.0/0")
-
-	var destNetInterface netInterface
-	var routerNetPort netInterface
-	var destNetComp NetComponent
-
-	// retrieve the port that can reach the netork
-	port, _ := fp.Find(r.ports, func(p routerPort) bool {
-		return p.number == rtEntry.port
-	})
-	routerNetPort = port.(routerPort).netInterface
-
-	if rtEntry.nexthop == defaultIp {
-		destNetComp = env.GetNetComponentByIp(GetPktsDest(pkts).ip)
-		destNetInterface = env.GetComponentNetInterfaceByIp(destNetComp, GetPktsDest(pkts).ip)
-	} else {
-		destNetComp = env.GetNetComponentByIpOnly(rtEntry.nexthop)
-		destNetInterface = env.GetComponentNetInterfaceByIpOnly(destNetComp, rtEntry.nexthop)
-	}
-
-	// verify if the destination is known by the router
-	_, hasMacArpTable := r.arpTable[destNetInterface.ip]
-	if !hasMacArpTable {
-		pkt := createBroadcastArpReq(r.name, routerNetPort, destNetInterface.ip)
-		arpReply := env.SendArpReq(pkt)
-		r.ReceiveArpRequest(arpReply)
+	hop, err := r.findNextHop(GetPktsDest(pkts).ip, env)
+	if err != nil {
+		env.Fail(err)
+		return
 	}
 
 	srcHost := &packetHost{
 		name: r.name,
 		ip:   GetPktsSrc(pkts).ip,
-		mac:  routerNetPort.mac,
+		mac:  hop.port.mac,
+		vlan: hop.port.vlan,
 	}
 	destHost := &packetHost{
-		name: destNetComp.GetName(),
+		name: hop.comp.GetName(),
 		ip:   GetPktsDest(pkts).ip,
-		mac:  destNetInterface.mac,
+		mac:  hop.netInt.mac,
 	}
 
-	list, _ := fp.Map(pkts, func(pkt *packet) []*packet {
-		frag := Fragment(pkt, destNetInterface.mtu)
-		return frag
-	})
-	pktList := list.([][]*packet)
-
-	var pktsToGo []*packet = make([]*packet, 0)
-	for _, p := range pktList {
-		pktsToGo = append(pktsToGo, p...)
-	}
+	pktsToGo := fragmentAll(pkts, hop.netInt.mtu)
 	SetHosts(pktsToGo, srcHost, destHost)
 	DecrementPktsTTL(pktsToGo)
-	env.SendIcmpReply(r, destNetComp, pktsToGo)
+	env.SendIcmpReply(r, hop.comp, pktsToGo)
 }
 
 func (r *router) ReceiveTimeExceeded(pkt []*packet, env Environment) {
-	// verify if the router can reach the network
-	entry, _ := fp.Find(r.routerTable, func(ent *routerTableEntry) bool {
-		return ent.netdest.IsSameNet(GetPktsDest(pkt).ip)
-	})
-
-	rtEntry := entry.(*routerTableEntry)
-	defaultIp := *NewIp("0.0.0.0/0")
-
-	var destNetInterface netInterface
-	var routerNetPort netInterface
-	var destNetComp NetComponent
-
-	// retrieve the port that can reach the netork
-	port, _ := fp.Find(r.ports, func(p routerPort) bool {
-		return p.number == rtEntry.port
-	})
-	routerNetPort = port.(routerPort).netInterface
-
-	if rtEntry.nexthop == defaultIp {
-		destNetComp = env.GetNetComponentByIp(GetPktsDest(pkt).ip)
-		destNetInterface = env.GetComponentNetInterfaceByIp(destNetComp, GetPktsDest(pkt).ip)
-	} else {
-		destNetComp = env.GetNetComponentByIpOnly(rtEntry.nexthop)
-		destNetInterface = env.GetComponentNetInterfaceByIpOnly(destNetComp, rtEntry.nexthop)
-	}
-
-	// verify if the destination is known by the router
-	_, hasMacArpTable := r.arpTable[destNetInterface.ip]
-	if !hasMacArpTable {
-		pkt := createBroadcastArpReq(r.name, routerNetPort, destNetInterface.ip)
-		arpReply := env.SendArpReq(pkt)
-		r.ReceiveArpRequest(arpReply)
+	hop, err := r.findNextHop(GetPktsDest(pkt).ip, env)
+	if err != nil {
+		env.Fail(err)
+		return
 	}
 
 	srcHost := &packetHost{
 		name: r.name,
 		ip:   GetPktsSrc(pkt).ip,
-		mac:  routerNetPort.mac,
+		mac:  hop.port.mac,
+		vlan: hop.port.vlan,
 	}
 	destHost := &packetHost{
-		name: destNetComp.GetName(),
+		name: hop.comp.GetName(),
 		ip:   GetPktsDest(pkt).ip,
-		mac:  destNetInterface.mac,
+		mac:  hop.netInt.mac,
 	}
-	list, _ := fp.Map(pkt, func(pkt *packet) []*packet {
-		return Fragment(pkt, destNetInterface.mtu)
-	})
-	pktList := list.([][]*packet)
 
-	var pkts []*packet = make([]*packet, 0)
-	for _, p := range pktList {
-		pkts = append(pkts, p...)
-	}
+	pkts := fragmentAll(pkt, hop.netInt.mtu)
 	DecrementPktsTTL(pkts)
 	SetHosts(pkts, srcHost, destHost)
 	env.SendIcmpTimeExceeded(r, pkts)
@@ -780,24 +800,126 @@ Environment implementations
 type Environment interface {
 	AddNode(nd *node)
 	AddRouter(r *router)
+	AddSwitch(name string, ports int, aging int) error
+	AttachSwitchPort(switchName string, port int, device string) error
 	GetDefaultGateway(n *node) *router
 	GetNetComponentByName(name string) NetComponent
 	GetNetComponentByIp(ip IP) NetComponent
 	GetNetComponentByIpOnly(ip IP) NetComponent
 	GetComponentNetInterfaceByIp(comp NetComponent, ip IP) netInterface
 	GetComponentNetInterfaceByIpOnly(comp NetComponent, ip IP) netInterface
-	ParseLines(lines []string)
+	ParseLines(lines []string) error
+	GetNames() []string
+	WriteDot(w io.Writer, events []event.Event) error
+	WriteRoutes(w io.Writer, routerName string) error
+	Warnings() []string
+	AnalyzeForwarding() Analysis
+	Clone() Environment
+	ResolvedLines() []string
+	WriteTopology(w io.Writer) error
+
+	EnableRip(routerName string, ports []uint8) error
+	EnableOspf(routerName string, costs map[uint8]uint32) error
+	WriteOspf(w io.Writer, routerName string) error
+	EnableBgp(routerName string, asn uint32, networks []string) error
+	AddBgpNeighbor(routerName, peerIp string, policy BgpPolicy) error
+	WriteBgp(w io.Writer, routerName string) error
+	SetLinkDown(routerName string, port uint8, at int) error
+	ConvergeRouting(show bool)
+
+	SetSink(sink event.Sink)
+	SetContext(ctx context.Context)
+	Emit(ev event.Event)
+	Fail(err error)
 
 	SendMessage(msg string, ipSrc, ipDest IP) error
-	SendArpReq(pkt packet) packet
+	SendArpReq(pkt packet) (packet, error)
 	SendIcmpReq(srcComp NetComponent, srcNetPort, dstNetPort netInterface, pkts []*packet)
 	SendIcmpReply(src, dest NetComponent, pkts []*packet)
 	SendIcmpTimeExceeded(src NetComponent, pkts []*packet)
 }
 
+/*
+----------------------------------------------------
+Indexes of the devices
+----------------------------------------------------
+*/
+
+// componentIndex finds the devices by name, MAC and IP without scanning them.
+// When two devices have the same key, the router is kept over the node.
+type componentIndex struct {
+	// Names on lower case, as they are compared without case
+	names map[string]NetComponent
+	macs  map[MAC]NetComponent
+	// Address and prefix of the interface
+	ips map[IP]NetComponent
+	// Address of the router ports, whatever their prefix
+	addrs map[uint32]*router
+	// Switches by name, on lower case, and the switch port of the interfaces
+	// connected to them, by MAC
+	switches map[string]*netSwitch
+	attached map[MAC]switchEnd
+}
+
+func newComponentIndex() *componentIndex {
+	return &componentIndex{
+		names:    make(map[string]NetComponent),
+		macs:     make(map[MAC]NetComponent),
+		ips:      make(map[IP]NetComponent),
+		addrs:    make(map[uint32]*router),
+		switches: make(map[string]*netSwitch),
+		attached: make(map[MAC]switchEnd),
+	}
+}
+
+func (idx *componentIndex) addNode(n *node) {
+	if _, isRouter := idx.names[strings.ToLower(n.name)].(*router); !isRouter {
+		idx.names[strings.ToLower(n.name)] = n
+	}
+	if _, isRouter := idx.macs[n.netPort.mac].(*router); !isRouter {
+		idx.macs[n.netPort.mac] = n
+	}
+	if _, isRouter := idx.ips[n.netPort.ip].(*router); !isRouter {
+		idx.ips[n.netPort.ip] = n
+	}
+}
+
+func (idx *componentIndex) addPort(r *router, p routerPort) {
+	idx.macs[p.mac] = r
+	idx.ips[p.ip] = r
+	idx.addrs[p.ip.bits] = r
+}
+
+func (idx *componentIndex) addRouter(r *router) {
+	idx.names[strings.ToLower(r.name)] = r
+	for _, p := range r.ports {
+		idx.addPort(r, p)
+	}
+	r.index = idx
+}
+
 type environment struct {
-	nodes   []*node
-	routers []*router
+	nodes    []*node
+	routers  []*router
+	switches []*netSwitch
+	// Seconds since the first message, that age the MAC tables of the switches
+	clock int
+	// Devices by name, MAC and IP
+	index *componentIndex
+	// Receives the events of the simulation
+	sink event.Sink
+	// Stops the simulation when it is done
+	ctx context.Context
+	// First failure of the simulation, that stops it
+	err error
+	// Routing protocols and the link failures they react to
+	routing routing
+	// Suspicious lines of the topology, that do not stop the simulation
+	warnings []*TopologyError
+	// Lines of the topology, with the files it includes, and the values of their
+	// placeholders, by line and field
+	lines    []topologyLine
+	resolved map[int]map[int]string
 }
 
 func NewEnvironment() Environment {
@@ -806,140 +928,146 @@ func NewEnvironment() Environment {
 	return &environment{
 		nodes:   nodeList,
 		routers: routerList,
+		index:   newComponentIndex(),
+		sink:    event.Writer{W: os.Stdout},
+		ctx:     context.Background(),
 	}
 }
 
-func (e *environment) AddNode(nd *node) {
-	e.nodes = append(e.nodes, nd)
+func (e *environment) SetSink(sink event.Sink) {
+	e.sink = sink
 }
 
-func (e *environment) AddRouter(rt *router) {
-	e.routers = append(e.routers, rt)
-}
-
-func (e *environment) GetNodeByName(name string) *node {
-	nd, _ := fp.Find(e.nodes, func(n *node) bool {
-		return n.name == name
-	})
-
-	return nd.(*node)
+// SetContext sets the context of the next simulations. Once it is done, no
+// more frames are sent.
+func (e *environment) SetContext(ctx context.Context) {
+	e.ctx = ctx
 }
 
-func (e *environment) GetNodeByIp(ip IP) *node {
-	nd, _ := fp.Find(e.nodes, func(n *node) bool {
-		return n.netPort.ip.ip == ip.ip
-	})
-	return nd.(*node)
-}
-
-func (e *environment) GetNodeByMac(mac MAC) *node {
-	nd, _ := fp.Find(e.nodes, func(n *node) bool {
-		return n.netPort.mac == mac
-	})
-	return nd.(*node)
+// Emit sends the event to the sink. The frames of the pings are followed by
+// their hops through the switches.
+func (e *environment) Emit(ev event.Event) {
+	e.sink.Emit(ev)
+	if isFrame(ev.Kind) {
+		e.switchFrame(ev)
+	}
 }
 
-func (e *environment) GetRouterByName(name string) *router {
-	for _, r := range e.routers {
-		if r.name == name {
-			return r
-		}
+// Fail stops the simulation, that returns the error. Only the first failure is kept.
+func (e *environment) Fail(err error) {
+	if e.err == nil {
+		e.err = err
 	}
-	return nil
 }
 
-func (e *environment) GetNetComponentByMac(mac MAC) NetComponent {
-	var component NetComponent
+// stopped tells if no more frames must be sent
+func (e *environment) stopped() bool {
+	return e.err != nil || e.ctx.Err() != nil
+}
 
+// GetNames returns the name of every node, router and switch, in the order they
+// were declared
+func (e *environment) GetNames() []string {
+	names := make([]string, 0, len(e.nodes)+len(e.routers)+len(e.switches))
 	for _, n := range e.nodes {
-		if n.netPort.mac == mac {
-			component = n
-			break
-		}
+		names = append(names, n.name)
 	}
-
 	for _, r := range e.routers {
-		for _, rp := range r.ports {
-			if rp.mac == mac {
-				component = r
-				break
-			}
-		}
+		names = append(names, r.name)
+	}
+	for _, s := range e.switches {
+		names = append(names, s.name)
 	}
+	return names
+}
 
-	return component
+// AddNode adds the node to the environment and to its indexes
+func (e *environment) AddNode(nd *node) {
+	e.nodes = append(e.nodes, nd)
+	e.index.addNode(nd)
 }
 
-func (e *environment) GetNetComponentByIp(ip IP) NetComponent {
-	var component NetComponent
+// AddRouter adds the router to the environment and to its indexes. The ports
+// added to the router later are indexed as well.
+func (e *environment) AddRouter(rt *router) {
+	e.routers = append(e.routers, rt)
+	e.index.addRouter(rt)
+}
 
-	for _, n := range e.nodes {
-		if n.netPort.ip == ip {
-			component = n
-			break
-		}
-	}
+func (e *environment) GetRouterByName(name string) *router {
+	rt, _ := e.index.names[strings.ToLower(name)].(*router)
+	return rt
+}
 
-	for _, r := range e.routers {
-		for _, rp := range r.ports {
-			if rp.ip == ip {
-				component = r
-				break
-			}
-		}
-	}
+func (e *environment) GetNetComponentByMac(mac MAC) NetComponent {
+	return e.index.macs[mac]
+}
 
-	return component
+func (e *environment) GetNetComponentByIp(ip IP) NetComponent {
+	return e.index.ips[ip]
 }
 
+// GetNetComponentByIpOnly returns the router with a port on the address,
+// whatever the prefix of the port
 func (e *environment) GetNetComponentByIpOnly(ip IP) NetComponent {
-	var component NetComponent
-	for _, r := range e.routers {
-		for _, rp := range r.ports {
-			if rp.ip.ip == ip.ip {
-				component = r
-				break
-			}
-		}
+	if rt, found := e.index.addrs[ip.bits]; found {
+		return rt
 	}
-	return component
+	return nil
 }
 
 func (e *environment) GetNetComponentByName(name string) NetComponent {
-	var comp NetComponent
+	return e.index.names[strings.ToLower(name)]
+}
 
-	for _, n := range e.nodes {
-		if n.name == name {
-			comp = n
+func (e *environment) SendArpReq(pkt packet) (packet, error) {
+	logArpRequest(e, pkt)
+	dst := e.GetNetComponentByIp(pkt.dst.ip)
+	if rt, isRouter := dst.(*router); isRouter {
+		// a port with the link down does not answer
+		if port, _ := rt.GetPortByIp(pkt.dst.ip); port.down {
+			dst = nil
 		}
 	}
-
-	for _, r := range e.routers {
-		if r.name == name {
-			comp = r
-		}
+	// nor an interface that the request does not reach
+	if dst != nil && !e.sameSegment(srcInterface(pkt.src), e.GetComponentNetInterfaceByIp(dst, pkt.dst.ip)) {
+		dst = nil
+	}
+	if dst == nil {
+		return packet{}, &ArpError{Device: pkt.src.name, Ip: pkt.dst.ip.ip}
 	}
-
-	return comp
-}
-
-func (e *environment) SendArpReq(pkt packet) packet {
-	logArpRequest(pkt)
-	dst := e.GetNetComponentByIp(pkt.dst.ip)
 	dst.ReceiveArpRequest(pkt)
 	arpReply := dst.SendArpReply(pkt)
-	logArpReply(arpReply)
-	return arpReply
+	logArpReply(e, arpReply)
+	return arpReply, nil
+}
+
+// receiver returns the device with the destination MAC of the packets
+func (e *environment) receiver(src NetComponent, pkts []*packet) NetComponent {
+	dst := e.GetNetComponentByMac(GetPktsDest(pkts).mac)
+	if dst != nil && !e.reaches(srcInterface(*GetPktsSrc(pkts)), dst, GetPktsDest(pkts).mac) {
+		dst = nil
+	}
+	if dst == nil {
+		e.Fail(&ArpError{Device: src.GetName(), Ip: GetPktsDest(pkts).ip.ip})
+	}
+	return dst
 }
 
 func (e *environment) SendIcmpReq(src NetComponent, srcNetPort, dstNetPort netInterface, pkts []*packet) {
+	if e.stopped() {
+		return
+	}
 	if IsTimeExceeded(pkts) {
 		e.SendIcmpTimeExceeded(src, pkts)
 		return
 	}
-	logIcmpRequest(pkts)
+	logIcmpRequest(e, pkts)
 
-	dst := e.GetNetComponentByMac(GetPktsDest(pkts).mac)
+	dst := e.receiver(src, pkts)
+	if dst == nil {
+		return
+	}
 
 	doReply := dst.ReceiveIcmpRequest(pkts, e)
 	if doReply {
@@ -948,6 +1076,9 @@ func (e *environment) SendIcmpReq(src NetComponent, srcNetPort, dstNetPort netIn
 }
 
 func (e *environment) SendIcmpReply(src, dest NetComponent, pkts []*packet) {
+	if e.stopped() {
+		return
+	}
 	var mtu MTU
 
 	switch src.(type) {
@@ -958,33 +1089,48 @@ func (e *environment) SendIcmpReply(src, dest NetComponent, pkts []*packet) {
 	}
 
 	replyPkts := src.SendIcmpReply(pkts, mtu, e)
+	if e.stopped() {
+		return
+	}
 
 	if IsTimeExceeded(replyPkts) {
 		e.SendIcmpTimeExceeded(src, pkts)
 		return
 	}
-	logIcmpReply(replyPkts)
+	logIcmpReply(e, replyPkts)
 
-	destination := e.GetNetComponentByMac(GetPktsDest(replyPkts).mac)
+	destination := e.receiver(src, replyPkts)
+	if destination == nil {
+		return
+	}
 	destination.ReceiveIcmpReply(replyPkts, e)
 }
 
 func (e *environment) SendIcmpTimeExceeded(src NetComponent, pkt []*packet) {
-	timePkt := src.(Router).SendIcmpTimeExceeded(pkt, e)
-	logIcmpTimeExceeded(timePkt)
-	destination := e.GetNetComponentByMac(GetPktsDest(timePkt).mac)
+	if e.stopped() {
+		return
+	}
+	// only routers discard packets
+	rt, isRouter := src.(Router)
+	if !isRouter {
+		return
+	}
+	timePkt := rt.SendIcmpTimeExceeded(pkt, e)
+	if e.stopped() {
+		return
+	}
+	logIcmpTimeExceeded(e, timePkt)
+
+	destination := e.receiver(src, timePkt)
+	if destination == nil {
+		return
+	}
 	destination.ReceiveTimeExceeded(timePkt, e)
 }
 
 func (e *environment) GetDefaultGateway(n *node) *router {
-	for _, rt := range e.routers {
-		for _, p := range rt.ports {
-			if p.ip == n.gateway {
-				return rt
-			}
-		}
-	}
-	return nil
+	rt, _ := e.index.ips[n.gateway].(*router)
+	return rt
 }
 
 func (e *environment) GetComponentNetInterfaceByIp(comp NetComponent, ip IP) netInterface {
@@ -1016,18 +1162,27 @@ func (e *environment) GetComponentNetInterfaceByIpOnly(comp NetComponent, ip IP)
 }
 
 func (e *environment) SendMessage(msg string, ipSrc, ipDest IP) error {
-	src := e.GetNetComponentByIp(ipSrc)
+	srcNode, isNode := e.GetNetComponentByIp(ipSrc).(Node)
+	if !isNode {
+		return &UnknownNodeError{Name: ipSrc.ToString(), Role: "source"}
+	}
 	dst := e.GetNetComponentByIp(ipDest)
-	if src == nil || dst == nil {
-		return fmt.Errorf(
-			"Invalid source (%v) or destination (%v) to send message",
-			src, dst,
-		)
+	if dst == nil {
+		return &UnknownNodeError{Name: ipDest.ToString(), Role: "destination"}
 	}
 
+	e.err = nil
+	if err := e.ctx.Err(); err != nil {
+		return err
+	}
+	e.clock++
+
 	destNetInterface := e.GetComponentNetInterfaceByIp(dst, ipDest)
-	src.(Node).SendMessage(msg, dst, destNetInterface, e)
-	return nil
+	srcNode.SendMessage(msg, dst, destNetInterface, e)
+	if e.err != nil {
+		return e.err
+	}
+	return e.ctx.Err()
 }
 
 /*
@@ -1036,81 +1191,398 @@ Parse the file to create the environment
 ----------------------------------------------------
 */
 
-func findLabelIndex(lb string, list []string) (int, error) {
-	return fp.FindIndex(list, func(val string) bool {
-		return val == lb
-	})
+var macRe = regexp.MustCompile(`^[0-9A-Fa-f]{2}(:[0-9A-Fa-f]{2}){5}$`)
+
+func parseMac(value string) (MAC, error) {
+	if !macRe.MatchString(value) {
+		return "", fmt.Errorf("Invalid MAC address %v", value)
+	}
+	return MAC(strings.ToUpper(value)), nil
 }
 
-func parseNode(line string) *node {
-	l := strings.Split(line, ",")
+// parseIpPrefix validates an IPv4 address with its prefix, as 10.0.0.1/24
+func parseIpPrefix(value string) error {
+	addr, _, err := net.ParseCIDR(value)
+	if err != nil || addr.To4() == nil {
+		return fmt.Errorf("Invalid IP address %v, expected an IPv4 address with prefix", value)
+	}
+	return nil
+}
 
-	mtu, _ := strconv.ParseUint(l[3], 10, 8)
-	mac := MAC(l[1])
-	return NewNode(l[0], l[2], l[4], mac, MTU(mtu))
+func parseIpAddress(value string) error {
+	addr := net.ParseIP(value)
+	if addr == nil || addr.To4() == nil {
+		return fmt.Errorf("Invalid IP address %v", value)
+	}
+	return nil
 }
 
-func parseRouter(line string) *router {
+func parseMtu(value string) (MTU, error) {
+	mtu, err := strconv.ParseUint(value, 10, 8)
+	if err != nil || mtu == 0 {
+		return 0, fmt.Errorf("Invalid MTU %v, expected a value between 1 and 255", value)
+	}
+	return MTU(mtu), nil
+}
+
+func splitFields(line string) []string {
 	l := strings.Split(line, ",")
-	numPorts, _ := strconv.ParseUint(l[1], 10, 8)
+	for i := range l {
+		l[i] = strings.TrimSpace(l[i])
+	}
+	return l
+}
+
+// parseNode reads the node on the line i, with its placeholders on the plan
+func parseNode(line string, i int, plan *addressPlan) (*node, error) {
+	l := splitFields(line)
+	if len(l) != 5 {
+		return nil, fmt.Errorf("Expected 5 fields on the node, found %v", len(l))
+	}
+
+	var nd *node
+	owner := "node " + l[0]
+	mac, err := plan.parseMacField(l[1], placeholder{line: i, field: 1, owner: owner}, func(mac MAC) {
+		nd.netPort.mac = mac
+	})
+	if err != nil {
+		return nil, err
+	}
+	ip, err := plan.parseIpField(l[2], placeholder{line: i, field: 2, owner: owner}, false, func(ip IP) {
+		nd.netPort.ip = ip
+	})
+	if err != nil {
+		return nil, err
+	}
+	mtu, err := parseMtu(l[3])
+	if err != nil {
+		return nil, err
+	}
+	gateway := l[4]
+	if isAuto(gateway) {
+		gateway = "0.0.0.0"
+	} else if err := parseIpAddress(gateway); err != nil {
+		return nil, err
+	}
+
+	nd = NewNode(l[0], ip, gateway, mac, mtu)
+	if isAuto(l[4]) {
+		plan.gateways = append(plan.gateways, autoGateway{placeholder: placeholder{line: i, field: 4, owner: owner}, node: nd})
+	}
+	return nd, nil
+}
+
+// parseRouter reads the router on the line i, with its placeholders on the plan
+func parseRouter(line string, i int, plan *addressPlan) (*router, error) {
+	l := splitFields(line)
+	if len(l) < 2 {
+		return nil, fmt.Errorf("Expected the name and number of ports of the router")
+	}
+	numPorts, err := strconv.ParseUint(l[1], 10, 8)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid number of ports %v", l[1])
+	}
+	if len(l) != 2+int(numPorts)*3 {
+		return nil, fmt.Errorf("Expected %v fields for %v ports, found %v", 2+numPorts*3, numPorts, len(l))
+	}
 
 	rt := NewRouter(l[0])
 
-	portLine := l[2:]
-	for i := 0; i < int(numPorts)*3; i += 3 {
-		mtu, _ := strconv.ParseUint(portLine[i+2], 10, 8)
-		rt.AddPort(
-			*NewRouterPort(
-				uint8(i/3), portLine[i+1], MAC(portLine[i]), MTU(mtu),
-			),
-		)
+	for n := 0; n < int(numPorts); n++ {
+		field := 2 + n*3
+		port := n
+		owner := fmt.Sprintf("router %v port %v", l[0], n)
+		macField, vlan, err := parseSubInterface(l[field])
+		if err != nil {
+			return nil, err
+		}
+		if vlan != 0 && isAuto(macField) {
+			return nil, fmt.Errorf("The sub-interfaces share the MAC of their link, which can not be %v", AUTO)
+		}
+		mac, err := plan.parseMacField(macField, placeholder{line: i, field: field, owner: owner}, func(mac MAC) {
+			rt.ports[port].mac = mac
+		})
+		if err != nil {
+			return nil, err
+		}
+		ip, err := plan.parseIpField(l[field+1], placeholder{line: i, field: field + 1, owner: owner}, true, func(ip IP) {
+			rt.ports[port].ip = ip
+		})
+		if err != nil {
+			return nil, err
+		}
+		mtu, err := parseMtu(l[field+2])
+		if err != nil {
+			return nil, err
+		}
+		rtPort := NewRouterPort(uint8(n), ip, mac, mtu)
+		rtPort.vlan = vlan
+		rt.AddPort(*rtPort)
 	}
 
-	return rt
+	return rt, nil
 }
 
-func parseRouterTableEntry(line string) (string, *routerTableEntry) {
-	l := strings.Split(line, ",")
-	routerName := l[0]
+func parseRouterTableEntry(line string) (string, *routerTableEntry, error) {
+	l := splitFields(line)
+	if len(l) != 4 {
+		return "", nil, fmt.Errorf("Expected 4 fields on the route, found %v", len(l))
+	}
+
+	if err := parseIpPrefix(l[1]); err != nil {
+		return "", nil, err
+	}
+	if err := parseIpAddress(l[2]); err != nil {
+		return "", nil, err
+	}
+	port, err := strconv.ParseUint(l[3], 10, 8)
+	if err != nil {
+		return "", nil, fmt.Errorf("Invalid port %v", l[3])
+	}
 
-	netDest := *NewIp(l[1])
-	nexthop := *NewIp(l[2])
-	port, _ := strconv.ParseUint(l[3], 10, 8)
+	return l[0], NewRouterTableEntry(l[1], l[2], uint8(port)), nil
+}
 
-	return routerName, &routerTableEntry{
-		netdest: netDest,
-		nexthop: nexthop,
-		port:    uint8(port),
+// parseRipRouter reads the router running RIP and its ports, all of them when none are listed
+func parseRipRouter(line string) (string, []uint8, error) {
+	l := splitFields(line)
+	ports := make([]uint8, 0, len(l)-1)
+	for _, field := range l[1:] {
+		port, err := strconv.ParseUint(field, 10, 8)
+		if err != nil {
+			return "", nil, fmt.Errorf("Invalid port %v", field)
+		}
+		ports = append(ports, uint8(port))
 	}
+	return l[0], ports, nil
 }
 
-func (e *environment) ParseLines(lines []string) {
-	nodeIdx, _ := findLabelIndex(NODE_LABEL, lines)
-	lenLines := len(lines)
-	for i := nodeIdx + 1; i < lenLines; i++ {
-		if strings.Contains(lines[i], "#") {
-			break
+// parseOspfRouter reads the router running OSPF and its ports, as port or
+// port:cost, all of them with the default cost when none are listed
+func parseOspfRouter(line string) (string, map[uint8]uint32, error) {
+	l := splitFields(line)
+	costs := make(map[uint8]uint32)
+	for _, field := range l[1:] {
+		spec := strings.SplitN(field, ":", 2)
+		port, err := strconv.ParseUint(spec[0], 10, 8)
+		if err != nil {
+			return "", nil, fmt.Errorf("Invalid port %v", spec[0])
+		}
+		cost := uint64(OSPF_DEFAULT_COST)
+		if len(spec) == 2 {
+			cost, err = strconv.ParseUint(spec[1], 10, 32)
+			if err != nil {
+				return "", nil, fmt.Errorf("Invalid cost %v", spec[1])
+			}
 		}
-		e.AddNode(parseNode(lines[i]))
+		costs[uint8(port)] = uint32(cost)
 	}
+	return l[0], costs, nil
+}
+
+// parseBgpRouter reads the router running BGP, its AS number and the networks it announces
+func parseBgpRouter(line string) (string, uint32, []string, error) {
+	l := splitFields(line)
+	if len(l) < 2 {
+		return "", 0, nil, fmt.Errorf("Expected the router name and its AS number")
+	}
+	asn, err := strconv.ParseUint(l[1], 10, 32)
+	if err != nil {
+		return "", 0, nil, fmt.Errorf("Invalid AS number %v", l[1])
+	}
+	return l[0], uint32(asn), l[2:], nil
+}
 
-	routerIdx, _ := findLabelIndex(ROUTER_LABEL, lines)
-	for i := routerIdx + 1; i < lenLines; i++ {
-		if strings.Contains(lines[i], "#") {
-			break
+// parseBgpNeighbor reads a session of the router with the peer address and its
+// policy, as localpref=N, med=N, prepend=N, deny-in=prefix, deny-out=prefix and
+// next-hop-self
+func parseBgpNeighbor(line string) (string, string, BgpPolicy, error) {
+	l := splitFields(line)
+	policy := BgpPolicy{}
+	if len(l) < 2 {
+		return "", "", policy, fmt.Errorf("Expected the router name and the peer address")
+	}
+	for _, field := range l[2:] {
+		spec := strings.SplitN(field, "=", 2)
+		if spec[0] == "next-hop-self" && len(spec) == 1 {
+			policy.NextHopSelf = true
+			continue
+		}
+		if len(spec) != 2 {
+			return "", "", policy, fmt.Errorf("Invalid BGP option %v", field)
+		}
+		var err error
+		var value uint64
+		switch spec[0] {
+		case "localpref":
+			value, err = strconv.ParseUint(spec[1], 10, 32)
+			policy.LocalPref = uint32(value)
+		case "med":
+			value, err = strconv.ParseUint(spec[1], 10, 32)
+			policy.Med = uint32(value)
+		case "prepend":
+			value, err = strconv.ParseUint(spec[1], 10, 8)
+			policy.Prepend = int(value)
+		case "deny-in":
+			policy.DenyIn = append(policy.DenyIn, spec[1])
+		case "deny-out":
+			policy.DenyOut = append(policy.DenyOut, spec[1])
+		default:
+			return "", "", policy, fmt.Errorf("Unknown BGP option %v", spec[0])
+		}
+		if err != nil {
+			return "", "", policy, fmt.Errorf("Invalid value of the BGP option %v", field)
 		}
-		e.AddRouter(parseRouter(lines[i]))
 	}
+	return l[0], l[1], policy, nil
+}
+
+// ParseLines creates the devices, routes and routing protocols of the topology.
+// The files it includes are relative to the current directory.
+func (e *environment) ParseLines(lines []string) error {
+	topology, err := readTopologyLines("", lines, nil)
+	if err != nil {
+		return err
+	}
+	return e.parseTopology(topology)
+}
 
-	routeTableIdx, _ := findLabelIndex(ROUTER_TABLE_LABEL, lines)
-	for i := routeTableIdx + 1; i < lenLines; i++ {
-		if strings.Contains(lines[i], "#") {
-			break
+func (e *environment) parseTopology(lines []topologyLine) error {
+	fail := func(l topologyLine, err error) error {
+		return &TopologyError{Path: l.path, Line: l.number, Text: l.text, Msg: err.Error()}
+	}
+
+	// lines of each section, in the order they were read, wherever the section is
+	sections := make(map[string][]int)
+	section := ""
+	for i, l := range lines {
+		switch {
+		case l.label != "":
+			section = l.label
+		case l.content == "":
+			// blank lines and comments
+		case section == "":
+			return fail(l, fmt.Errorf("Expected a section label, as %v, before the line", NODE_LABEL))
+		default:
+			sections[section] = append(sections[section], i)
+		}
+	}
+
+	// the devices are added once the placeholders of their addresses are resolved
+	plan := newAddressPlan()
+	nodes := make([]*node, 0)
+	for _, i := range sections[NODE_LABEL] {
+		nd, err := parseNode(lines[i].content, i, plan)
+		if err != nil {
+			return fail(lines[i], err)
+		}
+		nodes = append(nodes, nd)
+	}
+
+	routers := make([]*router, 0)
+	for _, i := range sections[ROUTER_LABEL] {
+		rt, err := parseRouter(lines[i].content, i, plan)
+		if err != nil {
+			return fail(lines[i], err)
+		}
+		routers = append(routers, rt)
+	}
+
+	err := plan.assignAddresses(routers, func(at placeholder, msg string) error {
+		return fail(lines[at.line], errors.New(msg))
+	})
+	if err != nil {
+		return err
+	}
+	e.lines = lines
+	e.resolved = plan.resolved
+	for _, nd := range nodes {
+		e.AddNode(nd)
+	}
+	for _, rt := range routers {
+		e.AddRouter(rt)
+	}
+
+	// the switches are added before their ports, that can link to later switches
+	for _, i := range sections[SWITCH_LABEL] {
+		name, ports, aging, err := parseSwitch(lines[i].content)
+		if err != nil {
+			return fail(lines[i], err)
+		}
+		if err := e.AddSwitch(name, len(ports), aging); err != nil {
+			return fail(lines[i], err)
+		}
+	}
+	for _, i := range sections[SWITCH_LABEL] {
+		name, ports, _, _ := parseSwitch(lines[i].content)
+		for port, device := range ports {
+			if device == "" {
+				continue
+			}
+			if err := e.AttachSwitchPort(name, port, device); err != nil {
+				return fail(lines[i], err)
+			}
+		}
+	}
+
+	for _, i := range sections[ROUTER_TABLE_LABEL] {
+		routerName, entry, err := parseRouterTableEntry(lines[i].content)
+		if err != nil {
+			return fail(lines[i], err)
 		}
-		routerName, entry := parseRouterTableEntry(lines[i])
 		router := e.GetRouterByName(routerName)
+		if router == nil {
+			return fail(lines[i], fmt.Errorf("Unknown router %v", routerName))
+		}
+		if _, hasPort := router.GetPortByNumber(entry.port); !hasPort {
+			return fail(lines[i], fmt.Errorf("Router %v has no port %v", routerName, entry.port))
+		}
+		for _, msg := range router.checkStaticRoute(entry) {
+			e.warnings = append(e.warnings, fail(lines[i], errors.New(msg)).(*TopologyError))
+		}
 		router.AddRouterTableEntry(entry)
 	}
+
+	for _, i := range sections[RIP_LABEL] {
+		routerName, ports, err := parseRipRouter(lines[i].content)
+		if err != nil {
+			return fail(lines[i], err)
+		}
+		if err := e.EnableRip(routerName, ports); err != nil {
+			return fail(lines[i], err)
+		}
+	}
+
+	for _, i := range sections[OSPF_LABEL] {
+		routerName, costs, err := parseOspfRouter(lines[i].content)
+		if err != nil {
+			return fail(lines[i], err)
+		}
+		if err := e.EnableOspf(routerName, costs); err != nil {
+			return fail(lines[i], err)
+		}
+	}
+
+	for _, i := range sections[BGP_LABEL] {
+		routerName, asn, networks, err := parseBgpRouter(lines[i].content)
+		if err != nil {
+			return fail(lines[i], err)
+		}
+		if err := e.EnableBgp(routerName, asn, networks); err != nil {
+			return fail(lines[i], err)
+		}
+	}
+
+	for _, i := range sections[BGP_NEIGHBOR_LABEL] {
+		routerName, peerIp, policy, err := parseBgpNeighbor(lines[i].content)
+		if err != nil {
+			return fail(lines[i], err)
+		}
+		if err := e.AddBgpNeighbor(routerName, peerIp, policy); err != nil {
+			return fail(lines[i], err)
+		}
+	}
+	return nil
 }
 
 /*
@@ -1119,23 +1591,76 @@ Run the Simulator
 ----------------------------------------------------
 */
 
-func Run(ctx *cli.Context) error {
-	args := &file.InputArgs{}
+// LoadEnvironment reads the topology file and creates its environment
+func LoadEnvironment(path string) (Environment, error) {
+	filePath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read topology: %w", err)
+	}
+	fileR, err := file.Read(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read topology: %w", err)
+	}
 
-	_ = file.ValidateInputeArgs(args, ctx)
+	// craete env and parse lines
+	env := NewEnvironment().(*environment)
+	topology, err := readTopologyLines(path, fileR, []string{filePath})
+	if err != nil {
+		return nil, err
+	}
+	if err := env.parseTopology(topology); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
 
-	filePath, _ := filepath.Abs(args.Topology)
-	fileR := file.Read(filePath)
+// Ping sends the message from the source node to the destination node
+func Ping(env Environment, srcName, dstName, msg string) error {
+	env.ConvergeRouting(false)
 
-	// craete env and parse lines
-	env := NewEnvironment()
-	env.ParseLines(fileR)
+	src, isNode := env.GetNetComponentByName(srcName).(Node)
+	if !isNode {
+		return &UnknownNodeError{Name: srcName, Role: "source"}
+	}
+	ipSrc := src.GetNetInterface()
 
-	src := env.GetNetComponentByName(args.SrcNode)
-	ipSrc := src.(Node).GetNetInterface()
+	dest, isNode := env.GetNetComponentByName(dstName).(Node)
+	if !isNode {
+		return &UnknownNodeError{Name: dstName, Role: "destination"}
+	}
+	if msg == "" {
+		return &UsageError{Msg: "The message can not be empty"}
+	}
+	ipDest := dest.GetNetInterface()
 
-	dest := env.GetNetComponentByName(args.DstNode)
-	ipDest := dest.(Node).GetNetInterface()
+	return env.SendMessage(msg, ipSrc.ip, ipDest.ip)
+}
+
+func Run(ctx *cli.Context) error {
+	args := &file.InputArgs{}
+
+	if err := file.ValidateInputeArgs(args, ctx); err != nil {
+		return &UsageError{Msg: err.Error()}
+	}
 
-	return env.SendMessage(args.Msg, ipSrc.ip, ipDest.ip)
+	env, err := LoadEnvironment(args.Topology)
+	if err != nil {
+		return err
+	}
+	PrintWarnings(env)
+	if err := SetLinkFailures(env, ctx.StringSlice("down")); err != nil {
+		return err
+	}
+
+	out, err := output.New(ctx.String("format"), os.Stdout, env.GetNames())
+	if err != nil {
+		return &UsageError{Msg: err.Error()}
+	}
+	env.SetSink(out)
+	env.ConvergeRouting(ctx.Bool("show-routing"))
+
+	if err := Ping(env, args.SrcNode, args.DstNode, args.Msg); err != nil {
+		return err
+	}
+	return out.Close()
 }
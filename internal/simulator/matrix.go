@@ -0,0 +1,208 @@
+package simulator
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/arielril/network-simulator/internal/event"
+	"github.com/urfave/cli"
+)
+
+// Formats of the reachability matrix
+const (
+	MATRIX_TABLE string = "table"
+	MATRIX_CSV   string = "csv"
+	MATRIX_JSON  string = "json"
+)
+
+// Outcomes of a ping of the reachability matrix
+const (
+	PING_REPLY         string = "reply"
+	PING_TIME_EXCEEDED string = "time-exceeded"
+	PING_UNREACHABLE   string = "unreachable"
+)
+
+// PingOutcome is what happened to the ping from a node to another
+type PingOutcome struct {
+	Src     string `json:"src"`
+	Dst     string `json:"dst"`
+	Outcome string `json:"outcome"`
+	// Hops of the echo request, up to the router that discarded it
+	Hops int `json:"hops"`
+	// Devices the echo request went through, from the source
+	Path []string `json:"path"`
+	// Most fragments a packet of the ping was split into
+	Fragments int `json:"fragments"`
+	// Failure that stopped the simulation, for unreachable nodes
+	Error string `json:"error,omitempty"`
+}
+
+func newPingOutcome(src, dst string, events []event.Event, err error) PingOutcome {
+	outcome := PingOutcome{Src: src, Dst: dst, Outcome: PING_UNREACHABLE, Path: []string{}}
+	for _, h := range pathHops(events) {
+		if h.kind == event.ECHO_REQUEST {
+			outcome.Hops++
+			if len(outcome.Path) == 0 {
+				outcome.Path = append(outcome.Path, h.src)
+			}
+			outcome.Path = append(outcome.Path, h.dst)
+		}
+		if h.fragments > outcome.Fragments {
+			outcome.Fragments = h.fragments
+		}
+	}
+
+	for _, ev := range events {
+		switch {
+		case ev.Kind == event.TIME_EXCEEDED:
+			outcome.Outcome = PING_TIME_EXCEEDED
+		case ev.Kind == event.ECHO_REPLY && ev.Dst == src && outcome.Outcome == PING_UNREACHABLE:
+			outcome.Outcome = PING_REPLY
+		}
+	}
+	if err != nil {
+		outcome.Outcome = PING_UNREACHABLE
+		outcome.Error = err.Error()
+	}
+	return outcome
+}
+
+// PingMatrix pings every node from every other node. Each ping runs on a clone
+// of the environment, concurrently on up to the number of workers goroutines,
+// or all of them on the environment itself when shared, so the ARP tables
+// filled by a ping are used by the next ones.
+func PingMatrix(env Environment, shared bool, msg string, workers int) ([]string, []PingOutcome) {
+	nodes := make([]string, 0)
+	for _, name := range env.GetNames() {
+		if _, isNode := env.GetNetComponentByName(name).(Node); isNode {
+			nodes = append(nodes, name)
+		}
+	}
+
+	sims := make([]Simulation, 0, len(nodes)*len(nodes))
+	for _, src := range nodes {
+		for _, dst := range nodes {
+			if src != dst {
+				sims = append(sims, Simulation{Env: env, Src: src, Dst: dst, Msg: msg})
+			}
+		}
+	}
+
+	outcomes := make([]PingOutcome, 0, len(sims))
+	if !shared {
+		for _, res := range RunSimulations(sims, workers) {
+			outcomes = append(outcomes, newPingOutcome(res.Src, res.Dst, res.Events, res.Err))
+		}
+		return nodes, outcomes
+	}
+
+	for _, sim := range sims {
+		recorder := &event.Recorder{}
+		env.SetSink(recorder)
+		err := Ping(env, sim.Src, sim.Dst, sim.Msg)
+		outcomes = append(outcomes, newPingOutcome(sim.Src, sim.Dst, recorder.Events, err))
+	}
+	return nodes, outcomes
+}
+
+func (o PingOutcome) cell() string {
+	switch o.Outcome {
+	case PING_REPLY:
+		return fmt.Sprintf("reply h=%v f=%v", o.Hops, o.Fragments)
+	case PING_TIME_EXCEEDED:
+		return fmt.Sprintf("ttl h=%v f=%v", o.Hops, o.Fragments)
+	}
+	return "unreachable"
+}
+
+// WriteMatrixTable prints a row for each source node and a column for each destination
+func WriteMatrixTable(w io.Writer, nodes []string, outcomes []PingOutcome) error {
+	cells := make(map[string]string)
+	for _, o := range outcomes {
+		cells[o.Src+" "+o.Dst] = o.cell()
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprint(tw, "src \\ dst")
+	for _, dst := range nodes {
+		fmt.Fprintf(tw, "\t%v", dst)
+	}
+	fmt.Fprintln(tw)
+	for _, src := range nodes {
+		fmt.Fprint(tw, src)
+		for _, dst := range nodes {
+			cell, done := cells[src+" "+dst]
+			if !done {
+				cell = "-"
+			}
+			fmt.Fprintf(tw, "\t%v", cell)
+		}
+		fmt.Fprintln(tw)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintln(w, "\nh: hops of the echo request, f: most fragments of a packet, ttl: time exceeded")
+	return err
+}
+
+// WriteMatrixCsv prints a line for each ping, with a header
+func WriteMatrixCsv(w io.Writer, outcomes []PingOutcome) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"src", "dst", "outcome", "hops", "fragments", "error"})
+	for _, o := range outcomes {
+		cw.Write([]string{o.Src, o.Dst, o.Outcome, strconv.Itoa(o.Hops), strconv.Itoa(o.Fragments), o.Error})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteMatrixJson prints the pings as a JSON array
+func WriteMatrixJson(w io.Writer, outcomes []PingOutcome) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(outcomes)
+}
+
+/*
+----------------------------------------------------
+Matrix command
+----------------------------------------------------
+*/
+
+// Matrix pings every node from every other node and prints the outcomes
+func Matrix(ctx *cli.Context) error {
+	args := ctx.Args()
+	if len(args) != 1 {
+		return &UsageError{Msg: "Invalid matrix arguments"}
+	}
+	format := ctx.String("format")
+	if format != MATRIX_TABLE && format != MATRIX_CSV && format != MATRIX_JSON {
+		return &UsageError{Msg: fmt.Sprintf(
+			"Unknown matrix format %q, expected one of [%v %v %v]", format, MATRIX_CSV, MATRIX_JSON, MATRIX_TABLE,
+		)}
+	}
+
+	env, err := LoadEnvironment(args.Get(0))
+	if err != nil {
+		return err
+	}
+	PrintWarnings(env)
+	if err := SetLinkFailures(env, ctx.StringSlice("down")); err != nil {
+		return err
+	}
+	nodes, outcomes := PingMatrix(env, ctx.Bool("shared"), ctx.String("message"), ctx.Int("jobs"))
+
+	switch format {
+	case MATRIX_CSV:
+		return WriteMatrixCsv(os.Stdout, outcomes)
+	case MATRIX_JSON:
+		return WriteMatrixJson(os.Stdout, outcomes)
+	}
+	return WriteMatrixTable(os.Stdout, nodes, outcomes)
+}
@@ -0,0 +1,67 @@
+package simulator
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func loadExample(t *testing.T, name string) Environment {
+	t.Helper()
+
+	env, err := LoadEnvironment(filepath.Join(EXAMPLES_DIR, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return env
+}
+
+func TestPingMatrix(t *testing.T) {
+	nodes, outcomes := PingMatrix(loadExample(t, "example3.txt"), false, "hello", 0)
+	if len(nodes) != 6 || len(outcomes) != 30 {
+		t.Fatalf("expected 30 pings between 6 nodes, got %v between %v", len(outcomes), nodes)
+	}
+
+	want := map[string]string{
+		"N1 N2": PING_REPLY,
+		"N3 N5": PING_REPLY,
+		"N1 N4": PING_TIME_EXCEEDED,
+		"N5 N2": PING_TIME_EXCEEDED,
+	}
+	for _, o := range outcomes {
+		if outcome, checked := want[o.Src+" "+o.Dst]; checked && o.Outcome != outcome {
+			t.Errorf("%v -> %v: expected %v, got %v", o.Src, o.Dst, outcome, o.Outcome)
+		}
+	}
+}
+
+func TestPingMatrixUnreachable(t *testing.T) {
+	env, err := loadTopology(t, behindRouter(twoNetworks))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, outcomes := PingMatrix(env, true, "hello", 0)
+	for _, o := range outcomes {
+		if o.Outcome != PING_UNREACHABLE || !strings.Contains(o.Error, "no route") {
+			t.Errorf("%v -> %v: expected unreachable without route, got %v (%v)", o.Src, o.Dst, o.Outcome, o.Error)
+		}
+	}
+}
+
+// The ARP tables kept by the shared environment do not change the outcomes
+func TestPingMatrixShared(t *testing.T) {
+	_, fresh := PingMatrix(loadExample(t, "example6.txt"), false, "abcdefghijklmnopqrstuvwxyz", 0)
+	_, shared := PingMatrix(loadExample(t, "example6.txt"), true, "abcdefghijklmnopqrstuvwxyz", 0)
+
+	var freshCsv, sharedCsv bytes.Buffer
+	if err := WriteMatrixCsv(&freshCsv, fresh); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteMatrixCsv(&sharedCsv, shared); err != nil {
+		t.Fatal(err)
+	}
+	if diff := diffLines(splitLines(freshCsv.String()), splitLines(sharedCsv.String())); diff != "" {
+		t.Errorf("Shared environment differs from fresh ones:\n%v", diff)
+	}
+}
@@ -0,0 +1,569 @@
+package simulator
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/arielril/network-simulator/internal/event"
+	"github.com/urfave/cli"
+)
+
+// OSPF timers (in seconds) and constants
+const (
+	OSPF_HELLO_INTERVAL int = 10
+	// A neighbor that sends no hello for this long is down
+	OSPF_DEAD_INTERVAL int = 40
+	// Delay between a change of the link state database and the SPF calculation
+	OSPF_SPF_DELAY int = 1
+
+	OSPF_DEFAULT_COST  uint32 = 1
+	OSPF_MAX_COST      uint32 = 65535
+	OSPF_MULTICAST_IP  string = "224.0.0.5"
+	OSPF_MULTICAST_MAC MAC    = "01:00:5E:00:00:05"
+)
+
+// ospfLink is a link described by an LSA: a network of a router port or, when
+// the neighbor is set, an adjacency with the neighbor on that network
+type ospfLink struct {
+	netdest IP
+	// Port of the router that originated the LSA
+	port uint8
+	cost uint32
+	// Name of the neighbor router and its address on the network
+	neighbor string
+	addr     IP
+}
+
+func (l ospfLink) String() string {
+	if l.neighbor == "" {
+		return fmt.Sprintf("network %v cost=%v", l.netdest.ToString(), l.cost)
+	}
+	return fmt.Sprintf("neighbor %v via %v cost=%v", l.neighbor, l.addr.ip, l.cost)
+}
+
+// lsa is the link state advertisement of a router. A newer LSA of the router
+// has a greater sequence number and replaces the older one.
+type lsa struct {
+	router string
+	seq    int
+	links  []ospfLink
+}
+
+type ospfPort struct {
+	number uint8
+	cost   uint32
+}
+
+// ospfNeighbor is the adjacency with another router, formed on a port when its
+// hellos are received
+type ospfNeighbor struct {
+	proc      *ospfProcess
+	port      uint8
+	addr      IP
+	lastHello int
+	up        bool
+}
+
+// spfNode is a router on the shortest path tree, with the first hop to reach it
+type spfNode struct {
+	router  string
+	cost    uint32
+	parent  string
+	port    uint8
+	nexthop IP
+}
+
+// ospfProcess is OSPF running on the ports of a router
+type ospfProcess struct {
+	router    *router
+	ports     []ospfPort
+	neighbors []*ospfNeighbor
+	lsdb      map[string]*lsa
+	seq       int
+	tree      []spfNode
+	// An SPF calculation is scheduled
+	spfScheduled bool
+}
+
+// ospf is the OSPF area, formed by the routers running it
+type ospf struct {
+	env        *environment
+	procs      []*ospfProcess
+	scheduler  *scheduler
+	emit       func(ev event.Event)
+	lastChange int
+}
+
+// EnableOspf runs OSPF on the ports of the router with their costs, or on all
+// of them with the default cost when none are given
+func (e *environment) EnableOspf(routerName string, costs map[uint8]uint32) error {
+	rt := e.GetRouterByName(routerName)
+	if rt == nil {
+		return fmt.Errorf("Unknown router %v", routerName)
+	}
+
+	ports := make([]ospfPort, 0, len(rt.ports))
+	for _, p := range rt.ports {
+		cost, listed := costs[p.number]
+		if len(costs) == 0 {
+			cost, listed = OSPF_DEFAULT_COST, true
+		}
+		if listed {
+			ports = append(ports, ospfPort{number: p.number, cost: cost})
+		}
+	}
+	for number, cost := range costs {
+		if _, hasPort := rt.GetPortByNumber(number); !hasPort {
+			return fmt.Errorf("Router %v has no port %v", routerName, number)
+		}
+		if cost == 0 || cost > OSPF_MAX_COST {
+			return fmt.Errorf("Invalid cost %v of port %v, expected a value between 1 and %v", cost, number, OSPF_MAX_COST)
+		}
+	}
+
+	area := e.ospfArea()
+	if area == nil {
+		area = &ospf{env: e}
+		e.routing.protocols = append(e.routing.protocols, area)
+	}
+	if area.process(rt.name) != nil {
+		return fmt.Errorf("OSPF is enabled more than once on router %v", routerName)
+	}
+	area.procs = append(area.procs, &ospfProcess{router: rt, ports: ports, lsdb: make(map[string]*lsa)})
+	e.routing.converged = false
+	return nil
+}
+
+func (e *environment) ospfArea() *ospf {
+	for _, p := range e.routing.protocols {
+		if area, isOspf := p.(*ospf); isOspf {
+			return area
+		}
+	}
+	return nil
+}
+
+func (d *ospf) process(routerName string) *ospfProcess {
+	for _, proc := range d.procs {
+		if proc.router.name == routerName {
+			return proc
+		}
+	}
+	return nil
+}
+
+func (p *ospfProcess) runsOn(number uint8) bool {
+	for _, port := range p.ports {
+		if port.number == number {
+			rp, _ := p.router.GetPortByNumber(number)
+			return !rp.down
+		}
+	}
+	return false
+}
+
+func (p *ospfProcess) findNeighbor(port uint8, other *ospfProcess) *ospfNeighbor {
+	for _, nb := range p.neighbors {
+		if nb.port == port && nb.proc == other {
+			return nb
+		}
+	}
+	return nil
+}
+
+/*
+----------------------------------------------------
+Hellos and flooding
+----------------------------------------------------
+*/
+
+func (d *ospf) start(s *scheduler, emit func(ev event.Event)) {
+	d.scheduler = s
+	d.emit = emit
+	for _, proc := range d.procs {
+		d.originate(proc, 0)
+	}
+
+	var periodic func(now int)
+	periodic = func(now int) {
+		for _, proc := range d.procs {
+			d.sendHellos(proc, now)
+		}
+		s.after(OSPF_HELLO_INTERVAL, periodic)
+	}
+	s.at(0, periodic)
+}
+
+// sendHellos sends a hello on every port to the routers on the same network.
+// Only the hellos that form an adjacency are shown.
+func (d *ospf) sendHellos(proc *ospfProcess, now int) {
+	for _, port := range proc.ports {
+		if !proc.runsOn(port.number) {
+			continue
+		}
+		rp, _ := proc.router.GetPortByNumber(port.number)
+
+		for _, other := range d.procs {
+			if other == proc {
+				continue
+			}
+			for _, otherPort := range other.ports {
+				op, _ := other.router.GetPortByNumber(otherPort.number)
+				if other.runsOn(otherPort.number) && rp.ip.IsSameNet(op.ip) && op.ip.IsSameNet(rp.ip) {
+					d.hello(proc, rp, other, otherPort.number, now)
+				}
+			}
+		}
+	}
+}
+
+func (d *ospf) hello(from *ospfProcess, fromPort routerPort, proc *ospfProcess, port uint8, now int) {
+	nb := proc.findNeighbor(port, from)
+	if nb == nil {
+		nb = &ospfNeighbor{proc: from, port: port, addr: *NewIp(fromPort.ip.ip)}
+		proc.neighbors = append(proc.neighbors, nb)
+	}
+	nb.lastHello = now
+	d.scheduleDead(proc, nb, now)
+	if nb.up {
+		return
+	}
+
+	d.emit(event.Event{
+		Kind:   event.OSPF_HELLO,
+		Src:    from.router.name,
+		Dst:    proc.router.name,
+		SrcMac: string(fromPort.mac),
+		DstMac: string(OSPF_MULTICAST_MAC),
+		SrcIp:  fromPort.ip.ip,
+		DstIp:  OSPF_MULTICAST_IP,
+		Ttl:    1,
+		Data:   fmt.Sprintf("router=%v hello=%v dead=%v", from.router.name, OSPF_HELLO_INTERVAL, OSPF_DEAD_INTERVAL),
+		Time:   now,
+	})
+	nb.up = true
+	d.originate(proc, now)
+}
+
+// originate builds a new LSA of the router from its ports and adjacencies and
+// floods it
+func (d *ospf) originate(proc *ospfProcess, now int) {
+	links := make([]ospfLink, 0)
+	for _, port := range proc.ports {
+		if !proc.runsOn(port.number) {
+			continue
+		}
+		rp, _ := proc.router.GetPortByNumber(port.number)
+		links = append(links, ospfLink{netdest: rp.ip.Network(), port: port.number, cost: port.cost})
+
+		for _, nb := range proc.neighbors {
+			if nb.up && nb.port == port.number {
+				links = append(links, ospfLink{
+					netdest:  rp.ip.Network(),
+					port:     port.number,
+					cost:     port.cost,
+					neighbor: nb.proc.router.name,
+					addr:     nb.addr,
+				})
+			}
+		}
+	}
+
+	proc.seq++
+	adv := &lsa{router: proc.router.name, seq: proc.seq, links: links}
+	proc.lsdb[adv.router] = adv
+	d.changed(proc, now)
+	d.flood(proc, adv, nil, now)
+}
+
+// flood sends the LSA to every neighbor, except the one it came from
+func (d *ospf) flood(proc *ospfProcess, adv *lsa, from *ospfProcess, now int) {
+	text := make([]string, len(adv.links))
+	for i, l := range adv.links {
+		text[i] = l.String()
+	}
+
+	for _, nb := range proc.neighbors {
+		if !nb.up || nb.proc == from || !proc.runsOn(nb.port) {
+			continue
+		}
+		rp, _ := proc.router.GetPortByNumber(nb.port)
+		d.emit(event.Event{
+			Kind:   event.OSPF_LSU,
+			Src:    proc.router.name,
+			Dst:    nb.proc.router.name,
+			SrcMac: string(rp.mac),
+			DstMac: string(OSPF_MULTICAST_MAC),
+			SrcIp:  rp.ip.ip,
+			DstIp:  OSPF_MULTICAST_IP,
+			Ttl:    1,
+			Data:   fmt.Sprintf("%v seq=%v: %v", adv.router, adv.seq, strings.Join(text, ", ")),
+			Time:   now,
+		})
+
+		neighbor := nb.proc
+		d.scheduler.after(0, func(now int) {
+			d.receive(neighbor, proc, adv, now)
+		})
+	}
+}
+
+// receive installs the LSA sent by the neighbor when it is newer than the one
+// on the database, and floods it to the other neighbors
+func (d *ospf) receive(proc *ospfProcess, from *ospfProcess, adv *lsa, now int) {
+	if current, known := proc.lsdb[adv.router]; known && current.seq >= adv.seq {
+		return
+	}
+	proc.lsdb[adv.router] = adv
+	d.changed(proc, now)
+	d.flood(proc, adv, from, now)
+}
+
+/*
+----------------------------------------------------
+Timers
+----------------------------------------------------
+*/
+
+func (d *ospf) scheduleDead(proc *ospfProcess, nb *ospfNeighbor, heard int) {
+	d.scheduler.at(heard+OSPF_DEAD_INTERVAL, func(now int) {
+		if nb.up && nb.lastHello == heard {
+			nb.up = false
+			d.originate(proc, now)
+		}
+	})
+}
+
+// changed schedules the SPF calculation of the router
+func (d *ospf) changed(proc *ospfProcess, now int) {
+	d.lastChange = now
+	if proc.spfScheduled {
+		return
+	}
+	proc.spfScheduled = true
+	d.scheduler.at(now+OSPF_SPF_DELAY, func(now int) {
+		proc.spfScheduled = false
+		d.spf(proc)
+	})
+}
+
+func (d *ospf) linkDown(s *scheduler, r *router, port uint8) {
+	for _, proc := range d.procs {
+		if proc.router != r {
+			continue
+		}
+		for _, nb := range proc.neighbors {
+			if nb.port == port {
+				nb.up = false
+			}
+		}
+		d.originate(proc, s.now)
+	}
+}
+
+// stable tells if the databases did not change on the last hello interval, no
+// SPF calculation is pending and every neighbor is sending hellos
+func (d *ospf) stable(now int) bool {
+	if now < OSPF_HELLO_INTERVAL || now-d.lastChange < OSPF_HELLO_INTERVAL {
+		return false
+	}
+	for _, proc := range d.procs {
+		if proc.spfScheduled {
+			return false
+		}
+		for _, nb := range proc.neighbors {
+			if nb.up && now-nb.lastHello >= OSPF_HELLO_INTERVAL {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+/*
+----------------------------------------------------
+Shortest path first
+----------------------------------------------------
+*/
+
+// hasLink tells if the LSA of the router has an adjacency with the neighbor, so
+// only links seen by both ends are used
+func (p *ospfProcess) hasLink(routerName, neighbor string) bool {
+	adv, known := p.lsdb[routerName]
+	if !known {
+		return false
+	}
+	for _, l := range adv.links {
+		if l.neighbor == neighbor {
+			return true
+		}
+	}
+	return false
+}
+
+// spf computes the shortest path tree of the router with Dijkstra and installs
+// a route to every network on it
+func (d *ospf) spf(proc *ospfProcess) {
+	root := proc.router.name
+	nodes := map[string]*spfNode{root: {router: root, nexthop: *NewIp("0.0.0.0")}}
+	tree := make([]spfNode, 0, len(proc.lsdb))
+
+	for {
+		// the closest router not on the tree, ties broken by name
+		var next *spfNode
+		for _, n := range nodes {
+			if next == nil || n.cost < next.cost || (n.cost == next.cost && n.router < next.router) {
+				next = n
+			}
+		}
+		if next == nil {
+			break
+		}
+		delete(nodes, next.router)
+		tree = append(tree, *next)
+
+		adv, known := proc.lsdb[next.router]
+		if !known {
+			continue
+		}
+		for _, l := range adv.links {
+			if l.neighbor == "" || onTree(tree, l.neighbor) || !proc.hasLink(l.neighbor, next.router) {
+				continue
+			}
+			cost := next.cost + l.cost
+			if n, seen := nodes[l.neighbor]; seen && n.cost <= cost {
+				continue
+			}
+			n := &spfNode{router: l.neighbor, cost: cost, parent: next.router, port: next.port, nexthop: next.nexthop}
+			if next.router == root {
+				n.port, n.nexthop = l.port, l.addr
+			}
+			nodes[l.neighbor] = n
+		}
+	}
+	proc.tree = tree
+
+	entries := make([]*routerTableEntry, 0)
+	for _, n := range tree {
+		for _, l := range proc.lsdb[n.router].links {
+			if l.neighbor != "" {
+				continue
+			}
+			ent := &routerTableEntry{netdest: l.netdest, nexthop: n.nexthop, port: n.port, metric: n.cost + l.cost}
+			if n.router == root {
+				ent.port = l.port
+			}
+
+			replaced := false
+			for i, other := range entries {
+				if other.netdest == ent.netdest {
+					if ent.metric < other.metric {
+						entries[i] = ent
+					}
+					replaced = true
+				}
+			}
+			if !replaced {
+				entries = append(entries, ent)
+			}
+		}
+	}
+	proc.router.installRoutes(OSPF_ROUTE, entries)
+}
+
+func onTree(tree []spfNode, routerName string) bool {
+	for _, n := range tree {
+		if n.router == routerName {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+----------------------------------------------------
+Link state dump
+----------------------------------------------------
+*/
+
+// WriteOspf prints the link state database, the shortest path tree and the
+// OSPF routes of the router, or of every router running OSPF when no name is given
+func (e *environment) WriteOspf(w io.Writer, routerName string) error {
+	area := e.ospfArea()
+	if area == nil {
+		return &UsageError{Msg: "No router runs OSPF"}
+	}
+	procs := area.procs
+	if routerName != "" {
+		proc := area.process(routerName)
+		if proc == nil {
+			return &UsageError{Msg: fmt.Sprintf("Router %v does not run OSPF", routerName)}
+		}
+		procs = []*ospfProcess{proc}
+	}
+
+	for i, proc := range procs {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "Router %v\n", proc.router.name)
+
+		fmt.Fprintln(w, "  Link state database")
+		names := make([]string, 0, len(proc.lsdb))
+		for name := range proc.lsdb {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			adv := proc.lsdb[name]
+			fmt.Fprintf(w, "    %v seq=%v\n", adv.router, adv.seq)
+			for _, l := range adv.links {
+				fmt.Fprintf(w, "      %v\n", l)
+			}
+		}
+
+		fmt.Fprintln(w, "  SPF tree")
+		for _, n := range proc.tree {
+			if n.parent == "" {
+				fmt.Fprintf(w, "    %v cost=%v\n", n.router, n.cost)
+				continue
+			}
+			fmt.Fprintf(w, "    %v cost=%v parent=%v via %v port %v\n", n.router, n.cost, n.parent, n.nexthop.ip, n.port)
+		}
+
+		fmt.Fprintln(w, "  Routes")
+		for _, ent := range proc.router.routerTable {
+			if ent.source == OSPF_ROUTE {
+				fmt.Fprintf(w, "    %v via %v port %v cost=%v\n", ent.netdest.ToString(), ent.nexthop.ip, ent.port, ent.metric)
+			}
+		}
+	}
+	return nil
+}
+
+// Ospf runs the routing protocols of the topology and prints the link state of
+// the routers running OSPF
+func Ospf(ctx *cli.Context) error {
+	args := ctx.Args()
+	if len(args) < 1 || len(args) > 2 {
+		return &UsageError{Msg: "Invalid ospf arguments"}
+	}
+
+	env, err := LoadEnvironment(args.Get(0))
+	if err != nil {
+		return err
+	}
+	if err := SetLinkFailures(env, ctx.StringSlice("down")); err != nil {
+		return err
+	}
+
+	env.SetSink(event.Writer{W: os.Stdout})
+	env.ConvergeRouting(ctx.Bool("show-routing"))
+	if ctx.Bool("show-routing") {
+		fmt.Println()
+	}
+	return env.WriteOspf(os.Stdout, args.Get(1))
+}
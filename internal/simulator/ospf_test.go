@@ -0,0 +1,120 @@
+package simulator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/arielril/network-simulator/internal/event"
+)
+
+// ospfTriangle has three routers running OSPF. The link between r1 and r3
+// costs 5, so n1 reaches n2 through r2 until the link between r1 and r2 is down.
+const ospfTriangle = `
+#NODE
+n1,00:00:00:00:00:01,10.0.0.2/24,5,10.0.0.1
+n2,00:00:00:00:00:02,20.0.0.2/24,5,20.0.0.1
+#ROUTER
+r1,3,00:00:00:00:00:10,10.0.0.1/24,5,00:00:00:00:00:11,100.0.1.1/24,5,00:00:00:00:00:12,100.0.3.1/24,5
+r2,2,00:00:00:00:00:20,100.0.1.2/24,5,00:00:00:00:00:21,100.0.2.1/24,5
+r3,3,00:00:00:00:00:30,20.0.0.1/24,5,00:00:00:00:00:31,100.0.2.2/24,5,00:00:00:00:00:32,100.0.3.2/24,5
+#ROUTERTABLE
+#OSPF
+r1,0,1,2:5
+r2
+r3,0,1,2:5
+`
+
+func TestOspfShortestPath(t *testing.T) {
+	env, _ := convergeRouting(t, ospfTriangle)
+
+	for _, tc := range []struct {
+		router, netdest, nexthop string
+		port                     uint8
+		metric                   uint32
+	}{
+		{"r1", "10.0.0.0/24", "0.0.0.0", 0, 1},
+		{"r1", "100.0.3.0/24", "0.0.0.0", 2, 5},
+		{"r1", "20.0.0.0/24", "100.0.1.2", 1, 3},
+		{"r3", "10.0.0.0/24", "100.0.2.1", 1, 3},
+		{"r2", "20.0.0.0/24", "100.0.2.2", 1, 2},
+	} {
+		ent := routeTo(t, env, tc.router, tc.netdest)
+		if ent == nil {
+			t.Errorf("%v has no route to %v", tc.router, tc.netdest)
+			continue
+		}
+		if ent.source != OSPF_ROUTE || ent.nexthop.ip != tc.nexthop || ent.port != tc.port || ent.metric != tc.metric {
+			t.Errorf(
+				"%v route to %v: want %v port %v cost %v, got %v port %v cost %v (%v)",
+				tc.router, tc.netdest, tc.nexthop, tc.port, tc.metric, ent.nexthop.ip, ent.port, ent.metric, ent.source,
+			)
+		}
+	}
+
+	recorder := &event.Recorder{}
+	env.SetSink(recorder)
+	if err := Ping(env, "n1", "n2", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	throughR2 := false
+	for _, ev := range recorder.Events {
+		throughR2 = throughR2 || (ev.Kind == event.ECHO_REQUEST && ev.Src == "r1" && ev.Dst == "r2")
+	}
+	if !throughR2 {
+		t.Error("Expected the ping to go through r2")
+	}
+}
+
+func TestOspfLinkDown(t *testing.T) {
+	env, events := convergeRouting(t, ospfTriangle, "r1:1")
+
+	ent := routeTo(t, env, "r1", "20.0.0.0/24")
+	if ent == nil || ent.nexthop.ip != "100.0.3.2" || ent.metric != 6 {
+		t.Fatalf("Expected r1 to reach 20.0.0.0/24 through r3 with cost 6, got %+v", ent)
+	}
+
+	// r2 only notices r1 is gone when its dead interval expires
+	deadAt := 0
+	for _, ev := range events {
+		if ev.Src == "r2" && strings.HasPrefix(ev.Data, "r2 seq=") && ev.Time > deadAt {
+			deadAt = ev.Time
+		}
+	}
+	if deadAt != OSPF_HELLO_INTERVAL+OSPF_DEAD_INTERVAL {
+		t.Errorf("Expected r2 to flood its LSA at %vs, got %vs", OSPF_HELLO_INTERVAL+OSPF_DEAD_INTERVAL, deadAt)
+	}
+	if ent := routeTo(t, env, "r2", "10.0.0.0/24"); ent == nil || ent.nexthop.ip != "100.0.2.2" {
+		t.Errorf("Expected r2 to reach 10.0.0.0/24 through r3, got %+v", ent)
+	}
+}
+
+func TestWriteOspf(t *testing.T) {
+	env, _ := convergeRouting(t, ospfTriangle)
+
+	var out strings.Builder
+	if err := env.WriteOspf(&out, "r1"); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"Router r1\n",
+		"    r3 seq=3\n",
+		"      neighbor r1 via 100.0.3.1 cost=5\n",
+		"    r3 cost=2 parent=r2 via 100.0.1.2 port 1\n",
+		"    20.0.0.0/24 via 100.0.1.2 port 1 cost=3\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected %q on the dump:\n%v", want, out.String())
+		}
+	}
+
+	if err := env.WriteOspf(&out, "n1"); err == nil {
+		t.Error("Expected an error dumping a device that does not run OSPF")
+	}
+}
+
+func TestOspfInvalidCost(t *testing.T) {
+	topology := strings.Replace(ospfTriangle, "r1,0,1,2:5", "r1,0,1,2:0", 1)
+	if _, err := loadTopology(t, topology); err == nil || !strings.Contains(err.Error(), "Invalid cost 0") {
+		t.Errorf("Expected an invalid cost error, got %v", err)
+	}
+}
@@ -0,0 +1,104 @@
+package simulator
+
+import (
+	"strings"
+	"testing"
+)
+
+func testPacket(data string) packet {
+	src := packetHost{name: "n1", ip: *NewIp("10.0.0.2/24"), mac: "00:00:00:00:00:01"}
+	dst := packetHost{name: "n2", ip: *NewIp("20.0.0.2/24"), mac: "00:00:00:00:00:02"}
+	return NewPacket(src, dst, ICMP_REQ, data, ICMP_TTL, 0, 0)
+}
+
+func TestFragment(t *testing.T) {
+	pkt := testPacket("abcdefghij")
+	pkt.off = 10
+	pkt.mf = 1
+
+	frags := Fragment(&pkt, 4)
+	want := []struct {
+		data    string
+		mf, off uint8
+	}{
+		{"abcd", 1, 10},
+		{"efgh", 1, 14},
+		{"ij", 1, 18},
+	}
+	if len(frags) != len(want) {
+		t.Fatalf("expected %v fragments, got %v", len(want), len(frags))
+	}
+	for i, w := range want {
+		f := frags[i]
+		if f.data != w.data || f.mf != w.mf || f.off != w.off || f.ttl != pkt.ttl || f.dst != pkt.dst {
+			t.Errorf("fragment %v: expected %+v, got %+v", i, w, *f)
+		}
+	}
+
+	last := testPacket("abcdefghij")
+	if frags := Fragment(&last, 4); frags[2].mf != 0 {
+		t.Errorf("expected the last fragment without the more fragments flag, got %v", frags[2].mf)
+	}
+	if frags := Fragment(&last, 20); len(frags) != 1 || frags[0].data != "abcdefghij" {
+		t.Errorf("expected a single fragment, got %v", frags)
+	}
+}
+
+func TestDefragment(t *testing.T) {
+	pkt := testPacket("abcdefghij")
+	frags := Fragment(&pkt, 3)
+	DecrementPktsTTL(frags)
+
+	whole := Defragment(frags)
+	if whole.data != "abcdefghij" || whole.ttl != ICMP_TTL-1 || whole.mf != 0 || whole.off != 0 {
+		t.Errorf("expected the original packet with the TTL decremented, got %+v", *whole)
+	}
+	if whole.src != pkt.src || whole.dst != pkt.dst || whole.typ != pkt.typ {
+		t.Errorf("expected the hosts and type of the fragments, got %+v", *whole)
+	}
+}
+
+var benchData = strings.Repeat("abcdefghijklmnopqrstuvwxyz", 8)
+
+func BenchmarkFragment(b *testing.B) {
+	pkt := testPacket(benchData)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		Fragment(&pkt, 5)
+	}
+}
+
+func BenchmarkDefragment(b *testing.B) {
+	pkt := testPacket(benchData)
+	frags := Fragment(&pkt, 5)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Defragment(frags)
+	}
+}
+
+func BenchmarkFragmentAll(b *testing.B) {
+	pkt := testPacket(benchData)
+	frags := Fragment(&pkt, 20)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		fragmentAll(frags, 5)
+	}
+}
+
+func BenchmarkPingFragmented(b *testing.B) {
+	env, err := LoadEnvironment(EXAMPLES_DIR + "/example6.txt")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		clone := env.Clone()
+		if err := Ping(clone, "n3", "n6", benchData); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package simulator
+
+import (
+	"bytes"
+	"context"
+	"runtime"
+	"sync"
+
+	"github.com/arielril/network-simulator/internal/event"
+)
+
+/*
+----------------------------------------------------
+Environment clones
+----------------------------------------------------
+*/
+
+func (n *node) clone() *node {
+	c := *n
+	c.arpTable = make(map[IP]MAC, len(n.arpTable))
+	for ip, mac := range n.arpTable {
+		c.arpTable[ip] = mac
+	}
+	return &c
+}
+
+func (r *router) clone() *router {
+	c := *r
+	c.ports = append([]routerPort{}, r.ports...)
+	c.routerTable = make([]*routerTableEntry, len(r.routerTable))
+	for i, ent := range r.routerTable {
+		entry := *ent
+		c.routerTable[i] = &entry
+	}
+	c.arpTable = make(map[IP]MAC, len(r.arpTable))
+	for ip, mac := range r.arpTable {
+		c.arpTable[ip] = mac
+	}
+	return &c
+}
+
+// Clone returns a copy of the environment that shares no device, table, ARP
+// cache or MAC table with it, so both can run simulations at the same time.
+// The routing protocols are converged first and the clone keeps the routes
+// they installed, but not the protocols. The clone sends its events to a new
+// Recorder.
+func (e *environment) Clone() Environment {
+	e.ConvergeRouting(false)
+
+	c := &environment{
+		nodes:    make([]*node, 0, len(e.nodes)),
+		routers:  make([]*router, 0, len(e.routers)),
+		index:    newComponentIndex(),
+		sink:     &event.Recorder{},
+		ctx:      context.Background(),
+		routing:  routing{converged: true},
+		warnings: append([]*TopologyError{}, e.warnings...),
+		lines:    e.lines,
+		resolved: e.resolved,
+	}
+	for _, n := range e.nodes {
+		c.AddNode(n.clone())
+	}
+	for _, r := range e.routers {
+		c.AddRouter(r.clone())
+	}
+	for _, s := range e.switches {
+		sw := s.clone()
+		c.switches = append(c.switches, sw)
+		c.index.addSwitch(sw)
+	}
+	c.clock = e.clock
+	return c
+}
+
+/*
+----------------------------------------------------
+Concurrent runner
+----------------------------------------------------
+*/
+
+// Simulation is a ping to run on a clone of the environment
+type Simulation struct {
+	Env Environment
+	Src string
+	Dst string
+	Msg string
+}
+
+// SimulationResult is what a simulation printed, in the order it was given
+type SimulationResult struct {
+	Simulation
+	Events []event.Event
+	// Lines of the simulator output, as printed by the text format
+	Output string
+	Err    error
+}
+
+func runSimulation(sim Simulation) SimulationResult {
+	env := sim.Env.Clone()
+	recorder := &event.Recorder{}
+	env.SetSink(recorder)
+	err := Ping(env, sim.Src, sim.Dst, sim.Msg)
+
+	var out bytes.Buffer
+	writer := event.Writer{W: &out}
+	for _, ev := range recorder.Events {
+		writer.Emit(ev)
+	}
+	return SimulationResult{Simulation: sim, Events: recorder.Events, Output: out.String(), Err: err}
+}
+
+// RunSimulations runs every simulation on its own clone of its environment,
+// using up to the number of workers goroutines (the number of CPUs when not
+// positive). The results are in the same order as the simulations.
+func RunSimulations(sims []Simulation, workers int) []SimulationResult {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	// cloning only reads the environments once their routing has converged
+	for _, sim := range sims {
+		sim.Env.ConvergeRouting(false)
+	}
+
+	results := make([]SimulationResult, len(sims))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = runSimulation(sims[i])
+			}
+		}()
+	}
+	for i := range sims {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,63 @@
+package simulator
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/arielril/network-simulator/internal/event"
+)
+
+func TestCloneIsolated(t *testing.T) {
+	env := loadExample(t, "example2.txt")
+	clone := env.Clone()
+	clone.SetSink(&event.Recorder{})
+	if err := Ping(clone, "n1", "n5", "hello"); err != nil {
+		t.Fatal(err)
+	}
+
+	original := env.(*environment)
+	for _, n := range original.nodes {
+		if len(n.arpTable) != 0 {
+			t.Errorf("The ping on the clone filled the ARP table of %v on the original", n.name)
+		}
+	}
+	for _, r := range original.routers {
+		if len(r.arpTable) != 0 {
+			t.Errorf("The ping on the clone filled the ARP table of %v on the original", r.name)
+		}
+	}
+
+	cloned := clone.(*environment)
+	cloned.routers[0].ports[0].down = true
+	cloned.routers[0].routerTable[0].port = 9
+	if original.routers[0].ports[0].down || original.routers[0].routerTable[0].port == 9 {
+		t.Error("Changing the ports and routes of the clone changed the original")
+	}
+}
+
+// Running the golden cases at the same time prints the same output as one by one
+func TestRunSimulations(t *testing.T) {
+	envs := make(map[string]Environment)
+	sims := make([]Simulation, len(goldenCases))
+	for i, tc := range goldenCases {
+		if _, loaded := envs[tc.topology]; !loaded {
+			envs[tc.topology] = loadExample(t, tc.topology)
+		}
+		sims[i] = Simulation{Env: envs[tc.topology], Src: tc.src, Dst: tc.dst, Msg: tc.msg}
+	}
+
+	for i, res := range RunSimulations(sims, 4) {
+		tc := goldenCases[i]
+		if res.Err != nil {
+			t.Fatalf("%v: %v", tc.name, res.Err)
+		}
+		content, err := os.ReadFile(filepath.Join(GOLDEN_DIR, tc.name+".txt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := diffLines(splitLines(string(content)), splitLines(res.Output)); diff != "" {
+			t.Errorf("%v differs from the golden file:\n%v", tc.name, diff)
+		}
+	}
+}
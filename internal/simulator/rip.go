@@ -0,0 +1,389 @@
+package simulator
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/arielril/network-simulator/internal/event"
+)
+
+// RIP v2 timers (in seconds) and constants
+const (
+	RIP_UPDATE_INTERVAL int = 30
+	// A learned route that is not refreshed for this long becomes unreachable
+	RIP_TIMEOUT int = 180
+	// An unreachable route ignores other next hops, and is deleted at the end
+	RIP_HOLDDOWN int = 180
+	// Delay of the triggered updates, sent when routes change
+	RIP_TRIGGERED_DELAY int = 1
+
+	RIP_INFINITY      uint32 = 16
+	RIP_MULTICAST_IP  string = "224.0.0.9"
+	RIP_MULTICAST_MAC MAC    = "01:00:5E:00:00:09"
+)
+
+type ripRoute struct {
+	netdest IP
+	// Address of the neighbor that advertised the route (0.0.0.0 when connected)
+	nexthop   IP
+	port      uint8
+	metric    uint32
+	connected bool
+	// Last time the route was advertised by its next hop
+	updated int
+	// Time the route became unreachable, while on hold-down
+	invalidAt int
+	holddown  bool
+	// The route changed since the last update
+	changed bool
+}
+
+// ripProcess is RIP running on the ports of a router
+type ripProcess struct {
+	router *router
+	// Ports RIP runs on
+	ports  []uint8
+	routes []*ripRoute
+	// A triggered update is scheduled
+	triggered bool
+}
+
+// rip is the RIP v2 domain, formed by the routers running it
+type rip struct {
+	env        *environment
+	procs      []*ripProcess
+	scheduler  *scheduler
+	emit       func(ev event.Event)
+	lastChange int
+}
+
+// EnableRip runs RIP on the ports of the router, or on all of them when none are given
+func (e *environment) EnableRip(routerName string, ports []uint8) error {
+	rt := e.GetRouterByName(routerName)
+	if rt == nil {
+		return fmt.Errorf("Unknown router %v", routerName)
+	}
+	if len(ports) == 0 {
+		for _, p := range rt.ports {
+			ports = append(ports, p.number)
+		}
+	}
+	for _, number := range ports {
+		if _, hasPort := rt.GetPortByNumber(number); !hasPort {
+			return fmt.Errorf("Router %v has no port %v", routerName, number)
+		}
+	}
+
+	var domain *rip
+	for _, p := range e.routing.protocols {
+		if d, isRip := p.(*rip); isRip {
+			domain = d
+		}
+	}
+	if domain == nil {
+		domain = &rip{env: e}
+		e.routing.protocols = append(e.routing.protocols, domain)
+	}
+	for _, proc := range domain.procs {
+		if proc.router == rt {
+			return fmt.Errorf("RIP is enabled more than once on router %v", routerName)
+		}
+	}
+	domain.procs = append(domain.procs, &ripProcess{router: rt, ports: ports})
+	e.routing.converged = false
+	return nil
+}
+
+func (p *ripProcess) runsOn(number uint8) bool {
+	for _, n := range p.ports {
+		if n == number {
+			port, _ := p.router.GetPortByNumber(number)
+			return !port.down
+		}
+	}
+	return false
+}
+
+func (p *ripProcess) findRoute(netdest IP) *ripRoute {
+	for _, rt := range p.routes {
+		if rt.netdest == netdest {
+			return rt
+		}
+	}
+	return nil
+}
+
+/*
+----------------------------------------------------
+Updates
+----------------------------------------------------
+*/
+
+type ripEntry struct {
+	netdest IP
+	metric  uint32
+}
+
+func (d *rip) start(s *scheduler, emit func(ev event.Event)) {
+	d.scheduler = s
+	d.emit = emit
+	for _, proc := range d.procs {
+		for _, number := range proc.ports {
+			port, _ := proc.router.GetPortByNumber(number)
+			proc.routes = append(proc.routes, &ripRoute{
+				netdest:   port.ip.Network(),
+				nexthop:   *NewIp("0.0.0.0"),
+				port:      number,
+				metric:    1,
+				connected: true,
+				changed:   true,
+			})
+		}
+		d.install(proc)
+	}
+
+	var periodic func(now int)
+	periodic = func(now int) {
+		for _, proc := range d.procs {
+			d.sendUpdate(proc, now, false)
+		}
+		s.after(RIP_UPDATE_INTERVAL, periodic)
+	}
+	s.at(0, periodic)
+}
+
+// neighbors returns the RIP processes with a port on the network of the port
+func (d *rip) neighbors(proc *ripProcess, port routerPort) []*ripProcess {
+	list := make([]*ripProcess, 0)
+	for _, other := range d.procs {
+		if other == proc {
+			continue
+		}
+		for _, number := range other.ports {
+			otherPort, _ := other.router.GetPortByNumber(number)
+			if other.runsOn(number) && port.ip.IsSameNet(otherPort.ip) && otherPort.ip.IsSameNet(port.ip) {
+				list = append(list, other)
+				break
+			}
+		}
+	}
+	return list
+}
+
+// sendUpdate sends the routes on every port, or only the changed ones on a
+// triggered update. Routes learned on a port are poisoned when sent back on it.
+func (d *rip) sendUpdate(proc *ripProcess, now int, onlyChanged bool) {
+	for _, number := range proc.ports {
+		if !proc.runsOn(number) {
+			continue
+		}
+		port, _ := proc.router.GetPortByNumber(number)
+
+		entries := make([]ripEntry, 0)
+		for _, rt := range proc.routes {
+			if onlyChanged && !rt.changed {
+				continue
+			}
+			metric := rt.metric
+			if !rt.connected && rt.port == number {
+				metric = RIP_INFINITY
+			}
+			entries = append(entries, ripEntry{netdest: rt.netdest, metric: metric})
+		}
+		if len(entries) == 0 {
+			continue
+		}
+
+		for _, neighbor := range d.neighbors(proc, port) {
+			d.emitUpdate(proc, port, neighbor, entries, now)
+			d.receive(neighbor, port, entries, now)
+		}
+	}
+
+	for _, rt := range proc.routes {
+		rt.changed = false
+	}
+}
+
+func (d *rip) emitUpdate(proc *ripProcess, port routerPort, neighbor *ripProcess, entries []ripEntry, now int) {
+	text := make([]string, len(entries))
+	for i, entry := range entries {
+		text[i] = fmt.Sprintf("%v metric=%v", entry.netdest.ToString(), entry.metric)
+	}
+	d.emit(event.Event{
+		Kind:   event.RIP_RESPONSE,
+		Src:    proc.router.name,
+		Dst:    neighbor.router.name,
+		SrcMac: string(port.mac),
+		DstMac: string(RIP_MULTICAST_MAC),
+		SrcIp:  port.ip.ip,
+		DstIp:  RIP_MULTICAST_IP,
+		Ttl:    1,
+		Data:   strings.Join(text, ", "),
+		Time:   now,
+	})
+}
+
+// receive processes the update sent by the neighbor port
+func (d *rip) receive(proc *ripProcess, from routerPort, entries []ripEntry, now int) {
+	var number uint8
+	found := false
+	for _, n := range proc.ports {
+		port, _ := proc.router.GetPortByNumber(n)
+		if proc.runsOn(n) && port.ip.IsSameNet(from.ip) {
+			number, found = n, true
+			break
+		}
+	}
+	if !found {
+		return
+	}
+	nexthop := *NewIp(from.ip.ip)
+
+	for _, entry := range entries {
+		metric := entry.metric + 1
+		if metric > RIP_INFINITY {
+			metric = RIP_INFINITY
+		}
+
+		rt := proc.findRoute(entry.netdest)
+		switch {
+		case rt == nil:
+			if metric < RIP_INFINITY {
+				proc.routes = append(proc.routes, &ripRoute{
+					netdest: entry.netdest,
+					nexthop: nexthop,
+					port:    number,
+					metric:  metric,
+					updated: now,
+				})
+				d.scheduleTimeout(proc, proc.routes[len(proc.routes)-1], now)
+				d.changed(proc, proc.routes[len(proc.routes)-1], now)
+			}
+		case rt.connected:
+			continue
+		case rt.nexthop == nexthop:
+			if metric >= RIP_INFINITY {
+				if !rt.holddown {
+					d.invalidate(proc, rt, now)
+				}
+				continue
+			}
+			rt.updated = now
+			d.scheduleTimeout(proc, rt, now)
+			if metric != rt.metric || rt.holddown {
+				rt.metric = metric
+				rt.holddown = false
+				d.changed(proc, rt, now)
+			}
+		case rt.holddown:
+			// other next hops are ignored while the route is on hold-down
+			continue
+		case metric < rt.metric:
+			rt.nexthop = nexthop
+			rt.port = number
+			rt.metric = metric
+			rt.updated = now
+			d.scheduleTimeout(proc, rt, now)
+			d.changed(proc, rt, now)
+		}
+	}
+}
+
+/*
+----------------------------------------------------
+Timers
+----------------------------------------------------
+*/
+
+func (d *rip) scheduleTimeout(proc *ripProcess, rt *ripRoute, updated int) {
+	d.scheduler.at(updated+RIP_TIMEOUT, func(now int) {
+		if !rt.holddown && !rt.connected && rt.updated == updated && proc.findRoute(rt.netdest) == rt {
+			d.invalidate(proc, rt, now)
+		}
+	})
+}
+
+// invalidate makes the route unreachable and puts it on hold-down, deleting it at the end
+func (d *rip) invalidate(proc *ripProcess, rt *ripRoute, now int) {
+	rt.metric = RIP_INFINITY
+	rt.holddown = true
+	rt.invalidAt = now
+	d.changed(proc, rt, now)
+
+	d.scheduler.at(now+RIP_HOLDDOWN, func(now int) {
+		if !rt.holddown || rt.invalidAt+RIP_HOLDDOWN != now {
+			return
+		}
+		routes := make([]*ripRoute, 0, len(proc.routes))
+		for _, other := range proc.routes {
+			if other != rt {
+				routes = append(routes, other)
+			}
+		}
+		proc.routes = routes
+		d.lastChange = now
+	})
+}
+
+// changed installs the routes and schedules a triggered update
+func (d *rip) changed(proc *ripProcess, rt *ripRoute, now int) {
+	rt.changed = true
+	d.lastChange = now
+	d.install(proc)
+
+	if proc.triggered {
+		return
+	}
+	proc.triggered = true
+	d.scheduler.at(now+RIP_TRIGGERED_DELAY, func(now int) {
+		proc.triggered = false
+		d.sendUpdate(proc, now, true)
+	})
+}
+
+func (d *rip) linkDown(s *scheduler, r *router, port uint8) {
+	for _, proc := range d.procs {
+		if proc.router != r {
+			continue
+		}
+		for _, rt := range proc.routes {
+			if rt.port == port && !rt.holddown {
+				d.invalidate(proc, rt, s.now)
+			}
+		}
+	}
+}
+
+// stable tells if no route changed on the last update interval, no route is on
+// hold-down and every learned route is being refreshed
+func (d *rip) stable(now int) bool {
+	if now < RIP_UPDATE_INTERVAL || now-d.lastChange < RIP_UPDATE_INTERVAL {
+		return false
+	}
+	for _, proc := range d.procs {
+		for _, rt := range proc.routes {
+			if rt.holddown || (!rt.connected && now-rt.updated > RIP_UPDATE_INTERVAL) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// install puts the reachable routes on the router table
+func (d *rip) install(proc *ripProcess) {
+	entries := make([]*routerTableEntry, 0, len(proc.routes))
+	for _, rt := range proc.routes {
+		if rt.metric >= RIP_INFINITY {
+			continue
+		}
+		entries = append(entries, &routerTableEntry{
+			netdest: rt.netdest,
+			nexthop: rt.nexthop,
+			port:    rt.port,
+			metric:  rt.metric,
+		})
+	}
+	proc.router.installRoutes(RIP_ROUTE, entries)
+}
@@ -0,0 +1,208 @@
+package simulator
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/arielril/network-simulator/internal/event"
+)
+
+// ripTriangle has three routers running RIP. n1 reaches n2 through r1 and r3,
+// or through r2 when the link between r1 and r3 is down.
+const ripTriangle = `
+#NODE
+n1,00:00:00:00:00:01,10.0.0.2/24,5,10.0.0.1
+n2,00:00:00:00:00:02,20.0.0.2/24,5,20.0.0.1
+#ROUTER
+r1,3,00:00:00:00:00:10,10.0.0.1/24,5,00:00:00:00:00:11,100.0.1.1/24,5,00:00:00:00:00:12,100.0.3.1/24,5
+r2,2,00:00:00:00:00:20,100.0.1.2/24,5,00:00:00:00:00:21,100.0.2.1/24,5
+r3,3,00:00:00:00:00:30,20.0.0.1/24,5,00:00:00:00:00:31,100.0.2.2/24,5,00:00:00:00:00:32,100.0.3.2/24,5
+#ROUTERTABLE
+#RIP
+r1
+r2
+r3
+`
+
+// routeTo returns the route of the router table to the network, skipping the
+// connected routes
+func routeTo(t *testing.T, env Environment, routerName, netdest string) *routerTableEntry {
+	t.Helper()
+	rt := env.(*environment).GetRouterByName(routerName)
+	for _, ent := range rt.routerTable {
+		if ent.source != CONNECTED_ROUTE && ent.netdest.ToString() == netdest {
+			return ent
+		}
+	}
+	return nil
+}
+
+func convergeRouting(t *testing.T, topology string, downs ...string) (Environment, []event.Event) {
+	t.Helper()
+
+	env, err := loadTopology(t, topology)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, down := range downs {
+		routerName, port, at, err := ParseLinkDown(down)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := env.SetLinkDown(routerName, port, at); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	recorder := &event.Recorder{}
+	env.SetSink(recorder)
+	env.ConvergeRouting(true)
+	return env, recorder.Events
+}
+
+func TestRipConvergence(t *testing.T) {
+	env, _ := convergeRouting(t, ripTriangle)
+
+	for _, tc := range []struct {
+		router, netdest, nexthop string
+		port                     uint8
+		metric                   uint32
+	}{
+		{"r1", "10.0.0.0/24", "0.0.0.0", 0, 1},
+		{"r1", "20.0.0.0/24", "100.0.3.2", 2, 2},
+		{"r1", "100.0.2.0/24", "100.0.1.2", 1, 2},
+		{"r2", "10.0.0.0/24", "100.0.1.1", 0, 2},
+		{"r3", "10.0.0.0/24", "100.0.3.1", 2, 2},
+	} {
+		ent := routeTo(t, env, tc.router, tc.netdest)
+		if ent == nil {
+			t.Errorf("%v has no route to %v", tc.router, tc.netdest)
+			continue
+		}
+		if ent.source != RIP_ROUTE || ent.nexthop.ip != tc.nexthop || ent.port != tc.port || ent.metric != tc.metric {
+			t.Errorf(
+				"%v to %v: want via %v port %v metric %v, got via %v port %v metric %v",
+				tc.router, tc.netdest, tc.nexthop, tc.port, tc.metric, ent.nexthop.ip, ent.port, ent.metric,
+			)
+		}
+	}
+
+	env.SetSink(&event.Recorder{})
+	if err := Ping(env, "n1", "n2", "hello"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRipPoisonedReverse(t *testing.T) {
+	_, events := convergeRouting(t, ripTriangle)
+
+	// r2 learned 10.0.0.0/24 from r1, so it must advertise it back as unreachable
+	for _, ev := range events {
+		if ev.Kind != event.RIP_RESPONSE || ev.Src != "r2" || ev.Dst != "r1" || ev.Time != RIP_UPDATE_INTERVAL {
+			continue
+		}
+		if !strings.Contains(ev.Data, "10.0.0.0/24 metric=16") || !strings.Contains(ev.Data, "100.0.2.0/24 metric=1") {
+			t.Errorf("unexpected update of r2 to r1: %v", ev.Data)
+		}
+		return
+	}
+	t.Error("r2 sent no periodic update to r1")
+}
+
+func TestRipLinkDown(t *testing.T) {
+	env, events := convergeRouting(t, ripTriangle, "r1:2")
+
+	// r1 poisons the routes of its port with a triggered update, and reroutes
+	// through r2 on its next periodic update
+	ent := routeTo(t, env, "r1", "20.0.0.0/24")
+	if ent == nil || ent.nexthop.ip != "100.0.1.2" || ent.metric != 3 {
+		t.Errorf("expected r1 to reach 20.0.0.0/24 through r2, got %+v", ent)
+	}
+
+	// r3 only notices when the route times out, and keeps it on hold-down
+	var down, poisoned, timeout, learned int
+	for _, ev := range events {
+		switch {
+		case ev.Kind == event.LINK_DOWN:
+			down = ev.Time
+		case ev.Kind == event.RIP_RESPONSE && ev.Src == "r1" && ev.Dst == "r2" && strings.Contains(ev.Data, "20.0.0.0/24 metric=16") && poisoned == 0:
+			poisoned = ev.Time
+		case ev.Kind == event.RIP_RESPONSE && ev.Src == "r3" && strings.Contains(ev.Data, "10.0.0.0/24 metric=16") && timeout == 0 && ev.Dst == "r2":
+			timeout = ev.Time
+		case ev.Kind == event.RIP_RESPONSE && ev.Src == "r2" && ev.Dst == "r3" && strings.Contains(ev.Data, "10.0.0.0/24 metric=2"):
+			learned = ev.Time
+		}
+	}
+	if poisoned != down+RIP_TRIGGERED_DELAY {
+		t.Errorf("expected r1 to poison the route %vs after the link went down at %vs, got %vs", RIP_TRIGGERED_DELAY, down, poisoned)
+	}
+	if timeout != down+RIP_TIMEOUT {
+		t.Errorf("expected r3 to time out the route %vs after the link went down at %vs, got %vs", RIP_TIMEOUT, down, timeout)
+	}
+	ent = routeTo(t, env, "r3", "10.0.0.0/24")
+	if ent == nil || ent.nexthop.ip != "100.0.2.1" || learned < timeout+RIP_HOLDDOWN {
+		t.Errorf("expected r3 to learn the route through r2 after the hold-down, got %+v", ent)
+	}
+
+	env.SetSink(&event.Recorder{})
+	if err := Ping(env, "n1", "n2", "hello"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// A link taken down at convergence is also taken down without routing protocols
+func TestStaticLinkDown(t *testing.T) {
+	env, events := convergeRouting(t, twoNetworks, "r1:1")
+	if len(events) != 1 || events[0].Kind != event.LINK_DOWN || events[0].Src != "r1" || events[0].Data != "1" {
+		t.Errorf("expected the link of r1:1 to go down, got %v", events)
+	}
+
+	env.SetSink(&event.Recorder{})
+	var routeErr *NoRouteError
+	if err := Ping(env, "n1", "n2", "hello"); !errors.As(err, &routeErr) {
+		t.Errorf("expected no route to n2, got %v", err)
+	}
+}
+
+// ripDefault has a static default route on r1 towards r3, which sends the
+// packets back, and RIP routes between r1 and r2, that reach n2
+const ripDefault = `
+#NODE
+n1,00:00:00:00:00:01,10.0.0.2/24,5,10.0.0.1
+n2,00:00:00:00:00:02,20.0.0.2/24,5,20.0.0.1
+#ROUTER
+r1,3,00:00:00:00:00:10,10.0.0.1/24,5,00:00:00:00:00:11,100.0.1.1/24,5,00:00:00:00:00:12,100.0.3.1/24,5
+r2,2,00:00:00:00:00:20,100.0.1.2/24,5,00:00:00:00:00:21,20.0.0.1/24,5
+r3,1,00:00:00:00:00:30,100.0.3.2/24,5
+#ROUTERTABLE
+r1,0.0.0.0/0,100.0.3.2,2
+r3,0.0.0.0/0,100.0.3.1,0
+#RIP
+r1,0,1
+r2
+`
+
+// The learned route to the network of n2 wins over the preferred static
+// default route, as its prefix is longer
+func TestLongestPrefixMatch(t *testing.T) {
+	env, _ := convergeRouting(t, ripDefault)
+	rec := &event.Recorder{}
+	env.SetSink(rec)
+	if err := Ping(env, "n1", "n2", "hello"); err != nil {
+		t.Fatal(err)
+	}
+
+	path := make([]string, 0)
+	for _, ev := range rec.Events {
+		if ev.Kind == event.ECHO_REQUEST {
+			path = append(path, ev.Src+" "+ev.Dst)
+		}
+		if ev.Kind == event.TIME_EXCEEDED {
+			t.Fatalf("Unexpected time exceeded from %v", ev.Src)
+		}
+	}
+	if diff := diffLines([]string{"n1 r1", "r1 r2", "r2 n2"}, path); diff != "" {
+		t.Errorf("Unexpected path of the echo request:\n%v", diff)
+	}
+}
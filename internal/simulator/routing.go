@@ -0,0 +1,382 @@
+package simulator
+
+import (
+	"container/heap"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/arielril/network-simulator/internal/event"
+	"github.com/urfave/cli"
+)
+
+// routeSource is the protocol that installed a route on the router table
+type routeSource uint8
+
+const (
+	STATIC_ROUTE routeSource = iota
+	RIP_ROUTE
+	OSPF_ROUTE
+	EBGP_ROUTE
+	IBGP_ROUTE
+	// Networks of the router ports, derived from their IP and prefix
+	CONNECTED_ROUTE
+)
+
+// preference of the routes of each protocol when more than one reaches the
+// same network, lower first (the administrative distance of the protocols)
+var routePreference = map[routeSource]int{
+	CONNECTED_ROUTE: 0,
+	STATIC_ROUTE:    1,
+	EBGP_ROUTE:      20,
+	OSPF_ROUTE:      110,
+	RIP_ROUTE:       120,
+	IBGP_ROUTE:      200,
+}
+
+func (s routeSource) String() string {
+	switch s {
+	case CONNECTED_ROUTE:
+		return "connected"
+	case RIP_ROUTE:
+		return "rip"
+	case OSPF_ROUTE:
+		return "ospf"
+	case EBGP_ROUTE:
+		return "ebgp"
+	case IBGP_ROUTE:
+		return "ibgp"
+	}
+	return "static"
+}
+
+// Limits of the simulation of the routing protocols, in seconds
+const (
+	// The protocols are stopped if they do not converge before it
+	ROUTING_MAX_TIME int = 3600
+)
+
+/*
+----------------------------------------------------
+Event scheduler
+----------------------------------------------------
+*/
+
+type scheduledTask struct {
+	at  int
+	seq int
+	run func(now int)
+}
+
+// taskQueue orders the tasks by time, and by the order they were scheduled
+type taskQueue []*scheduledTask
+
+func (q taskQueue) Len() int { return len(q) }
+func (q taskQueue) Less(i, j int) bool {
+	if q[i].at != q[j].at {
+		return q[i].at < q[j].at
+	}
+	return q[i].seq < q[j].seq
+}
+func (q taskQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *taskQueue) Push(x interface{}) { *q = append(*q, x.(*scheduledTask)) }
+func (q *taskQueue) Pop() interface{} {
+	old := *q
+	task := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return task
+}
+
+// scheduler runs the tasks of the routing protocols on a simulated clock
+type scheduler struct {
+	queue taskQueue
+	seq   int
+	now   int
+}
+
+func (s *scheduler) at(t int, run func(now int)) {
+	s.seq++
+	heap.Push(&s.queue, &scheduledTask{at: t, seq: s.seq, run: run})
+}
+
+func (s *scheduler) after(delay int, run func(now int)) {
+	s.at(s.now+delay, run)
+}
+
+// step runs every task of the next instant and tells if there was any
+func (s *scheduler) step() bool {
+	if len(s.queue) == 0 {
+		return false
+	}
+	s.now = s.queue[0].at
+	for len(s.queue) > 0 && s.queue[0].at == s.now {
+		task := heap.Pop(&s.queue).(*scheduledTask)
+		task.run(s.now)
+	}
+	return true
+}
+
+/*
+----------------------------------------------------
+Routing protocols
+----------------------------------------------------
+*/
+
+// routingProtocol is a dynamic routing protocol running on some routers
+type routingProtocol interface {
+	start(s *scheduler, emit func(ev event.Event))
+	// linkDown tells the protocol the port of the router went down
+	linkDown(s *scheduler, r *router, port uint8)
+	// stable tells if the protocol converged at the time
+	stable(now int) bool
+}
+
+// linkFailure is a router port going down. Without a time, it goes down once
+// the protocols converge.
+type linkFailure struct {
+	router *router
+	port   uint8
+	at     int
+}
+
+type routing struct {
+	protocols []routingProtocol
+	failures  []linkFailure
+	converged bool
+}
+
+// ParseLinkDown reads a link failure as router:port, or router:port@seconds
+func ParseLinkDown(value string) (string, uint8, int, error) {
+	at := -1
+	spec := value
+	if idx := strings.Index(spec, "@"); idx >= 0 {
+		if _, err := fmt.Sscanf(spec[idx+1:], "%d", &at); err != nil || at < 0 {
+			return "", 0, 0, fmt.Errorf("Invalid time of the link failure %v", value)
+		}
+		spec = spec[:idx]
+	}
+
+	idx := strings.LastIndex(spec, ":")
+	var port uint8
+	if idx <= 0 {
+		return "", 0, 0, fmt.Errorf("Invalid link failure %v, expected router:port[@seconds]", value)
+	}
+	if _, err := fmt.Sscanf(spec[idx+1:], "%d", &port); err != nil {
+		return "", 0, 0, fmt.Errorf("Invalid port of the link failure %v", value)
+	}
+	return spec[:idx], port, at, nil
+}
+
+// SetLinkDown takes the link of the router port down at the time, in seconds
+// since the start of the routing protocols. A negative time takes it down once
+// the protocols converge.
+func (e *environment) SetLinkDown(routerName string, port uint8, at int) error {
+	rt := e.GetRouterByName(routerName)
+	if rt == nil {
+		return &UsageError{Msg: fmt.Sprintf("Unknown router %v", routerName)}
+	}
+	if _, hasPort := rt.GetPortByNumber(port); !hasPort {
+		return &UsageError{Msg: fmt.Sprintf("Router %v has no port %v", routerName, port)}
+	}
+	e.routing.failures = append(e.routing.failures, linkFailure{router: rt, port: port, at: at})
+	e.routing.converged = false
+	return nil
+}
+
+// SetLinkFailures takes down the links given as router:port[@seconds]
+func SetLinkFailures(env Environment, values []string) error {
+	for _, value := range values {
+		routerName, port, at, err := ParseLinkDown(value)
+		if err != nil {
+			return &UsageError{Msg: err.Error()}
+		}
+		if err := env.SetLinkDown(routerName, port, at); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *environment) applyLinkFailure(s *scheduler, f linkFailure, emit func(ev event.Event)) {
+	for i := range f.router.ports {
+		if f.router.ports[i].number == f.port {
+			f.router.ports[i].down = true
+		}
+	}
+	emit(event.Event{
+		Kind: event.LINK_DOWN,
+		Src:  f.router.name,
+		Dst:  f.router.name,
+		Data: fmt.Sprintf("%v", f.port),
+		Time: s.now,
+	})
+	for _, p := range e.routing.protocols {
+		p.linkDown(s, f.router, f.port)
+	}
+}
+
+// ConvergeRouting runs the routing protocols until their routes are stable,
+// applying the link failures. The exchanges of the protocols are sent to the
+// sink when shown.
+func (e *environment) ConvergeRouting(show bool) {
+	if e.routing.converged {
+		return
+	}
+	e.routing.converged = true
+	if len(e.routing.protocols) == 0 && len(e.routing.failures) == 0 {
+		return
+	}
+
+	emit := func(ev event.Event) {
+		if show {
+			e.Emit(ev)
+		}
+	}
+
+	s := &scheduler{}
+	for _, p := range e.routing.protocols {
+		p.start(s, emit)
+	}
+
+	lastFailure := 0
+	pending := make([]linkFailure, 0)
+	for _, f := range e.routing.failures {
+		f := f
+		if f.at < 0 {
+			pending = append(pending, f)
+			continue
+		}
+		if f.at > lastFailure {
+			lastFailure = f.at
+		}
+		s.at(f.at, func(now int) { e.applyLinkFailure(s, f, emit) })
+	}
+
+	for s.step() && s.now <= ROUTING_MAX_TIME {
+		if s.now < lastFailure || !e.routingStable(s.now) {
+			continue
+		}
+		if len(pending) == 0 {
+			break
+		}
+		for _, f := range pending {
+			e.applyLinkFailure(s, f, emit)
+		}
+		pending = pending[:0]
+	}
+
+	// without protocols, or when they never settle, the queue ends before the
+	// failures at convergence are applied
+	for _, f := range pending {
+		e.applyLinkFailure(s, f, emit)
+	}
+}
+
+func (e *environment) routingStable(now int) bool {
+	for _, p := range e.routing.protocols {
+		if !p.stable(now) {
+			return false
+		}
+	}
+	return true
+}
+
+// installRoutes replaces the routes of the protocol on the router table. The
+// table is kept ordered by the preference of the protocols, so between routes
+// to networks of the same prefix the static ones win.
+func (r *router) installRoutes(source routeSource, entries []*routerTableEntry) {
+	table := make([]*routerTableEntry, 0, len(r.routerTable)+len(entries))
+	for _, ent := range r.routerTable {
+		if ent.source != source {
+			table = append(table, ent)
+		}
+	}
+	for _, ent := range entries {
+		ent.source = source
+		table = append(table, ent)
+	}
+	sort.SliceStable(table, func(i, j int) bool {
+		return routePreference[table[i].source] < routePreference[table[j].source]
+	})
+	r.routerTable = table
+}
+
+/*
+----------------------------------------------------
+Router table dump
+----------------------------------------------------
+*/
+
+// Warnings returns the suspicious lines of the topology, as the static routes
+// that duplicate connected ones
+func (e *environment) Warnings() []string {
+	msgs := make([]string, len(e.warnings))
+	for i, warning := range e.warnings {
+		msgs[i] = warning.Error()
+	}
+	return msgs
+}
+
+// PrintWarnings writes the warnings of the topology to the standard error
+func PrintWarnings(env Environment) {
+	for _, msg := range env.Warnings() {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", msg)
+	}
+}
+
+// WriteRoutes prints the router table of the router, or of every router when
+// no name is given, with the source of each route
+func (e *environment) WriteRoutes(w io.Writer, routerName string) error {
+	routers := e.routers
+	if routerName != "" {
+		rt := e.GetRouterByName(routerName)
+		if rt == nil {
+			return &UsageError{Msg: fmt.Sprintf("Unknown router %v", routerName)}
+		}
+		routers = []*router{rt}
+	}
+
+	for i, rt := range routers {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "Router %v\n", rt.name)
+		for _, ent := range rt.routerTable {
+			fmt.Fprintf(w, "  %-9v %v via %v port %v", ent.source, ent.netdest.ToString(), ent.nexthop.ip, ent.port)
+			if ent.source != CONNECTED_ROUTE && ent.source != STATIC_ROUTE {
+				fmt.Fprintf(w, " metric=%v", ent.metric)
+			}
+			if port, _ := rt.GetPortByNumber(ent.port); port.down {
+				fmt.Fprint(w, " (down)")
+			}
+			fmt.Fprintln(w)
+		}
+	}
+	return nil
+}
+
+// Routes runs the routing protocols of the topology and prints the router tables
+func Routes(ctx *cli.Context) error {
+	args := ctx.Args()
+	if len(args) < 1 || len(args) > 2 {
+		return &UsageError{Msg: "Invalid routes arguments"}
+	}
+
+	env, err := LoadEnvironment(args.Get(0))
+	if err != nil {
+		return err
+	}
+	PrintWarnings(env)
+	if err := SetLinkFailures(env, ctx.StringSlice("down")); err != nil {
+		return err
+	}
+
+	env.SetSink(event.Writer{W: os.Stdout})
+	env.ConvergeRouting(ctx.Bool("show-routing"))
+	if ctx.Bool("show-routing") {
+		fmt.Println()
+	}
+	return env.WriteRoutes(os.Stdout, args.Get(1))
+}
@@ -0,0 +1,597 @@
+package simulator
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/arielril/network-simulator/internal/event"
+)
+
+// Seconds that a switch keeps a MAC address it learned without seeing it again.
+// The messages sent on an environment are one second apart.
+const SWITCH_AGING int = 300
+
+// VLANs of the switch ports
+const (
+	// VLAN of the access ports without one, and native VLAN of the trunks,
+	// whose frames are not tagged
+	DEFAULT_VLAN uint16 = 1
+	MAX_VLAN     uint16 = 4094
+	// A trunk port, as trunk or trunk=10;20
+	TRUNK string = "trunk"
+)
+
+/*
+----------------------------------------------------
+Switch implementation
+----------------------------------------------------
+*/
+
+// switchPort is what is plugged on a port of a switch: the interface of a node,
+// the port of a router or the port of another switch
+type switchPort struct {
+	// Node or router, with the number of the router port, and the MAC of its
+	// interface
+	device string
+	router bool
+	number uint8
+	mac    MAC
+	// Switch on the other end of the link, and its port
+	peer     string
+	peerPort uint8
+	// A trunk carries the frames of the VLANs listed, or of all of them when
+	// none is, tagged but for the native VLAN. An access port carries the
+	// frames of its VLAN, not tagged.
+	trunk bool
+	vlans []uint16
+	vlan  uint16
+}
+
+func (p switchPort) empty() bool {
+	return p.device == "" && p.peer == ""
+}
+
+// receiver returns the name of the device or switch that gets the frames sent
+// on the port
+func (p switchPort) receiver() string {
+	if p.peer != "" {
+		return p.peer
+	}
+	return p.device
+}
+
+// mode returns the VLANs of the port as they are written on the topology,
+// empty for an access port of the default VLAN
+func (p switchPort) mode() string {
+	if !p.trunk {
+		if p.vlan == DEFAULT_VLAN {
+			return ""
+		}
+		return fmt.Sprint(p.vlan)
+	}
+	if len(p.vlans) == 0 {
+		return TRUNK
+	}
+	vlans := make([]string, len(p.vlans))
+	for i, vlan := range p.vlans {
+		vlans[i] = fmt.Sprint(vlan)
+	}
+	return TRUNK + "=" + strings.Join(vlans, ";")
+}
+
+// String returns the port as it is written on the topology
+func (p switchPort) String() string {
+	text := p.device
+	switch {
+	case p.peer != "":
+		text = fmt.Sprintf("%v:%v", p.peer, p.peerPort)
+	case p.router:
+		text = fmt.Sprintf("%v:%v", p.device, p.number)
+	}
+	if mode := p.mode(); mode != "" {
+		text += "@" + mode
+	}
+	return text
+}
+
+// carries tells if the frames of the VLAN go through the port
+func (p switchPort) carries(vlan uint16) bool {
+	if !p.trunk {
+		return vlan == p.vlan
+	}
+	if len(p.vlans) == 0 {
+		return true
+	}
+	for _, v := range p.vlans {
+		if v == vlan {
+			return true
+		}
+	}
+	return false
+}
+
+// classify returns the VLAN of a frame received on the port with the tag, and
+// if the port accepts it
+func (p switchPort) classify(tag uint16) (uint16, bool) {
+	if !p.trunk {
+		return p.vlan, tag == 0
+	}
+	vlan := tag
+	if tag == 0 {
+		vlan = DEFAULT_VLAN
+	}
+	return vlan, p.carries(vlan)
+}
+
+// tag returns the tag of the frames of the VLAN sent on the port
+func (p switchPort) tag(vlan uint16) uint16 {
+	if !p.trunk || vlan == DEFAULT_VLAN {
+		return 0
+	}
+	return vlan
+}
+
+// parseVlan reads a VLAN number, from 1 up to MAX_VLAN
+func parseVlan(value string) (uint16, error) {
+	vlan, err := strconv.ParseUint(value, 10, 16)
+	if err != nil || vlan == 0 || vlan > uint64(MAX_VLAN) {
+		return 0, fmt.Errorf("Invalid VLAN %v, expected a number from 1 to %v", value, MAX_VLAN)
+	}
+	return uint16(vlan), nil
+}
+
+// parsePortMode reads the VLANs of a switch port: the VLAN of an access port,
+// as 10, or a trunk, as trunk for every VLAN or trunk=10;20 for some of them.
+// An empty mode is an access port of the default VLAN.
+func parsePortMode(value string) (switchPort, error) {
+	p := switchPort{vlan: DEFAULT_VLAN}
+	spec := strings.SplitN(value, "=", 2)
+	switch {
+	case value == "":
+	case strings.EqualFold(spec[0], TRUNK):
+		p.trunk = true
+		if len(spec) == 1 {
+			break
+		}
+		for _, field := range strings.Split(spec[1], ";") {
+			vlan, err := parseVlan(strings.TrimSpace(field))
+			if err != nil {
+				return p, err
+			}
+			p.vlans = append(p.vlans, vlan)
+		}
+	default:
+		vlan, err := parseVlan(value)
+		if err != nil {
+			return p, fmt.Errorf("Invalid port mode %v, expected a VLAN, %v or %v=<vlan>;<vlan>", value, TRUNK, TRUNK)
+		}
+		p.vlan = vlan
+	}
+	return p, nil
+}
+
+// withMode returns the port with the VLANs of the other
+func (p switchPort) withMode(mode switchPort) switchPort {
+	p.trunk, p.vlans, p.vlan = mode.trunk, mode.vlans, mode.vlan
+	return p
+}
+
+// parseSubInterface reads the MAC field of a router port, followed by the VLAN
+// of its frames when the port is a sub-interface, as 00:00:00:00:00:01.10
+func parseSubInterface(value string) (string, uint16, error) {
+	spec := strings.SplitN(value, ".", 2)
+	if len(spec) == 1 {
+		return value, 0, nil
+	}
+	vlan, err := parseVlan(spec[1])
+	if err != nil {
+		return "", 0, err
+	}
+	if vlan == DEFAULT_VLAN {
+		return "", 0, fmt.Errorf("The sub-interface can not be on the native VLAN %v, whose frames are not tagged", DEFAULT_VLAN)
+	}
+	return spec[0], vlan, nil
+}
+
+// macField returns the MAC of the router port as it is written on the topology,
+// followed by the VLAN of a sub-interface
+func (p routerPort) macField() string {
+	if p.vlan == 0 {
+		return string(p.mac)
+	}
+	return fmt.Sprintf("%v.%v", p.mac, p.vlan)
+}
+
+// macKey is a MAC on a VLAN, as each VLAN has its own MAC table
+type macKey struct {
+	vlan uint16
+	mac  MAC
+}
+
+// macEntry is the port where a switch last saw a MAC, and when
+type macEntry struct {
+	port uint8
+	seen int
+}
+
+type netSwitch struct {
+	name  string
+	ports []switchPort
+	aging int
+	// Ports of the source MACs of the frames received, by VLAN
+	macTable map[macKey]macEntry
+}
+
+func newSwitch(name string, ports int, aging int) *netSwitch {
+	return &netSwitch{
+		name:     name,
+		ports:    make([]switchPort, ports),
+		aging:    aging,
+		macTable: make(map[macKey]macEntry),
+	}
+}
+
+func (s *netSwitch) clone() *netSwitch {
+	c := *s
+	c.ports = append([]switchPort{}, s.ports...)
+	c.macTable = make(map[macKey]macEntry, len(s.macTable))
+	for key, ent := range s.macTable {
+		c.macTable[key] = ent
+	}
+	return &c
+}
+
+func (s *netSwitch) learn(vlan uint16, mac MAC, port uint8, now int) {
+	s.macTable[macKey{vlan: vlan, mac: mac}] = macEntry{port: port, seen: now}
+}
+
+// lookup returns the port of the MAC on the VLAN, forgetting it once it is
+// older than the aging of the switch
+func (s *netSwitch) lookup(vlan uint16, mac MAC, now int) (uint8, bool) {
+	key := macKey{vlan: vlan, mac: mac}
+	ent, known := s.macTable[key]
+	if !known {
+		return 0, false
+	}
+	if now-ent.seen >= s.aging {
+		delete(s.macTable, key)
+		return 0, false
+	}
+	return ent.port, true
+}
+
+// switchEnd is a port of a switch
+type switchEnd struct {
+	sw   *netSwitch
+	port uint8
+}
+
+func (idx *componentIndex) addSwitch(s *netSwitch) {
+	idx.switches[strings.ToLower(s.name)] = s
+	for number, p := range s.ports {
+		if p.device != "" {
+			idx.attached[p.mac] = switchEnd{sw: s, port: uint8(number)}
+		}
+	}
+}
+
+/*
+----------------------------------------------------
+Switches of the environment
+----------------------------------------------------
+*/
+
+func (e *environment) getSwitch(name string) *netSwitch {
+	return e.index.switches[strings.ToLower(name)]
+}
+
+// AddSwitch adds a switch with the number of ports, none of them connected, that
+// forgets the MACs after the aging, in seconds
+func (e *environment) AddSwitch(name string, ports int, aging int) error {
+	if e.GetNetComponentByName(name) != nil || e.getSwitch(name) != nil {
+		return fmt.Errorf("The name %v is already used by another device", name)
+	}
+	if ports < 1 || ports > 255 {
+		return fmt.Errorf("Invalid number of ports %v", ports)
+	}
+	if aging < 1 {
+		return fmt.Errorf("Invalid aging %v, expected a number of seconds", aging)
+	}
+	sw := newSwitch(name, ports, aging)
+	e.switches = append(e.switches, sw)
+	e.index.addSwitch(sw)
+	return nil
+}
+
+// linked tells if the frames of a switch reach the other through the links
+// between switches
+func (e *environment) linked(from, to *netSwitch) bool {
+	visited := map[*netSwitch]bool{from: true}
+	queue := []*netSwitch{from}
+	for len(queue) > 0 {
+		sw := queue[0]
+		queue = queue[1:]
+		if sw == to {
+			return true
+		}
+		for _, p := range sw.ports {
+			if peer := e.getSwitch(p.peer); peer != nil && !visited[peer] {
+				visited[peer] = true
+				queue = append(queue, peer)
+			}
+		}
+	}
+	return false
+}
+
+// AttachSwitchPort connects the port of the switch to a node, as n1, to the
+// port of a router, as r1:0, or to the port of another switch, as sw2:0,
+// followed by the VLANs of the port, as n1@10 or sw2:0@trunk
+func (e *environment) AttachSwitchPort(switchName string, port int, device string) error {
+	sw := e.getSwitch(switchName)
+	if sw == nil {
+		return fmt.Errorf("Unknown switch %v", switchName)
+	}
+	if port < 0 || port >= len(sw.ports) {
+		return fmt.Errorf("Switch %v has no port %v", sw.name, port)
+	}
+	mode := ""
+	if sep := strings.Index(device, "@"); sep >= 0 {
+		device, mode = device[:sep], device[sep+1:]
+	}
+	vlans, err := parsePortMode(mode)
+	if err != nil {
+		return err
+	}
+
+	name, number, hasNumber := device, uint64(0), false
+	if sep := strings.LastIndex(device, ":"); sep >= 0 {
+		name = device[:sep]
+		if number, err = strconv.ParseUint(device[sep+1:], 10, 8); err != nil {
+			return fmt.Errorf("Invalid port %v", device[sep+1:])
+		}
+		hasNumber = true
+	}
+
+	var link switchPort
+	if peer := e.getSwitch(name); peer != nil {
+		if !hasNumber {
+			return fmt.Errorf("Expected the port of switch %v, as %v:0", peer.name, peer.name)
+		}
+		if peer == sw {
+			return fmt.Errorf("Switch %v can not be linked to itself", sw.name)
+		}
+		if int(number) >= len(peer.ports) {
+			return fmt.Errorf("Switch %v has no port %v", peer.name, number)
+		}
+		link = switchPort{peer: peer.name, peerPort: uint8(number)}.withMode(vlans)
+		back := switchPort{peer: sw.name, peerPort: uint8(port)}.withMode(vlans)
+		if sw.ports[port].peer == link.peer && sw.ports[port].peerPort == link.peerPort &&
+			peer.ports[number].peer == back.peer && peer.ports[number].peerPort == back.peerPort {
+			// the link was declared by the other switch, whose VLANs are kept
+			// unless this end sets its own
+			if mode != "" {
+				sw.ports[port] = link
+			}
+			return nil
+		}
+		if !peer.ports[number].empty() {
+			return fmt.Errorf("Port %v of switch %v is already connected to %v", number, peer.name, peer.ports[number])
+		}
+		if e.linked(sw, peer) {
+			return fmt.Errorf("The link between switches %v and %v makes a loop, where the frames are flooded forever", sw.name, peer.name)
+		}
+		if !sw.ports[port].empty() {
+			return fmt.Errorf("Port %v of switch %v is already connected to %v", port, sw.name, sw.ports[port])
+		}
+		sw.ports[port] = link
+		peer.ports[number] = back
+		return nil
+	}
+
+	switch comp := e.GetNetComponentByName(name).(type) {
+	case *node:
+		if hasNumber {
+			return fmt.Errorf("Expected the node %v without a port", comp.name)
+		}
+		link = switchPort{device: comp.name, mac: comp.netPort.mac}.withMode(vlans)
+	case *router:
+		if !hasNumber {
+			return fmt.Errorf("Expected the port of router %v, as %v:0", comp.name, comp.name)
+		}
+		rtPort, hasPort := comp.GetPortByNumber(uint8(number))
+		if !hasPort {
+			return fmt.Errorf("Router %v has no port %v", comp.name, number)
+		}
+		link = switchPort{device: comp.name, router: true, number: rtPort.number, mac: rtPort.mac}.withMode(vlans)
+	default:
+		return fmt.Errorf("Unknown device %v", name)
+	}
+
+	if end, attached := e.index.attached[link.mac]; attached {
+		return fmt.Errorf("%v is already connected to port %v of switch %v", link, end.port, end.sw.name)
+	}
+	if !sw.ports[port].empty() {
+		return fmt.Errorf("Port %v of switch %v is already connected to %v", port, sw.name, sw.ports[port])
+	}
+	sw.ports[port] = link
+	e.index.attached[link.mac] = switchEnd{sw: sw, port: uint8(port)}
+	return nil
+}
+
+// parseSwitch reads the switch, what is connected to each of its ports, empty
+// for the ports left unused, and its aging, given as aging=seconds after the
+// ports
+func parseSwitch(line string) (string, []string, int, error) {
+	l := splitFields(line)
+	if len(l) < 2 {
+		return "", nil, 0, fmt.Errorf("Expected the name and number of ports of the switch")
+	}
+	numPorts, err := strconv.ParseUint(l[1], 10, 8)
+	if err != nil || numPorts == 0 {
+		return "", nil, 0, fmt.Errorf("Invalid number of ports %v", l[1])
+	}
+
+	aging := SWITCH_AGING
+	if len(l) == 3+int(numPorts) {
+		spec := strings.SplitN(l[len(l)-1], "=", 2)
+		if len(spec) != 2 || spec[0] != "aging" {
+			return "", nil, 0, fmt.Errorf("Invalid switch option %v, expected aging=seconds", l[len(l)-1])
+		}
+		value, err := strconv.ParseUint(spec[1], 10, 32)
+		if err != nil || value == 0 {
+			return "", nil, 0, fmt.Errorf("Invalid aging %v, expected a number of seconds", spec[1])
+		}
+		aging = int(value)
+		l = l[:len(l)-1]
+	}
+	if len(l) != 2+int(numPorts) {
+		return "", nil, 0, fmt.Errorf("Expected %v fields for %v ports, found %v", 2+numPorts, numPorts, len(l))
+	}
+	return l[0], l[2:], aging, nil
+}
+
+/*
+----------------------------------------------------
+Frame switching
+----------------------------------------------------
+*/
+
+// arrival returns the VLAN tag that a frame sent by the interface with the tag
+// has when it reaches the interface with the other MAC, and if it does. The
+// interfaces connected to no switch share a link, where the tags are kept.
+func (e *environment) arrival(from MAC, tag uint16, to MAC) (uint16, bool) {
+	src, srcSwitched := e.index.attached[from]
+	dst, dstSwitched := e.index.attached[to]
+	if !srcSwitched || !dstSwitched {
+		return tag, !srcSwitched && !dstSwitched
+	}
+	vlan, accepted := src.sw.ports[src.port].classify(tag)
+	if !accepted {
+		return 0, false
+	}
+
+	type hop struct {
+		sw   *netSwitch
+		vlan uint16
+	}
+	visited := map[hop]bool{{src.sw, vlan}: true}
+	queue := []hop{{src.sw, vlan}}
+	for len(queue) > 0 {
+		h := queue[0]
+		queue = queue[1:]
+		if h.sw == dst.sw {
+			out := dst.sw.ports[dst.port]
+			return out.tag(h.vlan), out.carries(h.vlan)
+		}
+		for _, p := range h.sw.ports {
+			peer := e.getSwitch(p.peer)
+			if peer == nil || !p.carries(h.vlan) {
+				continue
+			}
+			next, accepted := peer.ports[p.peerPort].classify(p.tag(h.vlan))
+			if accepted && !visited[hop{peer, next}] {
+				visited[hop{peer, next}] = true
+				queue = append(queue, hop{peer, next})
+			}
+		}
+	}
+	return 0, false
+}
+
+// sameSegment tells if a frame sent by an interface reaches the other, on the
+// VLAN of its frames
+func (e *environment) sameSegment(from, to netInterface) bool {
+	tag, reached := e.arrival(from.mac, from.vlan, to.mac)
+	return reached && tag == to.vlan
+}
+
+// reaches tells if a frame sent by the interface reaches the device on an
+// interface with the MAC, which the sub-interfaces of a router port share
+func (e *environment) reaches(from netInterface, comp NetComponent, mac MAC) bool {
+	switch comp := comp.(type) {
+	case *node:
+		return comp.netPort.mac == mac && e.sameSegment(from, comp.netPort)
+	case *router:
+		for _, p := range comp.ports {
+			if p.mac == mac && e.sameSegment(from, p.netInterface) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// switchFrame carries the frame from the switch port of its source through the
+// switches, emitting a hop for each port it is sent on. The switches learn the
+// port of the source MAC on the VLAN of the frame, and flood the frames to
+// unknown MACs and broadcasts on every other port of the VLAN.
+func (e *environment) switchFrame(ev event.Event) {
+	start, switched := e.index.attached[MAC(ev.SrcMac)]
+	if !switched {
+		return
+	}
+	vlan, accepted := start.sw.ports[start.port].classify(ev.Vlan)
+	if !accepted {
+		return
+	}
+
+	type hop struct {
+		end  switchEnd
+		vlan uint16
+	}
+	queue := []hop{{start, vlan}}
+	for len(queue) > 0 {
+		in := queue[0]
+		queue = queue[1:]
+		sw := in.end.sw
+		sw.learn(in.vlan, MAC(ev.SrcMac), in.end.port, e.clock)
+
+		kind := event.SWITCH_FORWARD
+		out, known := sw.lookup(in.vlan, MAC(ev.DstMac), e.clock)
+		outs := []uint8{out}
+		if !known || MAC(ev.DstMac) == UNKOWN_MAC {
+			kind = event.SWITCH_FLOOD
+			outs = outs[:0]
+			for number, p := range sw.ports {
+				if !p.empty() && p.carries(in.vlan) {
+					outs = append(outs, uint8(number))
+				}
+			}
+		}
+
+		for _, number := range outs {
+			// the destination is on the link the frame came from
+			if number == in.end.port {
+				continue
+			}
+			p := sw.ports[number]
+			e.sink.Emit(event.Event{
+				Kind:   kind,
+				Src:    sw.name,
+				Dst:    p.receiver(),
+				SrcMac: ev.SrcMac,
+				DstMac: ev.DstMac,
+				Vlan:   p.tag(in.vlan),
+				Data:   fmt.Sprintf("from port %v to port %v", in.end.port, number),
+			})
+			peer := e.getSwitch(p.peer)
+			if peer == nil {
+				continue
+			}
+			if next, accepted := peer.ports[p.peerPort].classify(p.tag(in.vlan)); accepted {
+				queue = append(queue, hop{switchEnd{sw: peer, port: p.peerPort}, next})
+			}
+		}
+	}
+}
+
+// isFrame tells if the event is a frame of a ping, that crosses the switches
+func isFrame(kind event.Kind) bool {
+	switch kind {
+	case event.ARP_REQUEST, event.ARP_REPLY, event.ECHO_REQUEST, event.ECHO_REPLY, event.TIME_EXCEEDED:
+		return true
+	}
+	return false
+}
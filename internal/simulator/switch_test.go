@@ -0,0 +1,278 @@
+package simulator
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/arielril/network-simulator/internal/event"
+)
+
+// switchedNetwork has two nodes and a router port on a switch
+const switchedNetwork = `
+#NODE
+n1,00:00:00:00:00:01,10.0.0.2/24,5,10.0.0.1
+n2,00:00:00:00:00:02,10.0.0.3/24,5,10.0.0.1
+#ROUTER
+r1,1,00:00:00:00:00:10,10.0.0.1/24,5
+#SWITCH
+sw1,4,n1,n2,r1:0,,aging=1
+#ROUTERTABLE
+`
+
+// switchHops returns the hops of the switches, as "kind src dst"
+func switchHops(events []event.Event) []string {
+	hops := make([]string, 0)
+	for _, ev := range events {
+		if ev.Kind == event.SWITCH_FORWARD || ev.Kind == event.SWITCH_FLOOD {
+			hops = append(hops, strings.Join([]string{ev.Kind.String(), ev.Src, ev.Dst}, " "))
+		}
+	}
+	return hops
+}
+
+func TestSwitchLearning(t *testing.T) {
+	env := loadExample(t, "example10.txt")
+	rec := &event.Recorder{}
+	env.SetSink(rec)
+	if err := Ping(env, "n1", "n2", "hello"); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		// the ARP request is a broadcast, and the reply goes to the learned port
+		"switch_flood sw1 n2",
+		"switch_flood sw1 r1",
+		"switch_forward sw1 n1",
+		"switch_forward sw1 n2",
+		"switch_forward sw1 n1",
+	}
+	if diff := diffLines(expected, switchHops(rec.Events)); diff != "" {
+		t.Errorf("Unexpected switch hops:\n%v", diff)
+	}
+
+	sw := env.(*environment).getSwitch("SW1")
+	for mac, port := range map[MAC]uint8{"00:00:00:00:00:01": 0, "00:00:00:00:00:02": 1} {
+		if ent, known := sw.macTable[macKey{vlan: DEFAULT_VLAN, mac: mac}]; !known || ent.port != port {
+			t.Errorf("Expected %v on port %v of the MAC table, got %+v", mac, port, sw.macTable)
+		}
+	}
+}
+
+// The frames cross the links between switches
+func TestSwitchLinks(t *testing.T) {
+	env := loadExample(t, "example10.txt")
+	rec := &event.Recorder{}
+	env.SetSink(rec)
+	if err := Ping(env, "n3", "n4", "hello"); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"switch_flood sw2 r1",
+		"switch_flood sw2 sw3",
+		"switch_flood sw3 n4",
+		"switch_forward sw3 sw2",
+		"switch_forward sw2 n3",
+		"switch_forward sw2 sw3",
+		"switch_forward sw3 n4",
+		"switch_forward sw3 sw2",
+		"switch_forward sw2 n3",
+	}
+	if diff := diffLines(expected, switchHops(rec.Events)); diff != "" {
+		t.Errorf("Unexpected switch hops:\n%v", diff)
+	}
+}
+
+// The MACs are forgotten after the aging, and the frames to them are flooded
+func TestSwitchAging(t *testing.T) {
+	env, err := loadTopology(t, switchedNetwork)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := &event.Recorder{}
+	env.SetSink(rec)
+	if err := Ping(env, "n1", "n2", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	rec.Events = nil
+	// a second later, with the ARP tables filled
+	if err := Ping(env, "n1", "n2", "hello"); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"switch_flood sw1 n2",
+		"switch_flood sw1 r1",
+		"switch_forward sw1 n1",
+	}
+	if diff := diffLines(expected, switchHops(rec.Events)); diff != "" {
+		t.Errorf("Unexpected switch hops:\n%v", diff)
+	}
+}
+
+// Only the interfaces on linked switches, or on no switch, reach each other
+func TestSwitchSegments(t *testing.T) {
+	cases := []struct {
+		name   string
+		sw     string
+		reply  bool
+		device string
+	}{
+		{"same switch", "sw1,3,n1,n2,r1:0", true, ""},
+		{"linked switches", "sw1,2,n1,sw2:0\nsw2,3,sw1:1,n2,r1:0", true, ""},
+		{"separate switches", "sw1,1,n1\nsw2,2,n2,r1:0", false, "n1"},
+		{"node off the switch", "sw1,2,n2,r1:0", false, "n1"},
+	}
+	for _, c := range cases {
+		topology := strings.Replace(switchedNetwork, "sw1,4,n1,n2,r1:0,,aging=1", c.sw, 1)
+		err := pingTopology(t, topology, "n1", "n2")
+		var arpErr *ArpError
+		switch {
+		case c.reply && err != nil:
+			t.Errorf("%v: ping failed: %v", c.name, err)
+		case !c.reply && (!errors.As(err, &arpErr) || arpErr.Device != c.device):
+			t.Errorf("%v: expected no answer to the ARP request of %v, got %v", c.name, c.device, err)
+		}
+	}
+}
+
+func TestSwitchErrors(t *testing.T) {
+	cases := []struct {
+		sw  string
+		msg string
+	}{
+		{"sw1,2,n1", "Expected 4 fields for 2 ports, found 3"},
+		{"sw1,1,n1,aging=0", "Invalid aging 0"},
+		{"sw1,1,n1,ttl=5", "Invalid switch option ttl=5"},
+		{"n1,1,n2", "The name n1 is already used by another device"},
+		{"sw1,1,n9", "Unknown device n9"},
+		{"sw1,1,r1", "Expected the port of router r1, as r1:0"},
+		{"sw1,1,r1:3", "Router r1 has no port 3"},
+		{"sw1,1,n1:0", "Expected the node n1 without a port"},
+		{"sw1,2,n1,N1", "n1 is already connected to port 0 of switch sw1"},
+		{"sw1,1,sw1:0", "Switch sw1 can not be linked to itself"},
+		{"sw1,1,sw2:5\nsw2,1,", "Switch sw2 has no port 5"},
+		{"sw1,2,sw2:0,sw2:1\nsw2,2,,", "The link between switches sw1 and sw2 makes a loop"},
+		{"sw1,2,n1,sw2:0\nsw2,1,n2", "Port 0 of switch sw2 is already connected to sw1:1"},
+	}
+	for _, c := range cases {
+		_, err := loadTopology(t, strings.Replace(switchedNetwork, "sw1,4,n1,n2,r1:0,,aging=1", c.sw, 1))
+		var topoErr *TopologyError
+		if !errors.As(err, &topoErr) || !strings.Contains(topoErr.Msg, c.msg) {
+			t.Errorf("%q: expected %q, got %v", c.sw, c.msg, err)
+		}
+	}
+}
+
+func TestFormatSwitch(t *testing.T) {
+	topology := strings.Replace(switchedNetwork, "sw1,4,n1,n2,r1:0,,aging=1", "SW1 , 04 , N1 , n2 , R1:00 ,, aging=01", 1)
+	formatted, err := FormatTopology("", splitLines(strings.TrimSpace(topology)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if formatted[6] != "SW1,4,n1,n2,r1:0,,aging=1" {
+		t.Errorf("Unexpected formatted switch %v", formatted[6])
+	}
+}
+
+// vlanHops returns the frames sent by the switches, as "src dst vlan"
+func vlanHops(events []event.Event) []string {
+	hops := make([]string, 0)
+	for _, ev := range events {
+		if ev.Kind == event.SWITCH_FORWARD || ev.Kind == event.SWITCH_FLOOD {
+			hops = append(hops, fmt.Sprintf("%v %v %v", ev.Src, ev.Dst, ev.Vlan))
+		}
+	}
+	return hops
+}
+
+// The broadcasts stay on their VLAN, tagged on the trunks only
+func TestVlanBroadcast(t *testing.T) {
+	env := loadExample(t, "example11.txt")
+	rec := &event.Recorder{}
+	env.SetSink(rec)
+	if err := Ping(env, "n1", "n3", "hello"); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		// the ARP request of n1 does not reach n2 nor n4, on the VLAN 20
+		"sw1 sw2 10",
+		"sw2 n3 0",
+		"sw2 r1 10",
+	}
+	if diff := diffLines(expected, vlanHops(rec.Events)[:3]); diff != "" {
+		t.Errorf("Unexpected switch hops:\n%v", diff)
+	}
+}
+
+// The router routes between the VLANs with a sub-interface on each
+func TestRouterOnAStick(t *testing.T) {
+	env := loadExample(t, "example11.txt")
+	rec := &event.Recorder{}
+	env.SetSink(rec)
+	if err := Ping(env, "n1", "n2", "hello"); err != nil {
+		t.Fatal(err)
+	}
+
+	tags := make([]string, 0)
+	for _, ev := range rec.Events {
+		if ev.Src == "r1" && ev.Kind == event.ECHO_REQUEST || ev.Src == "r1" && ev.Kind == event.ECHO_REPLY {
+			tags = append(tags, fmt.Sprintf("%v %v", ev.Dst, ev.Vlan))
+		}
+	}
+	if diff := diffLines([]string{"n2 20", "n1 10"}, tags); diff != "" {
+		t.Errorf("Unexpected frames of the router:\n%v", diff)
+	}
+}
+
+// Only the interfaces on the same VLAN reach each other
+func TestVlanSegments(t *testing.T) {
+	cases := []struct {
+		name  string
+		sw    string
+		reply bool
+	}{
+		{"same vlan", "sw1,3,n1@10,n2@10,r1:0", true},
+		{"default vlan", "sw1,3,n1@1,n2,r1:0", true},
+		{"separate vlans", "sw1,3,n1@10,n2@20,r1:0", false},
+		{"trunk", "sw1,2,n1@10,sw2:0@trunk\nsw2,3,sw1:1,n2@10,r1:0", true},
+		{"trunk of other vlans", "sw1,2,n1@10,sw2:0@trunk=20;30\nsw2,3,sw1:1,n2@10,r1:0", false},
+		{"native vlan", "sw1,2,n1,sw2:0@trunk\nsw2,3,sw1:1,n2,r1:0", true},
+		{"untagged node on trunk", "sw1,3,n1@trunk,n2@10,r1:0", false},
+	}
+	for _, c := range cases {
+		topology := strings.Replace(switchedNetwork, "sw1,4,n1,n2,r1:0,,aging=1", c.sw, 1)
+		err := pingTopology(t, topology, "n1", "n2")
+		var arpErr *ArpError
+		switch {
+		case c.reply && err != nil:
+			t.Errorf("%v: ping failed: %v", c.name, err)
+		case !c.reply && !errors.As(err, &arpErr):
+			t.Errorf("%v: expected no answer to the ARP request, got %v", c.name, err)
+		}
+	}
+}
+
+func TestVlanErrors(t *testing.T) {
+	cases := []struct {
+		from, to string
+		msg      string
+	}{
+		{"sw1,4,n1,n2,r1:0,,aging=1", "sw1,1,n1@0", "Invalid port mode 0"},
+		{"sw1,4,n1,n2,r1:0,,aging=1", "sw1,1,n1@access", "Invalid port mode access"},
+		{"sw1,4,n1,n2,r1:0,,aging=1", "sw1,1,n1@trunk=10;5000", "Invalid VLAN 5000"},
+		{"00:00:00:00:00:10,", "00:00:00:00:00:10.1,", "can not be on the native VLAN 1"},
+		{"00:00:00:00:00:10,", "00:00:00:00:00:10.x,", "Invalid VLAN x"},
+		{"00:00:00:00:00:10,", "auto.10,", "The sub-interfaces share the MAC of their link"},
+	}
+	for _, c := range cases {
+		_, err := loadTopology(t, strings.Replace(switchedNetwork, c.from, c.to, 1))
+		var topoErr *TopologyError
+		if !errors.As(err, &topoErr) || !strings.Contains(topoErr.Msg, c.msg) {
+			t.Errorf("%q: expected %q, got %v", c.to, c.msg, err)
+		}
+	}
+}
@@ -0,0 +1,653 @@
+package simulator
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/arielril/network-simulator/internal/file"
+	"github.com/urfave/cli"
+)
+
+// Sections of the topology, in the order they are written
+var topologyLabels = []string{
+	NODE_LABEL,
+	ROUTER_LABEL,
+	SWITCH_LABEL,
+	ROUTER_TABLE_LABEL,
+	RIP_LABEL,
+	OSPF_LABEL,
+	BGP_LABEL,
+	BGP_NEIGHBOR_LABEL,
+}
+
+// A # followed by a word, as the labels are written
+var labelRe = regexp.MustCompile(`^#[A-Za-z_]+$`)
+
+/*
+----------------------------------------------------
+Topology lines
+----------------------------------------------------
+*/
+
+// topologyLine is a line of the topology file, or of a file it includes
+type topologyLine struct {
+	// File the line was read from, empty when it was not read from a file
+	path string
+	// Number of the line on its file, starting at 1, and its text
+	number int
+	text   string
+	// Label of the section the line starts, or #INCLUDE
+	label string
+	// Fields of the line, or the path of the included file, without the comment
+	content string
+	// Comment at the end of the line, starting with #
+	comment string
+}
+
+// parseTopologyLine tells the labels from the comments: a line starting with #
+// is a label when its first word is one, an unknown label when it is a single
+// word, as #ROUTR, and a comment otherwise. On the other lines, a # starts a
+// comment up to the end of the line.
+func parseTopologyLine(text string) (topologyLine, error) {
+	trimmed := strings.TrimSpace(text)
+	l := topologyLine{text: text}
+	if !strings.HasPrefix(trimmed, "#") {
+		l.content = trimmed
+		if idx := strings.Index(trimmed, "#"); idx >= 0 {
+			l.content, l.comment = strings.TrimSpace(trimmed[:idx]), trimmed[idx:]
+		}
+		return l, nil
+	}
+
+	word, rest := trimmed, ""
+	if idx := strings.IndexAny(trimmed, " \t"); idx >= 0 {
+		word, rest = trimmed[:idx], strings.TrimSpace(trimmed[idx:])
+	}
+	for _, label := range append([]string{INCLUDE_LABEL}, topologyLabels...) {
+		if strings.EqualFold(word, label) {
+			l.label = label
+		}
+	}
+	if l.label == "" && rest == "" && labelRe.MatchString(word) {
+		return l, fmt.Errorf("Unknown section %v, expected one of %v", word, strings.Join(topologyLabels, " "))
+	}
+	if l.label == "" {
+		l.comment = trimmed
+		return l, nil
+	}
+	if idx := strings.Index(rest, "#"); idx >= 0 {
+		rest, l.comment = strings.TrimSpace(rest[:idx]), rest[idx:]
+	}
+
+	switch {
+	case l.label == INCLUDE_LABEL && rest == "":
+		return l, fmt.Errorf("Expected the path of the included topology")
+	case l.label == INCLUDE_LABEL:
+		l.content = rest
+	case rest != "":
+		return l, fmt.Errorf("Unexpected %v after the label %v", rest, l.label)
+	}
+	return l, nil
+}
+
+// readTopologyLines reads the lines of the topology on the path, replacing each
+// #INCLUDE by the lines of the file, relative to the directory of the topology.
+// The included lines go on the section of the #INCLUDE, and the section goes on
+// after them, unless the #INCLUDE is above every label. Including is the
+// absolute path of the files being read.
+func readTopologyLines(path string, lines []string, including []string) ([]topologyLine, error) {
+	topology := make([]topologyLine, 0, len(lines))
+	section := ""
+	for i, text := range lines {
+		l, err := parseTopologyLine(text)
+		l.path, l.number = path, i+1
+		if err != nil {
+			return nil, &TopologyError{Path: path, Line: l.number, Text: text, Msg: err.Error()}
+		}
+		if l.label != INCLUDE_LABEL {
+			if l.label != "" {
+				section = l.label
+			}
+			topology = append(topology, l)
+			continue
+		}
+
+		included, err := includeTopology(l, including)
+		if err != nil {
+			return nil, err
+		}
+		topology = append(topology, included...)
+		last := section
+		for _, inc := range included {
+			if inc.label != "" {
+				last = inc.label
+			}
+		}
+		if section == "" {
+			section = last
+		} else if last != section {
+			topology = append(topology, topologyLine{path: path, number: l.number, text: section, label: section})
+		}
+	}
+	return topology, nil
+}
+
+func includeTopology(l topologyLine, including []string) ([]topologyLine, error) {
+	fail := func(msg string) error {
+		return &TopologyError{Path: l.path, Line: l.number, Text: l.text, Msg: msg}
+	}
+
+	path := l.content
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(l.path), path)
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fail(fmt.Sprintf("Failed to read the included topology %v: %v", l.content, err))
+	}
+	for _, p := range including {
+		if p == absPath {
+			return nil, fail(fmt.Sprintf("The topology %v includes itself", l.content))
+		}
+	}
+	lines, err := file.Read(absPath)
+	if err != nil {
+		return nil, fail(fmt.Sprintf("Failed to read the included topology %v: %v", l.content, err))
+	}
+	return readTopologyLines(path, lines, append(append([]string{}, including...), absPath))
+}
+
+/*
+----------------------------------------------------
+Topology writer
+----------------------------------------------------
+*/
+
+func (e *environment) topologySections() map[string][]string {
+	sections := make(map[string][]string)
+	add := func(label string, fields ...interface{}) {
+		values := make([]string, len(fields))
+		for i, field := range fields {
+			values[i] = fmt.Sprint(field)
+		}
+		sections[label] = append(sections[label], strings.Join(values, ","))
+	}
+
+	for _, n := range e.nodes {
+		add(NODE_LABEL, n.name, n.netPort.mac, n.netPort.ip.ToString(), n.netPort.mtu, n.gateway.ip)
+	}
+	for _, r := range e.routers {
+		fields := []interface{}{r.name, len(r.ports)}
+		for _, p := range r.ports {
+			fields = append(fields, p.macField(), p.ip.ToString(), p.mtu)
+		}
+		add(ROUTER_LABEL, fields...)
+	}
+	for _, s := range e.switches {
+		fields := []interface{}{s.name, len(s.ports)}
+		for _, p := range s.ports {
+			fields = append(fields, p)
+		}
+		if s.aging != SWITCH_AGING {
+			fields = append(fields, fmt.Sprintf("aging=%v", s.aging))
+		}
+		add(SWITCH_LABEL, fields...)
+	}
+	for _, r := range e.routers {
+		for _, ent := range r.routerTable {
+			if ent.source == STATIC_ROUTE {
+				add(ROUTER_TABLE_LABEL, r.name, ent.netdest.ToString(), ent.nexthop.ip, ent.port)
+			}
+		}
+	}
+
+	for _, p := range e.routing.protocols {
+		switch domain := p.(type) {
+		case *rip:
+			for _, proc := range domain.procs {
+				fields := []interface{}{proc.router.name}
+				for _, port := range proc.ports {
+					fields = append(fields, port)
+				}
+				add(RIP_LABEL, fields...)
+			}
+		case *ospf:
+			for _, proc := range domain.procs {
+				fields := []interface{}{proc.router.name}
+				for _, port := range proc.ports {
+					fields = append(fields, fmt.Sprintf("%v:%v", port.number, port.cost))
+				}
+				add(OSPF_LABEL, fields...)
+			}
+		case *bgp:
+			for _, proc := range domain.procs {
+				fields := []interface{}{proc.router.name, proc.asn}
+				for _, path := range proc.originated {
+					fields = append(fields, path.prefix.ToString())
+				}
+				add(BGP_LABEL, fields...)
+			}
+			for _, proc := range domain.procs {
+				for _, s := range proc.sessions {
+					fields := []interface{}{proc.router.name, s.peerIp.ip}
+					for _, option := range s.policy.options() {
+						fields = append(fields, option)
+					}
+					add(BGP_NEIGHBOR_LABEL, fields...)
+				}
+			}
+		}
+	}
+	return sections
+}
+
+// options returns the policy as the options of a #BGPNEIGHBOR line
+func (p BgpPolicy) options() []string {
+	options := make([]string, 0)
+	if p.LocalPref != 0 {
+		options = append(options, fmt.Sprintf("localpref=%v", p.LocalPref))
+	}
+	if p.Med != 0 {
+		options = append(options, fmt.Sprintf("med=%v", p.Med))
+	}
+	if p.Prepend != 0 {
+		options = append(options, fmt.Sprintf("prepend=%v", p.Prepend))
+	}
+	for _, prefix := range p.DenyIn {
+		options = append(options, "deny-in="+prefix)
+	}
+	for _, prefix := range p.DenyOut {
+		options = append(options, "deny-out="+prefix)
+	}
+	if p.NextHopSelf {
+		options = append(options, "next-hop-self")
+	}
+	return options
+}
+
+// WriteTopology writes the devices, the static routes and the routing
+// protocols of the environment on the topology format. The routes installed by
+// the protocols are not written, as they are learned again.
+func (e *environment) WriteTopology(w io.Writer) error {
+	sections := e.topologySections()
+	for _, label := range topologyLabels {
+		lines, hasLines := sections[label]
+		if !hasLines && label != NODE_LABEL && label != ROUTER_LABEL && label != ROUTER_TABLE_LABEL {
+			continue
+		}
+		if _, err := fmt.Fprintln(w, label); err != nil {
+			return err
+		}
+		for _, line := range lines {
+			if _, err := fmt.Fprintln(w, line); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+/*
+----------------------------------------------------
+Topology formatter
+----------------------------------------------------
+*/
+
+// formattedLine is a line of a section, with the comments and blank lines above it
+type formattedLine struct {
+	comments []string
+	text     string
+	// Router of the lines of #ROUTERTABLE
+	router string
+}
+
+// topologySection is a section of the file, with the lines above its label
+type topologySection struct {
+	label    string
+	comments []string
+	comment  string
+	lines    []formattedLine
+	// The section includes other files
+	includes bool
+}
+
+// formatNumber writes the number without leading zeros
+func formatNumber(value string) string {
+	number, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return value
+	}
+	return strconv.FormatUint(number, 10)
+}
+
+func formatMacField(value string) string {
+	if isAuto(value) {
+		return AUTO
+	}
+	spec := strings.SplitN(value, ".", 2)
+	if len(spec) == 2 {
+		return strings.ToUpper(spec[0]) + "." + formatNumber(spec[1])
+	}
+	return strings.ToUpper(value)
+}
+
+// formatPortMode normalises the VLANs of a switch port, as 10 or trunk=10;20
+func formatPortMode(value string) string {
+	spec := strings.SplitN(value, "=", 2)
+	if !strings.EqualFold(spec[0], TRUNK) {
+		return formatNumber(value)
+	}
+	if len(spec) == 1 {
+		return TRUNK
+	}
+	vlans := strings.Split(spec[1], ";")
+	for i := range vlans {
+		vlans[i] = formatNumber(strings.TrimSpace(vlans[i]))
+	}
+	return TRUNK + "=" + strings.Join(vlans, ";")
+}
+
+func formatIpField(value string) string {
+	if subnet, isAutoIp := autoSubnet(value); isAutoIp {
+		return AUTO_SUBNET + subnet
+	}
+	return value
+}
+
+// formatLine normalises the fields of a line of the section. The names of the
+// routers and of the devices on the switch ports take the case they were
+// declared with.
+func (e *environment) formatLine(label, line string) string {
+	l := splitFields(line)
+	routerName := func(name string) string {
+		if rt := e.GetRouterByName(name); rt != nil {
+			return rt.name
+		}
+		return name
+	}
+	deviceName := func(name string) string {
+		if comp := e.GetNetComponentByName(name); comp != nil {
+			return comp.GetName()
+		}
+		if sw := e.getSwitch(name); sw != nil {
+			return sw.name
+		}
+		return name
+	}
+
+	switch label {
+	case NODE_LABEL:
+		l[1] = formatMacField(l[1])
+		l[2] = formatIpField(l[2])
+		l[3] = formatNumber(l[3])
+		if isAuto(l[4]) {
+			l[4] = AUTO
+		}
+	case ROUTER_LABEL:
+		l[1] = formatNumber(l[1])
+		for i := 2; i+2 < len(l); i += 3 {
+			l[i] = formatMacField(l[i])
+			l[i+1] = formatIpField(l[i+1])
+			l[i+2] = formatNumber(l[i+2])
+		}
+	case SWITCH_LABEL:
+		l[1] = formatNumber(l[1])
+		for i := 2; i < len(l); i++ {
+			port := strings.SplitN(l[i], "@", 2)
+			spec := strings.SplitN(port[0], "=", 2)
+			if len(port) == 1 && len(spec) == 2 {
+				l[i] = spec[0] + "=" + formatNumber(spec[1])
+				continue
+			}
+			spec = strings.SplitN(port[0], ":", 2)
+			spec[0] = deviceName(spec[0])
+			if len(spec) == 2 {
+				spec[1] = formatNumber(spec[1])
+			}
+			l[i] = strings.Join(spec, ":")
+			if len(port) == 2 {
+				l[i] += "@" + formatPortMode(port[1])
+			}
+		}
+	case ROUTER_TABLE_LABEL:
+		l[0] = routerName(l[0])
+		l[3] = formatNumber(l[3])
+	case RIP_LABEL:
+		l[0] = routerName(l[0])
+		for i := 1; i < len(l); i++ {
+			l[i] = formatNumber(l[i])
+		}
+	case OSPF_LABEL:
+		l[0] = routerName(l[0])
+		for i := 1; i < len(l); i++ {
+			spec := strings.SplitN(l[i], ":", 2)
+			for j := range spec {
+				spec[j] = formatNumber(spec[j])
+			}
+			l[i] = strings.Join(spec, ":")
+		}
+	case BGP_LABEL:
+		l[0] = routerName(l[0])
+		l[1] = formatNumber(l[1])
+	case BGP_NEIGHBOR_LABEL:
+		l[0] = routerName(l[0])
+	}
+	return strings.Join(l, ",")
+}
+
+// FormatTopology normalises the lines of a valid topology, whose includes are
+// relative to the path: the labels in upper case and each section once, in the
+// order of the format, the fields without spaces and with the MACs in upper
+// case, and the routes of each router together, in the order of the routers.
+// The devices and the routes of a router keep their order, as it chooses the
+// automatic addresses and the route taken. The comments are kept above the line
+// that followed them, or at its end, and the included files are not changed.
+func FormatTopology(path string, lines []string) ([]string, error) {
+	env := NewEnvironment().(*environment)
+	including := []string{}
+	if path != "" {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		including = append(including, absPath)
+	}
+	topology, err := readTopologyLines(path, lines, including)
+	if err != nil {
+		return nil, err
+	}
+	if err := env.parseTopology(topology); err != nil {
+		return nil, err
+	}
+
+	sections := make(map[string]*topologySection)
+	var current *topologySection
+	top := make([]string, 0)
+	// comments and blank lines waiting for the next line
+	pending := make([]string, 0)
+	withComment := func(text, comment string) string {
+		if comment == "" {
+			return text
+		}
+		return text + " " + comment
+	}
+
+	for _, text := range lines {
+		l, _ := parseTopologyLine(text)
+		switch {
+		case l.label != "" && l.label != INCLUDE_LABEL:
+			if len(sections) == 0 {
+				top, pending = pending, make([]string, 0)
+			}
+			section, declared := sections[l.label]
+			if !declared {
+				section = &topologySection{label: l.label, comments: pending, comment: l.comment}
+				sections[l.label] = section
+				pending = make([]string, 0)
+			} else if l.comment != "" {
+				pending = append(pending, l.comment)
+			}
+			current = section
+		case l.content == "":
+			pending = append(pending, l.comment)
+		case current == nil:
+			// an include of sections, above them
+			top = append(append(top, pending...), withComment(INCLUDE_LABEL+" "+l.content, l.comment))
+			pending = make([]string, 0)
+		case l.label == INCLUDE_LABEL:
+			current.lines = append(current.lines, formattedLine{
+				comments: pending,
+				text:     withComment(INCLUDE_LABEL+" "+l.content, l.comment),
+			})
+			current.includes = true
+			pending = make([]string, 0)
+		default:
+			current.lines = append(current.lines, formattedLine{
+				comments: pending,
+				text:     withComment(env.formatLine(current.label, l.content), l.comment),
+				router:   splitFields(l.content)[0],
+			})
+			pending = make([]string, 0)
+		}
+	}
+
+	formatted := append([]string{}, top...)
+	add := func(fl formattedLine) {
+		formatted = append(append(formatted, fl.comments...), fl.text)
+	}
+	for _, label := range topologyLabels {
+		section, declared := sections[label]
+		if !declared {
+			continue
+		}
+		formatted = append(formatted, section.comments...)
+		formatted = append(formatted, withComment(label, section.comment))
+		if label != ROUTER_TABLE_LABEL || section.includes {
+			for _, fl := range section.lines {
+				add(fl)
+			}
+			continue
+		}
+		for _, rt := range env.routers {
+			for _, fl := range section.lines {
+				if strings.EqualFold(fl.router, rt.name) {
+					add(fl)
+				}
+			}
+		}
+	}
+	formatted = append(formatted, pending...)
+
+	// a single blank line between blocks, and none at the start and end
+	compact := make([]string, 0, len(formatted))
+	for _, line := range formatted {
+		if line == "" && (len(compact) == 0 || compact[len(compact)-1] == "") {
+			continue
+		}
+		compact = append(compact, line)
+	}
+	for len(compact) > 0 && compact[len(compact)-1] == "" {
+		compact = compact[:len(compact)-1]
+	}
+	return compact, nil
+}
+
+/*
+----------------------------------------------------
+Fmt command
+----------------------------------------------------
+*/
+
+// formatFile returns the formatted topology of the file and whether it changed
+func formatFile(path string) ([]byte, bool, error) {
+	filePath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, false, fmt.Errorf("Failed to read topology: %w", err)
+	}
+	lines, err := file.Read(filePath)
+	if err != nil {
+		return nil, false, fmt.Errorf("Failed to read topology: %w", err)
+	}
+	original, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, false, fmt.Errorf("Failed to read topology: %w", err)
+	}
+
+	formatted, err := FormatTopology(path, lines)
+	if err != nil {
+		return nil, false, err
+	}
+	var out bytes.Buffer
+	for _, line := range formatted {
+		fmt.Fprintln(&out, line)
+	}
+	return out.Bytes(), !bytes.Equal(out.Bytes(), original), nil
+}
+
+// replaceFile writes the content on a temporary file of the same directory and
+// renames it over the file, so a failure halfway leaves the original whole
+func replaceFile(path string, content []byte) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), info.Mode()); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Fmt formats the topology files in place, or only lists the ones that are not
+// formatted with --check
+func Fmt(ctx *cli.Context) error {
+	args := ctx.Args()
+	if len(args) == 0 {
+		return &UsageError{Msg: "Invalid fmt arguments, expected the topology files"}
+	}
+
+	unformatted := 0
+	for _, path := range args {
+		formatted, changed, err := formatFile(path)
+		if err != nil {
+			return err
+		}
+		if !changed {
+			continue
+		}
+		if ctx.Bool("check") {
+			fmt.Fprintln(os.Stdout, path)
+			unformatted++
+			continue
+		}
+		if err := replaceFile(path, formatted); err != nil {
+			return fmt.Errorf("Failed to write topology: %w", err)
+		}
+	}
+	if unformatted > 0 {
+		return cli.NewExitError("", 1)
+	}
+	return nil
+}
@@ -0,0 +1,365 @@
+package simulator
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/arielril/network-simulator/internal/event"
+)
+
+// The written topology loads into an environment with the same pings
+func TestWriteTopologyRoundTrip(t *testing.T) {
+	examples, err := filepath.Glob(filepath.Join(EXAMPLES_DIR, "*.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range examples {
+		name := filepath.Base(path)
+		t.Run(name, func(t *testing.T) {
+			env := loadExample(t, name)
+			var written bytes.Buffer
+			if err := env.WriteTopology(&written); err != nil {
+				t.Fatal(err)
+			}
+			again, err := loadTopology(t, written.String())
+			if err != nil {
+				t.Fatalf("Failed to load the written topology: %v\n%v", err, written.String())
+			}
+			var rewritten bytes.Buffer
+			if err := again.WriteTopology(&rewritten); err != nil {
+				t.Fatal(err)
+			}
+			if diff := diffLines(splitLines(written.String()), splitLines(rewritten.String())); diff != "" {
+				t.Errorf("The topology changed when written again:\n%v", diff)
+			}
+
+			var want, got bytes.Buffer
+			_, outcomes := PingMatrix(env, false, "hello", 0)
+			WriteMatrixCsv(&want, outcomes)
+			_, outcomes = PingMatrix(again, false, "hello", 0)
+			WriteMatrixCsv(&got, outcomes)
+			if diff := diffLines(splitLines(want.String()), splitLines(got.String())); diff != "" {
+				t.Errorf("The pings changed on the written topology:\n%v", diff)
+			}
+		})
+	}
+}
+
+func TestWriteTopologyProtocols(t *testing.T) {
+	var written bytes.Buffer
+	if err := loadExample(t, "example9.txt").WriteTopology(&written); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"#BGP", "r1,65001,10.0.0.0/24", "r2,65002", "r2,100.0.2.2,next-hop-self"} {
+		if !strings.Contains(written.String(), line+"\n") {
+			t.Errorf("Expected the line %v on the written topology:\n%v", line, written.String())
+		}
+	}
+}
+
+const messyTopology = `# lab topology
+#routertable
+R1 , 0.0.0.0/0 , 192.168.1.2 , 1
+# routers
+#Router
+r1,2,00:00:00:00:00:05,192.168.0.1/24,05,00:00:00:00:00:06,192.168.1.1/24,5
+#node
+n1, aa:00:00:00:00:01 ,192.168.0.2/24,5,192.168.0.1
+n2,AUTO,Auto@192.168.1.0/24,5,AUTO`
+
+func TestFormatTopology(t *testing.T) {
+	expected := []string{
+		"# lab topology",
+		"#NODE",
+		"n1,AA:00:00:00:00:01,192.168.0.2/24,5,192.168.0.1",
+		"n2,auto,auto@192.168.1.0/24,5,auto",
+		"# routers",
+		"#ROUTER",
+		"r1,2,00:00:00:00:00:05,192.168.0.1/24,5,00:00:00:00:00:06,192.168.1.1/24,5",
+		"#ROUTERTABLE",
+		"r1,0.0.0.0/0,192.168.1.2,1",
+	}
+
+	formatted, err := FormatTopology("", splitLines(messyTopology))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := diffLines(expected, formatted); diff != "" {
+		t.Errorf("Unexpected formatted topology:\n%v", diff)
+	}
+
+	again, err := FormatTopology("", formatted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := diffLines(formatted, again); diff != "" {
+		t.Errorf("Formatting twice changed the topology:\n%v", diff)
+	}
+}
+
+func TestFormatExamples(t *testing.T) {
+	examples, err := filepath.Glob(filepath.Join(EXAMPLES_DIR, "*.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range examples {
+		formatted, changed, err := formatFile(path)
+		if err != nil {
+			t.Fatalf("%v: %v", path, err)
+		}
+		if changed {
+			original, _ := os.ReadFile(path)
+			t.Errorf("%v is not formatted:\n%v", path, diffLines(splitLines(string(original)), splitLines(string(formatted))))
+		}
+	}
+}
+
+// The formatted topology replaces the file whole, keeping its mode
+func TestReplaceFile(t *testing.T) {
+	dir := writeTopologies(t, map[string]string{"lab.txt": twoNetworks})
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "lab.txt")
+	if err := os.Chmod(path, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := replaceFile(path, []byte("#NODE\n")); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil || string(content) != "#NODE\n" {
+		t.Errorf("expected the new content, got %q (%v)", content, err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode() != 0600 {
+		t.Errorf("expected the mode 0600 to be kept, got %v", info.Mode())
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("expected only the topology on the directory, got %v files", len(entries))
+	}
+}
+
+func TestFormatTopologyErrors(t *testing.T) {
+	if _, err := FormatTopology("", []string{"#NODE", "n1,00:00:00:00:00:01"}); err == nil {
+		t.Errorf("Expected an error on the invalid node")
+	}
+}
+
+// The repeated sections are merged, with the comments inside them
+func TestFormatRepeatedSections(t *testing.T) {
+	topology := `#ROUTER # the routers
+r1,2,00:00:00:00:00:10,10.0.0.1/24,5,00:00:00:00:00:11,20.0.0.1/24,5
+
+#NODE
+n1,00:00:00:00:00:01,10.0.0.2/24,5,10.0.0.1   # first network
+
+
+# second network
+#NODE
+n2,00:00:00:00:00:02,20.0.0.2/24,5,20.0.0.1
+#ROUTERTABLE`
+	expected := []string{
+		"#NODE",
+		"n1,00:00:00:00:00:01,10.0.0.2/24,5,10.0.0.1 # first network",
+		"",
+		"# second network",
+		"n2,00:00:00:00:00:02,20.0.0.2/24,5,20.0.0.1",
+		"#ROUTER # the routers",
+		"r1,2,00:00:00:00:00:10,10.0.0.1/24,5,00:00:00:00:00:11,20.0.0.1/24,5",
+		"#ROUTERTABLE",
+	}
+
+	formatted, err := FormatTopology("", splitLines(topology))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := diffLines(expected, formatted); diff != "" {
+		t.Errorf("Unexpected formatted topology:\n%v", diff)
+	}
+}
+
+/*
+----------------------------------------------------
+Comments, sections and includes
+----------------------------------------------------
+*/
+
+func TestTopologyComments(t *testing.T) {
+	topology := `
+# two networks and a router between them
+
+#ROUTERTABLE   # empty, the networks are connected
+#NODE
+n1,00:00:00:00:00:01,10.0.0.2/24,5,10.0.0.1 # the first node
+# the second node
+n2 , 00:00:00:00:00:02 , 20.0.0.2/24 , 5 , 20.0.0.1
+
+#ROUTER
+r1,2,00:00:00:00:00:10,10.0.0.1/24,5,00:00:00:00:00:11,20.0.0.1/24,5#no space
+`
+	if err := pingTopology(t, topology, "n1", "n2"); err != nil {
+		t.Errorf("Ping failed: %v", err)
+	}
+}
+
+// A misspelled label is reported on its line, not read as a comment
+func TestTopologyUnknownSection(t *testing.T) {
+	topology := strings.Replace(twoNetworks, "#ROUTER\n", "#ROUTR\n", 1)
+	_, err := loadTopology(t, topology)
+	var topoErr *TopologyError
+	if !errors.As(err, &topoErr) || topoErr.Line != 4 || !strings.Contains(topoErr.Msg, "Unknown section #ROUTR") {
+		t.Errorf("Expected an unknown section on line 4, got %v", err)
+	}
+
+	// a # with more text is still a comment
+	topology = strings.Replace(twoNetworks, "#ROUTER\n", "#ROUTER\n#routers of the lab\n#\n", 1)
+	if err := pingTopology(t, topology, "n1", "n2"); err != nil {
+		t.Errorf("Ping failed: %v", err)
+	}
+}
+
+func TestTopologyRepeatedSections(t *testing.T) {
+	topology := `
+#NODE
+N1,00:00:00:00:00:01,10.0.0.2/24,5,10.0.0.1
+#ROUTER
+Edge,2,00:00:00:00:00:10,10.0.0.1/24,5,00:00:00:00:00:11,20.0.0.1/24,5
+#node
+n2,00:00:00:00:00:02,20.0.0.2/24,5,20.0.0.1
+#routertable
+edge,30.0.0.0/24,20.0.0.2,1
+`
+	env, err := loadTopology(t, topology)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := strings.Join(env.GetNames(), " "); names != "N1 n2 Edge" {
+		t.Errorf("Expected the devices N1 n2 Edge, with the case they were declared with, got %v", names)
+	}
+	var routes bytes.Buffer
+	if err := env.WriteRoutes(&routes, "EDGE"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(routes.String(), "Router Edge\n") || !strings.Contains(routes.String(), "30.0.0.0/24 via 20.0.0.2") {
+		t.Errorf("Expected the static route of Edge, got:\n%v", routes.String())
+	}
+}
+
+// writeTopologies writes the files on a temporary directory and returns it
+func writeTopologies(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	dir, err := os.MkdirTemp("", "topology")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, text := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(strings.TrimSpace(text)+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestTopologyInclude(t *testing.T) {
+	dir := writeTopologies(t, map[string]string{
+		"lab.txt": `
+#NODE
+#INCLUDE shared/nodes.txt
+n2,00:00:00:00:00:02,20.0.0.2/24,5,20.0.0.1
+#ROUTERTABLE`,
+		// the routers of the lab, and the node of the first network
+		"shared/nodes.txt": `
+n1,00:00:00:00:00:01,10.0.0.2/24,5,10.0.0.1
+#ROUTER
+#INCLUDE routers.txt`,
+		"shared/routers.txt": `
+r1,2,00:00:00:00:00:10,10.0.0.1/24,5,00:00:00:00:00:11,20.0.0.1/24,5`,
+	})
+	defer os.RemoveAll(dir)
+
+	env, err := LoadEnvironment(filepath.Join(dir, "lab.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.SetSink(&event.Recorder{})
+	if err := Ping(env, "n1", "n2", "hello"); err != nil {
+		t.Errorf("Ping failed: %v", err)
+	}
+
+	expected := []string{
+		"#NODE",
+		"n1,00:00:00:00:00:01,10.0.0.2/24,5,10.0.0.1",
+		"#ROUTER",
+		"r1,2,00:00:00:00:00:10,10.0.0.1/24,5,00:00:00:00:00:11,20.0.0.1/24,5",
+		"#NODE",
+		"n2,00:00:00:00:00:02,20.0.0.2/24,5,20.0.0.1",
+		"#ROUTERTABLE",
+	}
+	if diff := diffLines(expected, env.ResolvedLines()); diff != "" {
+		t.Errorf("Unexpected resolved topology:\n%v", diff)
+	}
+}
+
+func TestTopologyIncludeErrors(t *testing.T) {
+	dir := writeTopologies(t, map[string]string{
+		"missing.txt": "#NODE\n#INCLUDE nodes.txt",
+		"loop.txt":    "#ROUTER\n#INCLUDE other.txt",
+		"other.txt":   "#include loop.txt",
+		"invalid.txt": "#ROUTER\n#INCLUDE router.txt",
+		"router.txt":  "r1,1,00:00:00:00:00:10,10.0.0.1/24",
+	})
+	defer os.RemoveAll(dir)
+
+	cases := []struct {
+		file string
+		path string
+		line int
+		msg  string
+	}{
+		{"missing.txt", "missing.txt", 2, "Failed to read the included topology nodes.txt"},
+		{"loop.txt", "other.txt", 1, "The topology loop.txt includes itself"},
+		{"invalid.txt", "router.txt", 1, "Expected 5 fields for 1 ports"},
+	}
+	for _, c := range cases {
+		_, err := LoadEnvironment(filepath.Join(dir, c.file))
+		var topoErr *TopologyError
+		if !errors.As(err, &topoErr) {
+			t.Errorf("%v: expected a topology error, got %v", c.file, err)
+			continue
+		}
+		if topoErr.Path != filepath.Join(dir, c.path) || topoErr.Line != c.line || !strings.Contains(topoErr.Msg, c.msg) {
+			t.Errorf("%v: expected %v:%v with %q, got %v", c.file, c.path, c.line, c.msg, err)
+		}
+	}
+}
+
+func TestTopologyLineErrors(t *testing.T) {
+	cases := []struct {
+		topology string
+		line     int
+		msg      string
+	}{
+		{"n1,00:00:00:00:00:01,10.0.0.2/24,5,10.0.0.1\n#NODE", 1, "Expected a section label"},
+		{"#NODE nodes", 1, "Unexpected nodes after the label #NODE"},
+		{"#NODE\n#INCLUDE # nothing", 2, "Expected the path of the included topology"},
+	}
+	for _, c := range cases {
+		_, err := loadTopology(t, c.topology)
+		var topoErr *TopologyError
+		if !errors.As(err, &topoErr) || topoErr.Line != c.line || !strings.Contains(topoErr.Msg, c.msg) {
+			t.Errorf("%q: expected line %v with %q, got %v", c.topology, c.line, c.msg, err)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package transcript
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/arielril/network-simulator/internal/output"
+	"github.com/arielril/network-simulator/internal/simulator"
+	"github.com/urfave/cli"
+)
+
+// Convert reads a transcript printed by the simulator and writes it on another
+// output format
+func Convert(ctx *cli.Context) error {
+	args := ctx.Args()
+	if len(args) != 1 {
+		return &simulator.UsageError{Msg: "Invalid convert arguments"}
+	}
+
+	f, err := os.Open(args.Get(0))
+	if err != nil {
+		return fmt.Errorf("Failed to read transcript: %w", err)
+	}
+	defer f.Close()
+
+	events, err := Parse(f)
+	if err != nil {
+		return fmt.Errorf("Invalid transcript %v:\n%w", args.Get(0), err)
+	}
+
+	out, err := output.New(ctx.String("format"), os.Stdout, nil)
+	if err != nil {
+		return &simulator.UsageError{Msg: err.Error()}
+	}
+	for _, ev := range events {
+		out.Emit(ev)
+	}
+	return out.Close()
+}
@@ -0,0 +1,542 @@
+package transcript
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/arielril/network-simulator/internal/event"
+)
+
+var (
+	macRe = regexp.MustCompile(`^[0-9A-Fa-f]{2}(:[0-9A-Fa-f]{2}){5}`)
+	ipRe  = regexp.MustCompile(`^\d{1,3}\.\d{1,3}\.\d{1,3}\.\d{1,3}`)
+	numRe = regexp.MustCompile(`^\d+`)
+	// names go until the next blank
+	nameRe = regexp.MustCompile(`^[^\s]+`)
+	// or, on lenient lines, until the arc or the ":" after the names
+	lenientNameRe = regexp.MustCompile(`^[^\s=:]+`)
+)
+
+// ParseError describes where a line differs from the simulator output format
+type ParseError struct {
+	// Line and column (in bytes) where the error was found, starting at 1
+	Line   int
+	Column int
+	Msg    string
+	Text   string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %v, column %v: %v", e.Line, e.Column, e.Msg)
+}
+
+// ParseErrors has every malformed line of a transcript
+type ParseErrors []*ParseError
+
+func (e ParseErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+/*
+----------------------------------------------------
+Line scanner
+----------------------------------------------------
+*/
+
+type cursor struct {
+	text string
+	pos  int
+	// Blanks are optional around the punctuation and between the words, and
+	// the final ";" may be left out
+	lenient bool
+}
+
+func (c *cursor) fail(format string, args ...interface{}) error {
+	return &ParseError{Column: c.pos + 1, Msg: fmt.Sprintf(format, args...), Text: c.text}
+}
+
+func (c *cursor) rest() string {
+	return c.text[c.pos:]
+}
+
+// near shows the text at the cursor on error messages
+func (c *cursor) near() string {
+	rest := c.rest()
+	if rest == "" {
+		return "end of line"
+	}
+	if len(rest) > 12 {
+		rest = rest[:12] + "..."
+	}
+	return strconv.Quote(rest)
+}
+
+func isBlank(ch byte) bool {
+	return ch == ' ' || ch == '\t'
+}
+
+func isWordChar(ch byte) bool {
+	return ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9'
+}
+
+// skipBlanks moves the cursor over the blanks, on lenient lines
+func (c *cursor) skipBlanks() {
+	for c.lenient && c.pos < len(c.text) && isBlank(c.text[c.pos]) {
+		c.pos++
+	}
+}
+
+// accept moves the cursor over the literal, if the text goes on with it
+func (c *cursor) accept(literal string) bool {
+	if !c.lenient {
+		if !strings.HasPrefix(c.rest(), literal) {
+			return false
+		}
+		c.pos += len(literal)
+		return true
+	}
+
+	start := c.pos
+	for i := 0; i < len(literal); i++ {
+		ch := literal[i]
+		if !isWordChar(ch) {
+			c.skipBlanks()
+		}
+		if ch == ' ' {
+			continue
+		}
+		if c.pos >= len(c.text) || c.text[c.pos] != ch {
+			c.pos = start
+			return false
+		}
+		c.pos++
+		if !isWordChar(ch) {
+			c.skipBlanks()
+		}
+	}
+	return true
+}
+
+func (c *cursor) expect(literal string) error {
+	if !c.accept(literal) {
+		return c.fail("expected %q, found %v", literal, c.near())
+	}
+	return nil
+}
+
+// end reads the ";" that ends the line
+func (c *cursor) end() error {
+	if c.lenient {
+		c.accept(";")
+		c.skipBlanks()
+		return nil
+	}
+	return c.expect(";")
+}
+
+func (c *cursor) match(re *regexp.Regexp, what string) (string, error) {
+	c.skipBlanks()
+	if c.lenient && re == nameRe {
+		re = lenientNameRe
+	}
+	value := re.FindString(c.rest())
+	if value == "" {
+		return "", c.fail("expected %v, found %v", what, c.near())
+	}
+	c.pos += len(value)
+	return value, nil
+}
+
+func (c *cursor) number(what string) (uint8, error) {
+	start := c.pos
+	value, err := c.match(numRe, what)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.ParseUint(value, 10, 8)
+	if err != nil {
+		c.pos = start
+		return 0, c.fail("%v %v is out of range", what, value)
+	}
+	return uint8(n), nil
+}
+
+// seconds reads the time of the event of a routing protocol, as "30s"
+func (c *cursor) seconds() (int, error) {
+	start := c.pos
+	value, err := c.match(numRe, "the time")
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		c.pos = start
+		return 0, c.fail("the time %v is out of range", value)
+	}
+	return n, c.expect("s")
+}
+
+// vlan reads a VLAN tag, from 1 to 4094
+func (c *cursor) vlan() (uint16, error) {
+	start := c.pos
+	value, err := c.match(numRe, "the VLAN")
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.ParseUint(value, 10, 16)
+	if err != nil || n == 0 || n > 4094 {
+		c.pos = start
+		return 0, c.fail("the VLAN %v is out of range", value)
+	}
+	return uint16(n), nil
+}
+
+// data reads the payload, that ends right before the suffix at the end of the line
+func (c *cursor) data(suffix string) (string, error) {
+	if c.lenient {
+		end := len(c.text)
+		for i := len(suffix) - 1; i >= 0; i-- {
+			for end > c.pos && isBlank(c.text[end-1]) {
+				end--
+			}
+			switch {
+			case end > c.pos && c.text[end-1] == suffix[i]:
+				end--
+			case suffix[i] != ';':
+				c.pos = end
+				return "", c.fail("expected the line to end with %q", suffix)
+			}
+		}
+		value := strings.TrimRight(c.text[c.pos:end], " \t")
+		c.pos = len(c.text)
+		return value, nil
+	}
+	if !strings.HasSuffix(c.rest(), suffix) {
+		c.pos = len(c.text)
+		return "", c.fail("expected the line to end with %q", suffix)
+	}
+	value := c.text[c.pos : len(c.text)-len(suffix)]
+	c.pos = len(c.text)
+	return value, nil
+}
+
+/*
+----------------------------------------------------
+Line parser
+----------------------------------------------------
+*/
+
+func (c *cursor) eth(ev *event.Event) (err error) {
+	if err = c.expect("ETH (src="); err != nil {
+		return
+	}
+	if ev.SrcMac, err = c.match(macRe, "a MAC address"); err != nil {
+		return
+	}
+	if err = c.expect(" dst="); err != nil {
+		return
+	}
+	if ev.DstMac, err = c.match(macRe, "a MAC address"); err != nil {
+		return
+	}
+	// the VLAN tag, only on tagged frames
+	if c.accept(" vlan=") {
+		if ev.Vlan, err = c.vlan(); err != nil {
+			return
+		}
+	}
+	return c.expect(") \\n ")
+}
+
+func (c *cursor) ip(ev *event.Event) (err error) {
+	if err = c.expect("IP (src="); err != nil {
+		return
+	}
+	if ev.SrcIp, err = c.match(ipRe, "an IP address"); err != nil {
+		return
+	}
+	if err = c.expect(" dst="); err != nil {
+		return
+	}
+	if ev.DstIp, err = c.match(ipRe, "an IP address"); err != nil {
+		return
+	}
+	if err = c.expect(" ttl="); err != nil {
+		return
+	}
+	if ev.Ttl, err = c.number("the TTL"); err != nil {
+		return
+	}
+	if err = c.expect(" mf="); err != nil {
+		return
+	}
+	start := c.pos
+	if ev.Mf, err = c.number("the mf flag"); err != nil {
+		return
+	}
+	if ev.Mf > 1 {
+		c.pos = start
+		return c.fail("the mf flag must be 0 or 1, found %v", ev.Mf)
+	}
+	if err = c.expect(" off="); err != nil {
+		return
+	}
+	if ev.Off, err = c.number("the offset"); err != nil {
+		return
+	}
+	return c.expect(") \\n ")
+}
+
+func (c *cursor) arpRequest(ev *event.Event) (err error) {
+	ev.Kind = event.ARP_REQUEST
+	if err = c.eth(ev); err != nil {
+		return
+	}
+	if err = c.expect("ARP - Who has "); err != nil {
+		return
+	}
+	if ev.DstIp, err = c.match(ipRe, "an IP address"); err != nil {
+		return
+	}
+	if err = c.expect("? Tell "); err != nil {
+		return
+	}
+	if ev.SrcIp, err = c.match(ipRe, "an IP address"); err != nil {
+		return
+	}
+	return c.end()
+}
+
+// switchHop reads the ports of a frame sent by a switch
+func (c *cursor) switchHop(ev *event.Event) (err error) {
+	switch {
+	case c.accept("Forwarded "):
+		ev.Kind = event.SWITCH_FORWARD
+	case c.accept("Flooded "):
+		ev.Kind = event.SWITCH_FLOOD
+	default:
+		return c.fail("expected \"Forwarded\" or \"Flooded\", found %v", c.near())
+	}
+
+	var in, out uint8
+	if err = c.expect("from port "); err != nil {
+		return
+	}
+	if in, err = c.number("the port"); err != nil {
+		return
+	}
+	if err = c.expect(" to port "); err != nil {
+		return
+	}
+	if out, err = c.number("the port"); err != nil {
+		return
+	}
+	ev.Data = fmt.Sprintf("from port %v to port %v", in, out)
+	return c.end()
+}
+
+func (c *cursor) frame(ev *event.Event) (err error) {
+	if err = c.eth(ev); err != nil {
+		return
+	}
+
+	if c.accept("ARP - ") {
+		ev.Kind = event.ARP_REPLY
+		if ev.SrcIp, err = c.match(ipRe, "an IP address"); err != nil {
+			return
+		}
+		if err = c.expect(" is at "); err != nil {
+			return
+		}
+		start := c.pos
+		var mac string
+		if mac, err = c.match(macRe, "a MAC address"); err != nil {
+			return
+		}
+		if !strings.EqualFold(mac, ev.SrcMac) {
+			c.pos = start
+			return c.fail("the ARP reply MAC %v is not the frame source %v", mac, ev.SrcMac)
+		}
+		return c.end()
+	}
+	if c.accept("Switch - ") {
+		return c.switchHop(ev)
+	}
+
+	if err = c.ip(ev); err != nil {
+		return
+	}
+	switch {
+	case c.accept("ICMP - "):
+		return c.icmp(ev)
+	case c.accept("UDP (src=520 dst=520) \\n RIP v2 - Response at "):
+		ev.Kind = event.RIP_RESPONSE
+	case c.accept("OSPF - Hello at "):
+		ev.Kind = event.OSPF_HELLO
+	case c.accept("OSPF - LS Update at "):
+		ev.Kind = event.OSPF_LSU
+	case c.accept("TCP (src=179 dst=179) \\n BGP - Update at "):
+		ev.Kind = event.BGP_UPDATE
+	default:
+		return c.fail("expected \"ICMP\", \"UDP\", \"OSPF\" or \"TCP\", found %v", c.near())
+	}
+	return c.routing(ev)
+}
+
+// icmp reads the ICMP message of a datagram
+func (c *cursor) icmp(ev *event.Event) (err error) {
+	switch {
+	case c.accept("Echo request (data="):
+		ev.Kind = event.ECHO_REQUEST
+		ev.Data, err = c.data(");")
+	case c.accept("Echo reply (data="):
+		ev.Kind = event.ECHO_REPLY
+		ev.Data, err = c.data(");")
+	case c.accept("Time Exceeded"):
+		ev.Kind = event.TIME_EXCEEDED
+		err = c.end()
+	default:
+		err = c.fail("expected \"Echo request\", \"Echo reply\" or \"Time Exceeded\", found %v", c.near())
+	}
+	return
+}
+
+// routing reads the time and the routes of a message of a routing protocol
+func (c *cursor) routing(ev *event.Event) (err error) {
+	if ev.Time, err = c.seconds(); err != nil {
+		return
+	}
+	if err = c.expect(" ("); err != nil {
+		return
+	}
+	ev.Data, err = c.data(");")
+	return
+}
+
+// linkDown reads the port of a router that went down
+func (c *cursor) linkDown(ev *event.Event) (err error) {
+	ev.Kind = event.LINK_DOWN
+	if err = c.expect("Port "); err != nil {
+		return
+	}
+	var port uint8
+	if port, err = c.number("the port"); err != nil {
+		return
+	}
+	ev.Data = fmt.Sprintf("%v", port)
+	if err = c.expect(" down at "); err != nil {
+		return
+	}
+	if ev.Time, err = c.seconds(); err != nil {
+		return
+	}
+	return c.end()
+}
+
+func (c *cursor) line() (ev event.Event, err error) {
+	if ev.Src, err = c.match(nameRe, "the source name"); err != nil {
+		return
+	}
+
+	var arc string
+	switch {
+	case c.accept(" box "):
+		arc = "box"
+	case c.accept(" rbox "):
+		arc = "rbox"
+	case c.accept(" abox "):
+		arc = "abox"
+	case c.accept(" => "):
+		arc = "=>"
+	default:
+		return ev, c.fail("expected \" box \", \" rbox \", \" abox \" or \" => \", found %v", c.near())
+	}
+
+	start := c.pos
+	if ev.Dst, err = c.match(nameRe, "the destination name"); err != nil {
+		return
+	}
+	if arc != "=>" && ev.Dst != ev.Src {
+		c.pos = start
+		return ev, c.fail("a %v must start and end on the same entity, found %v and %v", arc, ev.Src, ev.Dst)
+	}
+	if err = c.expect(" : "); err != nil {
+		return
+	}
+
+	switch arc {
+	case "box":
+		ev.Dst = ""
+		err = c.arpRequest(&ev)
+	case "rbox":
+		ev.Kind = event.RECEIVED
+		if err = c.expect("Received "); err != nil {
+			return
+		}
+		ev.Data, err = c.data(";")
+	case "abox":
+		err = c.linkDown(&ev)
+	default:
+		err = c.frame(&ev)
+	}
+	if err == nil && c.pos != len(c.text) {
+		err = c.fail("unexpected text after the end of the line: %v", c.near())
+	}
+	return
+}
+
+// ParseLine reads a line printed by the simulator into its event
+func ParseLine(line string) (event.Event, error) {
+	c := &cursor{text: line}
+	return c.line()
+}
+
+// ParseLenientLine reads a line as ParseLine, but also when blanks are added or
+// left out around the fields, or the final ";" is left out, as on the output
+// of other simulators
+func ParseLenientLine(line string) (event.Event, error) {
+	c := &cursor{text: line, lenient: true}
+	c.skipBlanks()
+	return c.line()
+}
+
+// Parse reads every line of a transcript. Blank lines are skipped and every
+// malformed line is reported on the returned ParseErrors.
+func Parse(r io.Reader) ([]event.Event, error) {
+	events := make([]event.Event, 0)
+	errs := make(ParseErrors, 0)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		ev, err := ParseLine(line)
+		if err != nil {
+			perr := err.(*ParseError)
+			perr.Line = number
+			errs = append(errs, perr)
+			continue
+		}
+		events = append(events, ev)
+	}
+	if err := scanner.Err(); err != nil {
+		return events, err
+	}
+
+	if len(errs) > 0 {
+		return events, errs
+	}
+	return events, nil
+}
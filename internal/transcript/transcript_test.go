@@ -0,0 +1,196 @@
+package transcript
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/arielril/network-simulator/internal/event"
+)
+
+const GOLDEN_DIR = "../simulator/testdata/golden"
+
+// Every line of the simulator output must be read back into the same line
+func TestParseGolden(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join(GOLDEN_DIR, "*.txt"))
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("No golden files found on %v: %v", GOLDEN_DIR, err)
+	}
+
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		events, err := Parse(f)
+		f.Close()
+		if err != nil {
+			t.Fatalf("Failed to parse %v: %v", path, err)
+		}
+
+		content, _ := os.ReadFile(path)
+		lines := strings.Split(strings.TrimRight(string(content), "\n"), "\n")
+		if len(events) != len(lines) {
+			t.Fatalf("%v: expected %v events, got %v", path, len(lines), len(events))
+		}
+		for i, ev := range events {
+			if ev.String() != lines[i] {
+				t.Errorf("%v:%v:\n  want: %v\n  got:  %v", path, i+1, lines[i], ev.String())
+			}
+		}
+	}
+}
+
+func TestParseLine(t *testing.T) {
+	line := "r1 => n3 : ETH (src=00:00:00:00:00:06 dst=00:00:00:00:00:03) \\n IP (src=210.0.1.1 dst=210.0.3.1 ttl=7 mf=1 off=5) \\n ICMP - Echo request (data=a;b);"
+	ev, err := ParseLine(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := event.Event{
+		Kind: event.ECHO_REQUEST, Src: "r1", Dst: "n3",
+		SrcMac: "00:00:00:00:00:06", DstMac: "00:00:00:00:00:03",
+		SrcIp: "210.0.1.1", DstIp: "210.0.3.1", Ttl: 7, Mf: 1, Off: 5, Data: "a;b",
+	}
+	if ev != want {
+		t.Errorf("want %+v, got %+v", want, ev)
+	}
+}
+
+// Every kind of event printed by the simulator is read back as it was written
+func TestParseRoundTrip(t *testing.T) {
+	eth := event.Event{SrcMac: "00:00:00:00:00:11", DstMac: "00:00:00:00:00:22", SrcIp: "10.0.0.1", DstIp: "10.0.0.2", Ttl: 8}
+	multicast := event.Event{SrcMac: "00:00:00:00:00:11", DstMac: "01:00:5E:00:00:05", SrcIp: "100.0.1.1", DstIp: "224.0.0.5", Ttl: 1}
+	with := func(base event.Event, ev event.Event) event.Event {
+		ev.SrcMac, ev.DstMac, ev.SrcIp, ev.DstIp, ev.Ttl = base.SrcMac, base.DstMac, base.SrcIp, base.DstIp, base.Ttl
+		return ev
+	}
+
+	events := []event.Event{
+		{Kind: event.ARP_REQUEST, Src: "n1", SrcMac: "00:00:00:00:00:11", DstMac: "FF:FF:FF:FF:FF:FF", SrcIp: "10.0.0.2", DstIp: "10.0.0.1"},
+		{Kind: event.ARP_REPLY, Src: "r1", Dst: "n1", SrcMac: "00:00:00:00:00:11", DstMac: "00:00:00:00:00:22", SrcIp: "10.0.0.1", Vlan: 10},
+		with(eth, event.Event{Kind: event.ECHO_REQUEST, Src: "n1", Dst: "r1", Mf: 1, Data: "a;b"}),
+		with(eth, event.Event{Kind: event.ECHO_REPLY, Src: "r1", Dst: "n1", Off: 5, Data: "hi"}),
+		with(eth, event.Event{Kind: event.TIME_EXCEEDED, Src: "r1", Dst: "n1"}),
+		{Kind: event.RECEIVED, Src: "n1", Dst: "n1", Data: "hello world"},
+		with(multicast, event.Event{Kind: event.RIP_RESPONSE, Src: "r1", Dst: "r2", Time: 30, Data: "10.0.0.0/24 metric=1, 20.0.0.0/24 metric=16"}),
+		{Kind: event.LINK_DOWN, Src: "r1", Dst: "r1", Time: 300, Data: "2"},
+		with(multicast, event.Event{Kind: event.OSPF_HELLO, Src: "r1", Dst: "r2", Data: "router=r1 hello=10 dead=40"}),
+		with(multicast, event.Event{Kind: event.OSPF_LSU, Src: "r1", Dst: "r2", Time: 1, Data: "router=r1 seq=2 links=(r2 cost=1)"}),
+		with(eth, event.Event{Kind: event.BGP_UPDATE, Src: "r1", Dst: "r2", Time: 5, Data: "20.0.0.0/24 as_path=65002"}),
+		{Kind: event.SWITCH_FORWARD, Src: "sw1", Dst: "n2", SrcMac: "00:00:00:00:00:01", DstMac: "00:00:00:00:00:02", Data: "from port 0 to port 1"},
+		{Kind: event.SWITCH_FLOOD, Src: "sw1", Dst: "n3", SrcMac: "00:00:00:00:00:01", DstMac: "FF:FF:FF:FF:FF:FF", Vlan: 20, Data: "from port 0 to port 2"},
+	}
+	kinds := make(map[event.Kind]bool)
+	for _, ev := range events {
+		kinds[ev.Kind] = true
+	}
+	for kind := event.ARP_REQUEST; kind <= event.SWITCH_FLOOD; kind++ {
+		if !kinds[kind] {
+			t.Errorf("no event of the kind %v", kind)
+		}
+	}
+
+	var buf bytes.Buffer
+	w := event.Writer{W: &buf}
+	for _, ev := range events {
+		w.Emit(ev)
+	}
+	got, err := Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(events) {
+		t.Fatalf("expected %v events, got %v", len(events), len(got))
+	}
+	for i, want := range events {
+		if got[i] != want {
+			t.Errorf("%v:\n  want %+v\n  got  %+v", want.Kind, want, got[i])
+		}
+		if ev, err := ParseLenientLine(want.String()); err != nil || ev != want {
+			t.Errorf("%v: the lenient parser read %+v, %v", want.Kind, ev, err)
+		}
+	}
+}
+
+// Lenient lines may add or leave out blanks and the final ";"
+func TestParseLenientLine(t *testing.T) {
+	strict := []string{
+		"n1 box n1 : ETH (src=00:00:00:00:00:01 dst=FF:FF:FF:FF:FF:FF) \\n ARP - Who has 1.1.1.1? Tell 1.1.1.2;",
+		"r1 => n3 : ETH (src=00:00:00:00:00:06 dst=00:00:00:00:00:03 vlan=10) \\n IP (src=210.0.1.1 dst=210.0.3.1 ttl=7 mf=1 off=5) \\n ICMP - Echo request (data=a;b);",
+		"sw1 => n2 : ETH (src=00:00:00:00:00:01 dst=00:00:00:00:00:02) \\n Switch - Forwarded from port 0 to port 1;",
+		"n2 rbox n2 : Received hi there;",
+	}
+	lenient := []string{
+		"  n1 box n1: ETH(src = 00:00:00:00:00:01 dst=FF:FF:FF:FF:FF:FF)\\n ARP -Who has 1.1.1.1 ? Tell 1.1.1.2",
+		"r1=>n3 : ETH ( src=00:00:00:00:00:06  dst=00:00:00:00:00:03 vlan =10 ) \\n IP (src=210.0.1.1 dst=210.0.3.1 ttl=7 mf=1 off=5) \\n ICMP - Echo request (data=a;b) ; ",
+		"sw1 => n2 : ETH (src=00:00:00:00:00:01 dst=00:00:00:00:00:02) \\n Switch - Forwarded from port 0 to port 1",
+		"n2 rbox n2 :Received hi there ;",
+	}
+	for i, line := range lenient {
+		want, err := ParseLine(strict[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ParseLine(line); err == nil {
+			t.Errorf("%q: expected the strict parser to fail", line)
+		}
+		got, err := ParseLenientLine(line)
+		if err != nil {
+			t.Errorf("%q: %v", line, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("%q:\n  want %+v\n  got  %+v", line, want, got)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	cases := []struct {
+		line   string
+		column int
+		msg    string
+	}{
+		{"", 1, "expected the source name"},
+		{"n1 -> n2 : ", 3, `expected " box ", " rbox ", " abox " or " => "`},
+		{"n1 box n2 : ETH (src=00:00:00:00:00:01 dst=FF:FF:FF:FF:FF:FF) \\n ARP - Who has 1.1.1.1? Tell 1.1.1.2;", 8, "must start and end on the same entity"},
+		{"n1 => n2 : ETH (src=00:00:00:00:00:1 dst=00:00:00:00:00:02) \\n ARP - 1.1.1.1 is at 00:00:00:00:00:01;", 21, "expected a MAC address"},
+		{"n1 => n2 : ETH (src=00:00:00:00:00:01 dst=00:00:00:00:00:02) \\n ARP - 1.1.1.1 is at 00:00:00:00:00:03;", 85, "is not the frame source"},
+		{"n1 => n2 : ETH (src=00:00:00:00:00:01 dst=00:00:00:00:00:02) \\n IP (src=1.1.1.1 dst=1.1.1.2 ttl=300 mf=0 off=0) \\n ICMP - Echo request (data=hi);", 97, "the TTL 300 is out of range"},
+		{"n1 => n2 : ETH (src=00:00:00:00:00:01 dst=00:00:00:00:00:02) \\n IP (src=1.1.1.1 dst=1.1.1.2 ttl=8 mf=2 off=0) \\n ICMP - Echo request (data=hi);", 102, "the mf flag must be 0 or 1"},
+		{"n1 => n2 : ETH (src=00:00:00:00:00:01 dst=00:00:00:00:00:02) \\n IP (src=1.1.1.1 dst=1.1.1.2 ttl=8 mf=0 off=0) \\n ICMP - Echo (data=hi);", 121, `expected "Echo request", "Echo reply" or "Time Exceeded"`},
+		{"r1 => r2 : ETH (src=00:00:00:00:00:01 dst=01:00:5E:00:00:09) \\n IP (src=1.1.1.1 dst=224.0.0.9 ttl=1 mf=0 off=0) \\n UDP (src=53 dst=53) \\n RIP v2 - Response at 0s (1.1.1.0/24 metric=1);", 116, `expected "ICMP", "UDP", "OSPF" or "TCP"`},
+		{"r1 abox r1 : Port 2 down at now;", 29, "expected the time"},
+		{"n1 => n2 : ETH (src=00:00:00:00:00:01 dst=00:00:00:00:00:02) \\n IP (src=1.1.1.1 dst=1.1.1.2 ttl=8 mf=0 off=0) \\n ICMP - Echo reply (data=hi)", 141, `expected the line to end with ");"`},
+		{"n1 => n2 : ETH (src=00:00:00:00:00:01 dst=00:00:00:00:00:02 vlan=5000) \\n ARP - 1.1.1.1 is at 00:00:00:00:00:01;", 66, "the VLAN 5000 is out of range"},
+		{"n2 rbox n2 : Received hi; extra", 32, `expected the line to end with ";"`},
+		{"n1 => n2 : ETH (src=00:00:00:00:00:01 dst=00:00:00:00:00:02) \\n IP (src=1.1.1.1 dst=1.1.1.2 ttl=8 mf=0 off=0) \\n ICMP - Time Exceeded; x", 135, "unexpected text after the end of the line"},
+	}
+
+	for _, tc := range cases {
+		_, err := ParseLine(tc.line)
+		perr, ok := err.(*ParseError)
+		if !ok {
+			t.Errorf("%q: expected a ParseError, got %v", tc.line, err)
+			continue
+		}
+		if perr.Column != tc.column || !strings.Contains(perr.Msg, tc.msg) {
+			t.Errorf("%q:\n  want column %v: %v\n  got  column %v: %v", tc.line, tc.column, tc.msg, perr.Column, perr.Msg)
+		}
+	}
+}
+
+func TestParseReportsLines(t *testing.T) {
+	text := "n1 rbox n1 : Received hi;\n\nbroken\nn2 rbox n2 : Received hi;\n"
+	events, err := Parse(strings.NewReader(text))
+	if len(events) != 2 {
+		t.Errorf("expected 2 events, got %v", len(events))
+	}
+	errs, ok := err.(ParseErrors)
+	if !ok || len(errs) != 1 || errs[0].Line != 3 {
+		t.Fatalf("expected a single error on line 3, got %v", err)
+	}
+}
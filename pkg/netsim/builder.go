@@ -0,0 +1,230 @@
+package netsim
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/arielril/network-simulator/internal/simulator"
+)
+
+// Builder declares the devices of a topology. The first invalid declaration is
+// kept and returned by Build, so calls can be chained without checking errors.
+type Builder struct {
+	env   simulator.Environment
+	names map[string]bool
+	err   error
+}
+
+// RouterBuilder declares the ports and routes of a router
+type RouterBuilder struct {
+	builder *Builder
+	name    string
+	router  simulator.Router
+	ports   int
+}
+
+// NewBuilder creates a builder of an empty topology
+func NewBuilder() *Builder {
+	return &Builder{
+		env:   simulator.NewEnvironment(),
+		names: make(map[string]bool),
+	}
+}
+
+func (b *Builder) fail(format string, args ...interface{}) {
+	if b.err == nil {
+		b.err = fmt.Errorf(format, args...)
+	}
+}
+
+func (b *Builder) declare(name string) bool {
+	key := strings.ToLower(name)
+	switch {
+	case name == "" || strings.ContainsAny(name, ", \t\n#"):
+		b.fail("Invalid device name %q", name)
+	case b.names[key]:
+		b.fail("Device %v is declared more than once", name)
+	default:
+		b.names[key] = true
+		return true
+	}
+	return false
+}
+
+func parseMac(mac string) (simulator.MAC, error) {
+	hw, err := net.ParseMAC(mac)
+	if err != nil || len(hw) != 6 {
+		return "", fmt.Errorf("Invalid MAC address %q", mac)
+	}
+	return simulator.MAC(strings.ToUpper(hw.String())), nil
+}
+
+// parseInterface validates an IPv4 address with its prefix, as 10.0.0.1/24
+func parseInterface(ip string) error {
+	addr, _, err := net.ParseCIDR(ip)
+	if err != nil || addr.To4() == nil {
+		return fmt.Errorf("Invalid IP address %q, expected an IPv4 address with prefix", ip)
+	}
+	return nil
+}
+
+func parseAddress(ip string) error {
+	addr := net.ParseIP(ip)
+	if addr == nil || addr.To4() == nil {
+		return fmt.Errorf("Invalid IP address %q", ip)
+	}
+	return nil
+}
+
+func parseMtu(mtu int) (simulator.MTU, error) {
+	if mtu < 1 || mtu > 255 {
+		return 0, fmt.Errorf("Invalid MTU %v, expected a value between 1 and 255", mtu)
+	}
+	return simulator.MTU(mtu), nil
+}
+
+// Node declares a node with its interface (MAC, IP/prefix and MTU) and default
+// gateway, in the same order of the topology file
+func (b *Builder) Node(name, mac, ip string, mtu int, gateway string) *Builder {
+	if !b.declare(name) {
+		return b
+	}
+
+	hw, err := parseMac(mac)
+	if err == nil {
+		err = parseInterface(ip)
+	}
+	var size simulator.MTU
+	if err == nil {
+		size, err = parseMtu(mtu)
+	}
+	if err == nil {
+		err = parseAddress(gateway)
+	}
+	if err != nil {
+		b.fail("Node %v: %v", name, err)
+		return b
+	}
+
+	b.env.AddNode(simulator.NewNode(name, ip, gateway, hw, size))
+	return b
+}
+
+// Router declares a router. Its ports are numbered from 0, in the order they
+// are added.
+func (b *Builder) Router(name string) *RouterBuilder {
+	rt := simulator.NewRouter(name)
+	if b.declare(name) {
+		b.env.AddRouter(rt)
+	}
+	return &RouterBuilder{builder: b, name: name, router: rt}
+}
+
+// Port adds a port with its interface (MAC, IP/prefix and MTU) to the router
+func (r *RouterBuilder) Port(mac, ip string, mtu int) *RouterBuilder {
+	hw, err := parseMac(mac)
+	if err == nil {
+		err = parseInterface(ip)
+	}
+	var size simulator.MTU
+	if err == nil {
+		size, err = parseMtu(mtu)
+	}
+	if err != nil {
+		r.builder.fail("Router %v, port %v: %v", r.name, r.ports, err)
+		return r
+	}
+
+	r.router.AddPort(*simulator.NewRouterPort(uint8(r.ports), ip, hw, size))
+	r.ports++
+	return r
+}
+
+// Route adds an entry to the router table. The networks of the ports already
+// have connected routes.
+func (r *RouterBuilder) Route(netdest, nexthop string, port int) *RouterBuilder {
+	err := parseInterface(netdest)
+	if err == nil {
+		err = parseAddress(nexthop)
+	}
+	if err == nil && (port < 0 || port >= r.ports) {
+		err = fmt.Errorf("Unknown port %v", port)
+	}
+	if err != nil {
+		r.builder.fail("Router %v, route to %v: %v", r.name, netdest, err)
+		return r
+	}
+
+	r.router.AddRouterTableEntry(simulator.NewRouterTableEntry(netdest, nexthop, uint8(port)))
+	return r
+}
+
+// Rip runs RIP v2 on the ports of the router, or on all of its ports when none
+// are given. The routes are learned before the first ping.
+func (r *RouterBuilder) Rip(ports ...int) *RouterBuilder {
+	numbers := make([]uint8, 0, len(ports))
+	for _, port := range ports {
+		if port < 0 || port >= r.ports {
+			r.builder.fail("Router %v, RIP: Unknown port %v", r.name, port)
+			return r
+		}
+		numbers = append(numbers, uint8(port))
+	}
+	if err := r.builder.env.EnableRip(r.name, numbers); err != nil {
+		r.builder.fail("Router %v, RIP: %v", r.name, err)
+	}
+	return r
+}
+
+// Ospf runs OSPF on the ports of the router with their costs, or on all of its
+// ports with cost 1 when none are given. The routes are learned before the first ping.
+func (r *RouterBuilder) Ospf(costs map[int]int) *RouterBuilder {
+	numbers := make(map[uint8]uint32, len(costs))
+	for port, cost := range costs {
+		if port < 0 || port >= r.ports {
+			r.builder.fail("Router %v, OSPF: Unknown port %v", r.name, port)
+			return r
+		}
+		if cost < 1 || cost > int(simulator.OSPF_MAX_COST) {
+			r.builder.fail("Router %v, OSPF: Invalid cost %v of port %v", r.name, cost, port)
+			return r
+		}
+		numbers[uint8(port)] = uint32(cost)
+	}
+	if err := r.builder.env.EnableOspf(r.name, numbers); err != nil {
+		r.builder.fail("Router %v, OSPF: %v", r.name, err)
+	}
+	return r
+}
+
+// Bgp runs BGP on the router, as part of the autonomous system, announcing the
+// networks. The sessions are added with BgpNeighbor.
+func (r *RouterBuilder) Bgp(asn uint32, networks ...string) *RouterBuilder {
+	if err := r.builder.env.EnableBgp(r.name, asn, networks); err != nil {
+		r.builder.fail("Router %v, BGP: %v", r.name, err)
+	}
+	return r
+}
+
+// BgpNeighbor adds a BGP session with the peer address, which must also be
+// declared on the peer, with its import and export policy
+func (r *RouterBuilder) BgpNeighbor(peerIp string, policy BgpPolicy) *RouterBuilder {
+	if err := r.builder.env.AddBgpNeighbor(r.name, peerIp, policy); err != nil {
+		r.builder.fail("Router %v, BGP neighbor %v: %v", r.name, peerIp, err)
+	}
+	return r
+}
+
+// Done returns to the topology builder, to declare the next device
+func (r *RouterBuilder) Done() *Builder {
+	return r.builder
+}
+
+// Build returns the network declared, or the first invalid declaration
+func (b *Builder) Build() (*Network, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	return newNetwork(b.env), nil
+}
@@ -0,0 +1,151 @@
+// Package netsim builds network topologies and simulates pings over them, with
+// every ARP and ICMP frame reported as an event. Nothing is printed: the
+// events are returned to the caller or sent to a Sink while the simulation
+// runs.
+package netsim
+
+import (
+	"context"
+	"io"
+
+	"github.com/arielril/network-simulator/internal/event"
+	"github.com/arielril/network-simulator/internal/simulator"
+)
+
+// Event is a frame sent or a message processed during the simulation. Its
+// String method returns the line printed by the simulador command.
+type Event = event.Event
+
+// Kind tells what happened on an event
+type Kind = event.Kind
+
+// Sink receives the events while the simulation runs
+type Sink = event.Sink
+
+// BgpPolicy is the import and export policy of a BGP session
+type BgpPolicy = simulator.BgpPolicy
+
+// Kinds of events
+const (
+	ARP_REQUEST    = event.ARP_REQUEST
+	ARP_REPLY      = event.ARP_REPLY
+	ECHO_REQUEST   = event.ECHO_REQUEST
+	ECHO_REPLY     = event.ECHO_REPLY
+	TIME_EXCEEDED  = event.TIME_EXCEEDED
+	RECEIVED       = event.RECEIVED
+	SWITCH_FORWARD = event.SWITCH_FORWARD
+	SWITCH_FLOOD   = event.SWITCH_FLOOD
+)
+
+// Network is a topology ready to be simulated. The ARP tables of the devices
+// are kept between pings, like on a real network, so a Network must not be
+// used by concurrent pings: each goroutine must ping on its own Clone.
+type Network struct {
+	env simulator.Environment
+}
+
+func newNetwork(env simulator.Environment) *Network {
+	env.SetSink(&event.Recorder{})
+	return &Network{env: env}
+}
+
+// Load reads a topology file, on the format of the simulador command
+func Load(path string) (*Network, error) {
+	env, err := simulator.LoadEnvironment(path)
+	if err != nil {
+		return nil, err
+	}
+	return newNetwork(env), nil
+}
+
+// Clone returns a copy of the network, with its own devices and ARP tables,
+// that can be pinged at the same time as the original
+func (n *Network) Clone() *Network {
+	return newNetwork(n.env.Clone())
+}
+
+// WriteTopology writes the network on the topology file format, so a network
+// declared with the builder can be loaded by the simulador command
+func (n *Network) WriteTopology(w io.Writer) error {
+	return n.env.WriteTopology(w)
+}
+
+// Names returns the name of every node, router and switch, in the order they were declared
+func (n *Network) Names() []string {
+	return n.env.GetNames()
+}
+
+// PingOptions changes how a ping is simulated. The zero value is valid.
+type PingOptions struct {
+	// Also receives every event, as soon as it happens
+	Sink Sink
+}
+
+// Result describes what happened to a ping
+type Result struct {
+	// Every frame sent and message processed, in order
+	Events []Event
+	// The destination received the echo request
+	Delivered bool
+	// The source received the echo reply
+	Replied bool
+	// A router discarded the packet because its TTL reached 0
+	TimeExceeded bool
+}
+
+// collector records the events of a ping and forwards them to the sink of the options
+type collector struct {
+	events []Event
+	sink   Sink
+}
+
+func (c *collector) Emit(ev Event) {
+	c.events = append(c.events, ev)
+	if c.sink != nil {
+		c.sink.Emit(ev)
+	}
+}
+
+// Ping sends the payload from the source node to the destination node and
+// waits for the reply. When the context is done the simulation stops sending
+// frames, and the events up to that point are returned with the context error.
+// The Result is also returned with the simulation errors, such as a missing
+// route, with the frames sent before the failure; it is only nil when the
+// context is done before the ping starts.
+func (n *Network) Ping(ctx context.Context, src, dst, payload string, opts PingOptions) (*Result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	c := &collector{events: make([]Event, 0), sink: opts.Sink}
+	n.env.SetSink(c)
+	n.env.SetContext(ctx)
+	defer func() {
+		n.env.SetSink(&event.Recorder{})
+		n.env.SetContext(context.Background())
+	}()
+
+	err := simulator.Ping(n.env, src, dst, payload)
+	srcName, dstName := n.declaredName(src), n.declaredName(dst)
+	result := &Result{Events: c.events}
+	for _, ev := range c.events {
+		switch {
+		case ev.Kind == TIME_EXCEEDED:
+			result.TimeExceeded = true
+		case ev.Kind == RECEIVED && ev.Src == dstName:
+			result.Delivered = true
+		case ev.Kind == RECEIVED && ev.Src == srcName:
+			result.Replied = true
+		}
+	}
+	return result, err
+}
+
+// declaredName returns the name of the device as it was declared, which is the
+// one written on the events
+func (n *Network) declaredName(name string) string {
+	if c := n.env.GetNetComponentByName(name); c != nil {
+		return c.GetName()
+	}
+	return name
+}
@@ -0,0 +1,211 @@
+package netsim
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/arielril/network-simulator/internal/simulator"
+)
+
+// example1 is examples/example1.txt declared with the builder
+func example1() *Builder {
+	b := NewBuilder().
+		Node("n1", "00:00:00:00:00:01", "192.168.0.2/24", 5, "192.168.0.1").
+		Node("n2", "00:00:00:00:00:02", "192.168.0.3/24", 5, "192.168.0.1").
+		Node("n3", "00:00:00:00:00:03", "192.168.1.2/24", 5, "192.168.1.1").
+		Node("n4", "00:00:00:00:00:04", "192.168.1.3/24", 5, "192.168.1.1")
+	b.Router("r1").
+		Port("00:00:00:00:00:05", "192.168.0.1/24", 5).
+		Port("00:00:00:00:00:06", "192.168.1.1/24", 5)
+	return b
+}
+
+func lines(events []Event) string {
+	text := make([]string, len(events))
+	for i, ev := range events {
+		text[i] = ev.String()
+	}
+	return strings.Join(text, "\n") + "\n"
+}
+
+func TestBuilderPing(t *testing.T) {
+	want, err := os.ReadFile("../../internal/simulator/testdata/golden/example1_n1_n4_hello.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	network, err := example1().Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := network.Ping(context.Background(), "n1", "n4", "hello", PingOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := lines(result.Events); got != string(want) {
+		t.Errorf("want:\n%v\ngot:\n%v", string(want), got)
+	}
+	if !result.Delivered || !result.Replied || result.TimeExceeded {
+		t.Errorf("unexpected result %+v", result)
+	}
+}
+
+func TestLoadPing(t *testing.T) {
+	network, err := Load("../../examples/example3.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var streamed []Event
+	sink := sinkFunc(func(ev Event) { streamed = append(streamed, ev) })
+	result, err := network.Ping(context.Background(), "n1", "n4", "hello", PingOptions{Sink: sink})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.TimeExceeded || !result.Delivered || result.Replied {
+		t.Errorf("expected the reply to end on Time Exceeded, got %+v", result)
+	}
+	if len(streamed) != len(result.Events) {
+		t.Errorf("the sink received %v events, expected %v", len(streamed), len(result.Events))
+	}
+}
+
+type sinkFunc func(ev Event)
+
+func (f sinkFunc) Emit(ev Event) {
+	f(ev)
+}
+
+func TestPingErrors(t *testing.T) {
+	network, err := example1().Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := network.Ping(context.Background(), "r1", "n4", "hello", PingOptions{}); err == nil {
+		t.Error("expected an error when the source is a router")
+	}
+	if _, err := network.Ping(context.Background(), "n1", "n9", "hello", PingOptions{}); err == nil {
+		t.Error("expected an error when the destination does not exist")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := network.Ping(ctx, "n1", "n4", "hello", PingOptions{}); err != context.Canceled {
+		t.Errorf("expected the context error, got %v", err)
+	}
+}
+
+// The frames sent before a simulation error are returned with it
+func TestPingPartialResult(t *testing.T) {
+	b := NewBuilder().
+		Node("n1", "00:00:00:00:00:01", "192.168.0.2/24", 5, "192.168.0.1").
+		Node("n4", "00:00:00:00:00:04", "192.168.1.3/24", 5, "192.168.1.1")
+	b.Router("r1").Port("00:00:00:00:00:05", "192.168.0.1/24", 5)
+	network, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := network.Ping(context.Background(), "n1", "n4", "hello", PingOptions{})
+	var routeErr *simulator.NoRouteError
+	if !errors.As(err, &routeErr) {
+		t.Fatalf("expected no route from r1, got %v", err)
+	}
+	if result == nil || len(result.Events) != 3 || result.Events[2].Kind != ECHO_REQUEST || result.Delivered {
+		t.Errorf("expected the ARP resolution and the echo request to r1, got %+v", result)
+	}
+}
+
+// The names of the ping are matched to the devices as they were declared
+func TestPingDeclaredNames(t *testing.T) {
+	network, err := example1().Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := network.Ping(context.Background(), "N1", "N4", "hello", PingOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Delivered || !result.Replied {
+		t.Errorf("expected the ping to be delivered and replied, got %+v", result)
+	}
+}
+
+func TestBuilderErrors(t *testing.T) {
+	cases := []struct {
+		name  string
+		build func(b *Builder)
+		msg   string
+	}{
+		{"duplicated name", func(b *Builder) {
+			b.Node("N1", "00:00:00:00:00:09", "10.0.0.2/24", 5, "10.0.0.1")
+		}, "declared more than once"},
+		{"invalid MAC", func(b *Builder) {
+			b.Node("n9", "00:00:00:00:09", "10.0.0.2/24", 5, "10.0.0.1")
+		}, "Invalid MAC address"},
+		{"IP without prefix", func(b *Builder) {
+			b.Node("n9", "00:00:00:00:00:09", "10.0.0.2", 5, "10.0.0.1")
+		}, "Invalid IP address"},
+		{"invalid MTU", func(b *Builder) {
+			b.Router("r9").Port("00:00:00:00:00:09", "10.0.0.1/24", 0)
+		}, "Invalid MTU"},
+		{"unknown port", func(b *Builder) {
+			b.Router("r9").Port("00:00:00:00:00:09", "10.0.0.1/24", 5).Route("10.0.0.0/24", "0.0.0.0", 1)
+		}, "Unknown port 1"},
+	}
+
+	for _, tc := range cases {
+		b := example1()
+		tc.build(b)
+		if _, err := b.Build(); err == nil || !strings.Contains(err.Error(), tc.msg) {
+			t.Errorf("%v: expected an error with %q, got %v", tc.name, tc.msg, err)
+		}
+	}
+}
+
+func TestClonePing(t *testing.T) {
+	network, err := example1().Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clones := []*Network{network.Clone(), network.Clone()}
+	done := make(chan *Result, len(clones))
+	for _, clone := range clones {
+		go func(n *Network) {
+			result, err := n.Ping(context.Background(), "n1", "n3", "hello", PingOptions{})
+			if err != nil {
+				t.Error(err)
+			}
+			done <- result
+		}(clone)
+	}
+	for range clones {
+		if result := <-done; result == nil || !result.Replied {
+			t.Errorf("Expected the clones to reply, got %+v", result)
+		}
+	}
+}
+
+func TestWriteTopology(t *testing.T) {
+	want, err := os.ReadFile("../../examples/example1.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	network, err := example1().Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var written strings.Builder
+	if err := network.WriteTopology(&written); err != nil {
+		t.Fatal(err)
+	}
+	if written.String() != string(want) {
+		t.Errorf("want:\n%v\ngot:\n%v", string(want), written.String())
+	}
+}